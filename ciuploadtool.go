@@ -1,13 +1,41 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/d1vanov/ciuploadtool/uploader"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "promote":
+			promote(os.Args[2:])
+			return
+		case "download":
+			download(os.Args[2:])
+			return
+		case "finalize":
+			finalize(os.Args[2:])
+			return
+		case "apply-patch":
+			applyPatch(os.Args[2:])
+			return
+		case "verify-provenance":
+			verifyProvenance(os.Args[2:])
+			return
+		case "label":
+			label(os.Args[2:])
+			return
+		}
+	}
+
 	var releaseSuffix string
 	flag.StringVar(
 		&releaseSuffix,
@@ -29,6 +57,194 @@ func main() {
 		false,
 		"Specify this flag to only prepare the release but not upload binaries")
 
+	var draft bool
+	flag.BoolVar(
+		&draft,
+		"draft",
+		false,
+		"Create the release as a draft to be published once all binaries "+
+			"are uploaded")
+
+	var expectedAssets string
+	flag.StringVar(
+		&expectedAssets,
+		"expected-assets",
+		"",
+		"Optional comma separated list of binaries which have to be uploaded "+
+			"before the draft release is published")
+
+	var prune bool
+	flag.BoolVar(
+		&prune,
+		"prune",
+		false,
+		"Delete the binaries no job of the current build has uploaded once "+
+			"all expected assets are uploaded")
+
+	var updateTag bool
+	flag.BoolVar(
+		&updateTag,
+		"update-tag",
+		false,
+		"Move the existing continuous tag to the current commit and reuse "+
+			"the existing release instead of recreating both")
+
+	var verify bool
+	flag.BoolVar(
+		&verify,
+		"verify",
+		false,
+		"Verify each uploaded binary by downloading it back and comparing "+
+			"its SHA-256 with the local one, re-upload it on mismatch")
+
+	var allowEmpty bool
+	flag.BoolVar(
+		&allowEmpty,
+		"allow-empty",
+		false,
+		"Don't fail if a glob pattern among the files to upload matches "+
+			"nothing")
+
+	var archive string
+	flag.StringVar(
+		&archive,
+		"archive",
+		"",
+		"Optional format of archives the dirs given among the files to upload "+
+			"are packed into: zip, tar.gz or tar.xz")
+
+	var recursive bool
+	flag.BoolVar(
+		&recursive,
+		"recursive",
+		false,
+		"Upload the files within the dirs given among the files to upload")
+
+	var include stringListFlag
+	flag.Var(
+		&include,
+		"include",
+		"Optional glob for files within the dirs to upload, \"**\" matches "+
+			"any number of path components; may be given several times")
+
+	var exclude stringListFlag
+	flag.Var(
+		&exclude,
+		"exclude",
+		"Optional glob for files within the dirs not to upload; may be given "+
+			"several times")
+
+	var nameSeparator string
+	flag.StringVar(
+		&nameSeparator,
+		"name-separator",
+		"",
+		"Optional separator joining the path components of files within "+
+			"the uploaded dirs into binary names, by default the base names "+
+			"of files are used")
+
+	var nameTemplate string
+	flag.StringVar(
+		&nameTemplate,
+		"name-template",
+		"",
+		"Optional template of binary names, i.e. "+
+			"{{.Stem}}-{{.ShortCommit}}-{{.Platform}}{{.Ext}}")
+
+	var jobNamespace string
+	flag.StringVar(
+		&jobNamespace,
+		"job-namespace",
+		"",
+		"Optional placement of the build job key within binary names, "+
+			"prefix or suffix")
+
+	var jobKey string
+	flag.StringVar(
+		&jobKey,
+		"job-key",
+		"",
+		"Optional key of the build job, by default made of the OS, "+
+			"architecture and configuration of the job")
+
+	var splitSize string
+	flag.StringVar(
+		&splitSize,
+		"split-size",
+		"",
+		"Optional size, i.e. 1900M, above which binaries are uploaded as "+
+			"parts along with the manifest of their checksums")
+
+	var deltaPatches bool
+	flag.BoolVar(
+		&deltaPatches,
+		"delta-patches",
+		false,
+		"Upload delta patches from the binaries of the previous continuous "+
+			"build replaced by the uploaded ones")
+
+	var zsync bool
+	flag.BoolVar(
+		&zsync,
+		"zsync",
+		false,
+		"Upload zsync files for delta updates next to the uploaded AppImages")
+
+	var sbom bool
+	flag.BoolVar(
+		&sbom,
+		"sbom",
+		false,
+		"Upload SPDX SBOM describing the uploaded binaries, merged with "+
+			"the binaries uploaded to the same release by other build jobs")
+
+	var provenance bool
+	flag.BoolVar(
+		&provenance,
+		"provenance",
+		false,
+		"Upload in-toto provenance of the uploaded binaries signed with "+
+			"ed25519 private key")
+
+	var provenanceKeyEnv string
+	flag.StringVar(
+		&provenanceKeyEnv,
+		"provenance-key-env",
+		"",
+		"Optional environment variable with ed25519 private key signing "+
+			"the provenance, PROVENANCE_PRIVATE_KEY by default")
+
+	var stdinName string
+	flag.StringVar(
+		&stdinName,
+		"stdin-name",
+		"",
+		"Name of the binary read from stdin, required if \"-\" is given "+
+			"among the files to upload")
+
+	var configFilename string
+	flag.StringVar(
+		&configFilename,
+		"config",
+		"",
+		"Optional JSON config file with the settings of the update feed, "+
+			"Sparkle appcast, package manifests and labels of binaries")
+
+	var timeout time.Duration
+	flag.DurationVar(
+		&timeout,
+		"timeout",
+		0,
+		"Optional limit for the total duration of the run, i.e. 30m")
+
+	var uploadTimeout time.Duration
+	flag.DurationVar(
+		&uploadTimeout,
+		"upload-timeout",
+		0,
+		"Optional limit for the duration of the upload of each binary, "+
+			"i.e. 10m")
+
 	var verbose bool
 	flag.BoolVar(
 		&verbose,
@@ -41,23 +257,503 @@ func main() {
 	if !prepareOnly && flag.NArg() < 1 {
 		fmt.Printf(
 			"Usage: %s [-suffix=<suffix for continuous release names>] "+
-				"[-relbody=<release body message>] [-preponly] [-verbose] "+
-				"<files to upload>\n",
-			os.Args[0])
+				"[-relbody=<release body message>] [-preponly] [-draft] "+
+				"[-expected-assets=<comma separated asset names>] [-prune] "+
+				"[-update-tag] "+
+				"[-verify] [-allow-empty] [-archive=zip|tar.gz|tar.xz] "+
+				"[-recursive] [-include=<glob>] "+
+				"[-exclude=<glob>] "+
+				"[-name-separator=<separator>] [-name-template=<template>] "+
+				"[-job-namespace=prefix|suffix] [-job-key=<key>] "+
+				"[-split-size=<size>] "+
+				"[-delta-patches] [-zsync] [-sbom] "+
+				"[-provenance] [-provenance-key-env=<env var>] "+
+				"[-stdin-name=<name of binary read from stdin>] "+
+				"[-config=<config file>] [-timeout=<duration>] [-upload-timeout=<duration>] "+
+				"[-verbose] <files to upload or - for stdin>\n"+
+				"       %s finalize [-suffix=<suffix for continuous release "+
+				"names>] [-prune] [-expected-assets=<comma separated asset "+
+				"names>] [-config=<config file>] [-timeout=<duration>] "+
+				"[-verbose]\n"+
+				"       %s promote -from=<continuous release tag> "+
+				"-version=<version tag> [-relbody=<release body message>] "+
+				"[-repo=<owner/repo>] [-timeout=<duration>] [-verbose]\n"+
+				"       %s download -tag=<release tag> "+
+				"[-pattern=<asset name pattern>] [-out=<output dir>] "+
+				"[-repo=<owner/repo>] [-timeout=<duration>] [-verbose]\n"+
+				"       %s apply-patch -old=<old binary> -patch=<patch> "+
+				"-out=<new binary>\n"+
+				"       %s verify-provenance -provenance=<provenance> "+
+				"-key=<public key file> [files to verify]\n"+
+				"       %s label -tag=<release tag> -config=<config file> "+
+				"[-repo=<owner/repo>] [-timeout=<duration>] [-verbose]\n",
+			os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0],
+			os.Args[0], os.Args[0])
 		os.Exit(-1)
 	}
 
+	var splitSizeBytes int64
+	if len(splitSize) != 0 {
+		var err error
+		splitSizeBytes, err = uploader.ParseSize(splitSize)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}
+
+	config := &uploader.Config{}
+	if len(configFilename) != 0 {
+		var err error
+		config, err = uploader.LoadConfig(configFilename)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}
+
+	var expectedAssetList []string
+	if len(expectedAssets) != 0 {
+		expectedAssetList = strings.Split(expectedAssets, ",")
+	}
+
+	options := uploader.Options{
+		ReleaseSuffix:      releaseSuffix,
+		ReleaseBody:        releaseBody,
+		Draft:              draft,
+		ExpectedAssets:     expectedAssetList,
+		UpdateTag:          updateTag,
+		Prune:              prune,
+		Verify:             verify,
+		AllowEmpty:         allowEmpty,
+		Archive:            archive,
+		Recursive:          recursive,
+		Include:            include,
+		Exclude:            exclude,
+		AssetNameSeparator: nameSeparator,
+		NameTemplate:       nameTemplate,
+		JobNamespace:       jobNamespace,
+		JobKey:             jobKey,
+		SplitSize:          splitSizeBytes,
+		DeltaPatches:       deltaPatches,
+		Zsync:              zsync,
+		SBOM:               sbom,
+		Provenance:         provenance,
+		ProvenanceKeyEnv:   provenanceKeyEnv,
+		StdinName:          stdinName,
+		UpdateFeed:         config.UpdateFeed,
+		Appcast:            config.Appcast,
+		Scoop:              config.Scoop,
+		Homebrew:           config.Homebrew,
+		Assets:             config.Assets,
+		UploadTimeout:      uploadTimeout,
+		Verbose:            verbose,
+	}
+
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
 	var err error
 	if prepareOnly {
 		fmt.Println("Prepare only flag is active, won't upload any real " +
 			"binaries, will just prepare the release")
-		err = uploader.Upload([]string{}, releaseSuffix, releaseBody, verbose)
+		err = uploader.Upload(ctx, []string{}, options)
 	} else {
-		err = uploader.Upload(flag.Args(), releaseSuffix, releaseBody, verbose)
+		err = uploader.Upload(ctx, flag.Args(), options)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+func promote(args []string) {
+	flags := flag.NewFlagSet("promote", flag.ExitOnError)
+
+	var sourceTag string
+	flags.StringVar(
+		&sourceTag,
+		"from",
+		"",
+		"Tag of the continuous release to promote, i.e. continuous-master")
+
+	var version string
+	flags.StringVar(
+		&version,
+		"version",
+		"",
+		"Version tag of the stable release to create, i.e. v2.3.0")
+
+	var releaseBody string
+	flags.StringVar(
+		&releaseBody,
+		"relbody",
+		"",
+		"Optional content for body of created release, by default the body "+
+			"of the promoted release is used")
+
+	var repoSlug string
+	flags.StringVar(
+		&repoSlug,
+		"repo",
+		"",
+		"Optional owner/repo slug, by default it is taken from CI environment")
+
+	var timeout time.Duration
+	flags.DurationVar(
+		&timeout,
+		"timeout",
+		0,
+		"Optional limit for the total duration of the run, i.e. 30m")
+
+	var verbose bool
+	flags.BoolVar(
+		&verbose,
+		"verbose",
+		false,
+		"Enable verbose output")
+
+	flags.Parse(args)
+
+	if len(sourceTag) == 0 || len(version) == 0 {
+		fmt.Printf(
+			"Usage: %s promote -from=<continuous release tag> "+
+				"-version=<version tag> [-relbody=<release body message>] "+
+				"[-repo=<owner/repo>] [-timeout=<duration>] [-verbose]\n",
+			os.Args[0])
+		os.Exit(-1)
 	}
 
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	err := uploader.Promote(
+		ctx, sourceTag, version, releaseBody, repoSlug, verbose)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(-1)
 	}
 }
+
+func download(args []string) {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+
+	var tag string
+	flags.StringVar(
+		&tag,
+		"tag",
+		"",
+		"Tag of the release to download assets from")
+
+	var pattern string
+	flags.StringVar(
+		&pattern,
+		"pattern",
+		"*",
+		"Optional pattern for names of release assets to download")
+
+	var outDir string
+	flags.StringVar(
+		&outDir,
+		"out",
+		"",
+		"Optional dir to save the downloaded assets to, by default the "+
+			"current dir is used")
+
+	var repoSlug string
+	flags.StringVar(
+		&repoSlug,
+		"repo",
+		"",
+		"Optional owner/repo slug, by default it is taken from CI environment")
+
+	var timeout time.Duration
+	flags.DurationVar(
+		&timeout,
+		"timeout",
+		0,
+		"Optional limit for the total duration of the run, i.e. 30m")
+
+	var verbose bool
+	flags.BoolVar(
+		&verbose,
+		"verbose",
+		false,
+		"Enable verbose output")
+
+	flags.Parse(args)
+
+	if len(tag) == 0 {
+		fmt.Printf(
+			"Usage: %s download -tag=<release tag> "+
+				"[-pattern=<asset name pattern>] [-out=<output dir>] "+
+				"[-repo=<owner/repo>] [-timeout=<duration>] [-verbose]\n",
+			os.Args[0])
+		os.Exit(-1)
+	}
+
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	err := uploader.Download(ctx, tag, pattern, outDir, repoSlug, verbose)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+func label(args []string) {
+	flags := flag.NewFlagSet("label", flag.ExitOnError)
+
+	var tag string
+	flags.StringVar(
+		&tag,
+		"tag",
+		"",
+		"Tag of the release to update labels of assets of")
+
+	var configFilename string
+	flags.StringVar(
+		&configFilename,
+		"config",
+		"",
+		"JSON config file with the label templates of binaries")
+
+	var repoSlug string
+	flags.StringVar(
+		&repoSlug,
+		"repo",
+		"",
+		"Optional owner/repo slug, by default it is taken from CI environment")
+
+	var timeout time.Duration
+	flags.DurationVar(
+		&timeout,
+		"timeout",
+		0,
+		"Optional limit for the total duration of the run, i.e. 30m")
+
+	var verbose bool
+	flags.BoolVar(
+		&verbose,
+		"verbose",
+		false,
+		"Enable verbose output")
+
+	flags.Parse(args)
+
+	if len(tag) == 0 || len(configFilename) == 0 {
+		fmt.Printf(
+			"Usage: %s label -tag=<release tag> -config=<config file> "+
+				"[-repo=<owner/repo>] [-timeout=<duration>] [-verbose]\n",
+			os.Args[0])
+		os.Exit(-1)
+	}
+
+	config, err := uploader.LoadConfig(configFilename)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	err = uploader.UpdateLabels(ctx, tag, config.Assets, repoSlug, verbose)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+func applyPatch(args []string) {
+	flags := flag.NewFlagSet("apply-patch", flag.ExitOnError)
+
+	var oldFilename string
+	flags.StringVar(
+		&oldFilename,
+		"old",
+		"",
+		"Binary of the previous build the patch was computed against")
+
+	var patchFilename string
+	flags.StringVar(
+		&patchFilename,
+		"patch",
+		"",
+		"Delta patch downloaded from the release")
+
+	var newFilename string
+	flags.StringVar(
+		&newFilename,
+		"out",
+		"",
+		"File to write the binary of the new build to")
+
+	flags.Parse(args)
+
+	if len(oldFilename) == 0 || len(patchFilename) == 0 ||
+		len(newFilename) == 0 {
+		fmt.Printf(
+			"Usage: %s apply-patch -old=<old binary> -patch=<patch> "+
+				"-out=<new binary>\n",
+			os.Args[0])
+		os.Exit(-1)
+	}
+
+	err := uploader.ApplyPatch(oldFilename, patchFilename, newFilename)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+func verifyProvenance(args []string) {
+	flags := flag.NewFlagSet("verify-provenance", flag.ExitOnError)
+
+	var provenanceFilename string
+	flags.StringVar(
+		&provenanceFilename,
+		"provenance",
+		"",
+		"Provenance <tag>.intoto.jsonl downloaded from the release")
+
+	var publicKeyFilename string
+	flags.StringVar(
+		&publicKeyFilename,
+		"key",
+		"",
+		"File with ed25519 public key, either PEM encoded or base64 encoded "+
+			"32 bytes")
+
+	flags.Parse(args)
+
+	if len(provenanceFilename) == 0 || len(publicKeyFilename) == 0 {
+		fmt.Printf(
+			"Usage: %s verify-provenance -provenance=<provenance> "+
+				"-key=<public key file> [files to verify]\n",
+			os.Args[0])
+		os.Exit(-1)
+	}
+
+	err := uploader.VerifyProvenance(
+		provenanceFilename, publicKeyFilename, flags.Args())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	fmt.Printf("Verified provenance %s\n", provenanceFilename)
+}
+
+func finalize(args []string) {
+	flags := flag.NewFlagSet("finalize", flag.ExitOnError)
+
+	var releaseSuffix string
+	flags.StringVar(
+		&releaseSuffix,
+		"suffix",
+		"",
+		"Optional suffix for names of created continuous releases")
+
+	var prune bool
+	flags.BoolVar(
+		&prune,
+		"prune",
+		false,
+		"Delete the binaries no job of the current build has uploaded")
+
+	var expectedAssets string
+	flags.StringVar(
+		&expectedAssets,
+		"expected-assets",
+		"",
+		"Optional comma separated list of binaries which have to be uploaded "+
+			"before the release is pruned")
+
+	var configFilename string
+	flags.StringVar(
+		&configFilename,
+		"config",
+		"",
+		"Optional JSON config file with the settings of Sparkle appcast "+
+			"and package manifests generated once the release is published")
+
+	var timeout time.Duration
+	flags.DurationVar(
+		&timeout,
+		"timeout",
+		0,
+		"Optional limit for the total duration of the run, i.e. 30m")
+
+	var verbose bool
+	flags.BoolVar(
+		&verbose,
+		"verbose",
+		false,
+		"Enable verbose output")
+
+	flags.Parse(args)
+
+	config := &uploader.Config{}
+	if len(configFilename) != 0 {
+		var err error
+		config, err = uploader.LoadConfig(configFilename)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}
+
+	var expectedAssetList []string
+	if len(expectedAssets) != 0 {
+		expectedAssetList = strings.Split(expectedAssets, ",")
+	}
+
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	err := uploader.Finalize(ctx, uploader.Options{
+		ReleaseSuffix:  releaseSuffix,
+		Prune:          prune,
+		ExpectedAssets: expectedAssetList,
+		Appcast:        config.Appcast,
+		Scoop:          config.Scoop,
+		Homebrew:       config.Homebrew,
+		Assets:         config.Assets,
+		Verbose:        verbose,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+// Creates the context which is cancelled on SIGINT or SIGTERM or once
+// the timeout expires, zero timeout means no limit
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	return timeoutCtx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// Flag which may be given several times collecting all the values
+type stringListFlag []string
+
+func (list *stringListFlag) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringListFlag) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
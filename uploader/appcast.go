@@ -0,0 +1,252 @@
+package uploader
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the Sparkle appcast config
+const (
+	defaultAppcastName    = "appcast.xml"
+	defaultAppcastPattern = "*.{dmg,zip}"
+	defaultAppcastKeyEnv  = "SPARKLE_ED_PRIVATE_KEY"
+)
+
+const sparkleNamespace = "http://www.andymatuschak.org/xml-namespaces/sparkle"
+
+// The sparkle prefixed names are written literally, encoding/xml doesn't
+// support namespace prefixes
+type appcastRss struct {
+	XMLName      xml.Name       `xml:"rss"`
+	Version      string         `xml:"version,attr"`
+	SparkleXmlns string         `xml:"xmlns:sparkle,attr"`
+	Channel      appcastChannel `xml:"channel"`
+}
+
+type appcastChannel struct {
+	Title string        `xml:"title"`
+	Items []appcastItem `xml:"item"`
+}
+
+type appcastItem struct {
+	Title                string           `xml:"title"`
+	PubDate              string           `xml:"pubDate"`
+	Version              string           `xml:"sparkle:version"`
+	ShortVersionString   string           `xml:"sparkle:shortVersionString,omitempty"`
+	MinimumSystemVersion string           `xml:"sparkle:minimumSystemVersion,omitempty"`
+	Description          appcastCData     `xml:"description"`
+	Enclosure            appcastEnclosure `xml:"enclosure"`
+}
+
+type appcastCData struct {
+	Text string `xml:",cdata"`
+}
+
+type appcastEnclosure struct {
+	URL         string `xml:"url,attr"`
+	Length      string `xml:"length,attr"`
+	Type        string `xml:"type,attr"`
+	EdSignature string `xml:"sparkle:edSignature,attr"`
+}
+
+func validateAppcastConfig(config *AppcastConfig) error {
+	if strings.ContainsAny(config.Name, "/\\") {
+		return fmt.Errorf("Invalid appcast name %s", config.Name)
+	}
+
+	if len(config.Pattern) != 0 {
+		err := validateBracedGlob(config.Pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid appcast pattern: %v", err)
+		}
+	}
+
+	for _, text := range []string{config.Version, config.ShortVersion} {
+		_, err := parseTemplate(text)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appcastDefault(value string, defaultValue string) string {
+	if len(value) == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// Regenerates the appcast if the current build job has uploaded the binary
+// the appcast points to or has published the draft release, the appcast
+// asset is replaced in place holding its lock
+func (uploader *Uploader) updateAppcast(ctx context.Context) error {
+	config := uploader.options.Appcast
+	pattern := appcastDefault(config.Pattern, defaultAppcastPattern)
+	name := appcastDefault(config.Name, defaultAppcastName)
+	logger := uploader.logger
+
+	candidates := uploader.result.Assets
+	if uploader.published {
+		var response Response
+		var err error
+		candidates, response, err = uploader.client.ListReleaseAssets(
+			ctx, uploader.release.GetID())
+		response.CloseBody()
+		if err == nil {
+			err = response.Check()
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to list release assets for %s: %v",
+				name, err)
+		}
+	}
+
+	var asset ReleaseAsset
+	for _, uploadedAsset := range candidates {
+		if !matchBracedGlob(pattern, uploadedAsset.GetName()) {
+			continue
+		}
+
+		if asset != nil {
+			return fmt.Errorf("Both %s and %s match the appcast pattern %s",
+				asset.GetName(), uploadedAsset.GetName(), pattern)
+		}
+		asset = uploadedAsset
+	}
+
+	if asset == nil {
+		logger.Printf("No uploaded binaries match the appcast pattern %s, "+
+			"leaving %s as is\n", pattern, name)
+		return nil
+	}
+
+	key, err := loadEd25519PrivateKey(
+		appcastDefault(config.KeyEnv, defaultAppcastKeyEnv))
+	if err != nil {
+		return err
+	}
+
+	item, err := uploader.appcastItem(ctx, asset, key)
+	if err != nil {
+		return err
+	}
+
+	rss := appcastRss{
+		Version:      "2.0",
+		SparkleXmlns: sparkleNamespace,
+		Channel: appcastChannel{
+			Title: appcastDefault(config.Title, uploader.client.GetRepo()),
+			Items: []appcastItem{item},
+		},
+	}
+
+	content, err := xml.MarshalIndent(rss, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append([]byte(xml.Header), append(content, '\n')...)
+
+	return uploader.withLock(ctx, name, func() error {
+		logger.Printf("Uploading appcast %s for %s\n", name, asset.GetName())
+		source := memoryAssetSource(name, name, content)
+		source.contentType = "application/xml"
+		_, err := uploader.replaceReleaseAsset(ctx, source)
+		return err
+	})
+}
+
+// The release notes within the appcast item are the release body without
+// the parts managed by the tool
+func (uploader *Uploader) appcastItem(
+	ctx context.Context,
+	asset ReleaseAsset,
+	key ed25519.PrivateKey) (appcastItem, error) {
+
+	config := uploader.options.Appcast
+	info := uploader.info
+
+	data, err := uploader.appcastAssetContent(ctx, asset)
+	if err != nil {
+		return appcastItem{}, err
+	}
+
+	version := strings.TrimPrefix(info.Tag, "v")
+	if len(config.Version) != 0 {
+		version, err = executeTemplate(config.Version, info)
+		if err != nil {
+			return appcastItem{}, err
+		}
+	}
+
+	shortVersion := ""
+	if len(config.ShortVersion) != 0 {
+		shortVersion, err = executeTemplate(config.ShortVersion, info)
+		if err != nil {
+			return appcastItem{}, err
+		}
+	}
+
+	return appcastItem{
+		Title:                uploader.release.GetName(),
+		PubDate:              time.Now().UTC().Format(time.RFC1123Z),
+		Version:              version,
+		ShortVersionString:   shortVersion,
+		MinimumSystemVersion: config.MinimumSystemVersion,
+		Description: appcastCData{Text: strings.TrimSpace(stripCiBuildLogs(
+			stripReleaseBodySections(uploader.release.GetBody())))},
+		Enclosure: appcastEnclosure{
+			URL: releaseAssetDownloadURL(uploader.client.GetOwner(),
+				uploader.client.GetRepo(), info.Tag, asset.GetName()),
+			Length: strconv.Itoa(len(data)),
+			Type:   "application/octet-stream",
+			EdSignature: base64.StdEncoding.EncodeToString(
+				ed25519.Sign(key, data)),
+		},
+	}, nil
+}
+
+// Returns the content of the binary to sign. The file uploaded by
+// the current build job is read locally, the binaries uploaded by other
+// build jobs, i.e. when the draft release is published, are downloaded
+// back from the release.
+func (uploader *Uploader) appcastAssetContent(
+	ctx context.Context,
+	asset ReleaseAsset) ([]byte, error) {
+
+	for _, source := range uploader.deltaSources {
+		if source.name != asset.GetName() {
+			continue
+		}
+
+		content, err := source.open()
+		if err != nil {
+			return nil, err
+		}
+		defer content.Close()
+		return ioutil.ReadAll(content)
+	}
+
+	content, response, err := uploader.client.DownloadReleaseAsset(
+		ctx, asset.GetID())
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return nil, fmt.Errorf("Bad response on attempt to download %s "+
+			"to sign it: %v", asset.GetName(), err)
+	}
+
+	return ioutil.ReadAll(content)
+}
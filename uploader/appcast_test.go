@@ -0,0 +1,237 @@
+package uploader
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppcastIsSignedAndReplacedInPlace(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate the key: %v", err)
+	}
+
+	os.Setenv(defaultAppcastKeyEnv,
+		base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	defer os.Unsetenv(defaultAppcastKeyEnv)
+
+	dir := t.TempDir()
+
+	factory := newSharedTstClientFactory()
+
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+
+	filename := filepath.Join(dir, "MyApp.dmg")
+	for _, content := range []string{"first build", "second build"} {
+		err = ioutil.WriteFile(filename, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write the sample file: %v", err)
+		}
+
+		_, err = uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{filename},
+			Options{
+				ReleaseSuffix: "master",
+				Appcast:       &AppcastConfig{ShortVersion: "{{.Branch}}"},
+			})
+		if err != nil {
+			t.Fatalf("Failed to upload the file: %v", err)
+		}
+
+		var appcasts []string
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			if asset.GetName() == defaultAppcastName {
+				appcasts = append(appcasts,
+					asset.(TstReleaseAsset).GetContent())
+			}
+		}
+		if len(appcasts) != 1 {
+			t.Fatalf("Expected single appcast, found %d", len(appcasts))
+		}
+
+		if !strings.Contains(appcasts[0], "xmlns:sparkle=\""+
+			sparkleNamespace+"\"") {
+			t.Fatalf("The appcast lacks sparkle namespace: %s", appcasts[0])
+		}
+
+		var rss tstAppcast
+		err = xml.Unmarshal([]byte(appcasts[0]), &rss)
+		if err != nil || len(rss.Channel.Items) != 1 {
+			t.Fatalf("Failed to parse the appcast: %v: %s", err, appcasts[0])
+		}
+
+		item := rss.Channel.Items[0]
+		if item.Version != "continuous-master" ||
+			item.ShortVersionString != "master" ||
+			item.Enclosure.Length != strconv.Itoa(len(content)) ||
+			!strings.HasSuffix(item.Enclosure.URL, "/MyApp.dmg") {
+			t.Fatalf("Unexpected appcast item: %+v", item)
+		}
+
+		if strings.Contains(item.Description, "ciuploadtool:") ||
+			strings.Contains(item.Description, "build log") {
+			t.Fatalf("The release notes within the appcast contain the parts "+
+				"managed by the tool: %s", item.Description)
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(
+			item.Enclosure.EdSignature)
+		if err != nil || !ed25519.Verify(publicKey, []byte(content), signature) {
+			t.Fatalf("Invalid signature of the binary within the appcast: %s",
+				appcasts[0])
+		}
+	}
+}
+
+func TestAppcastIsReplacedHoldingItsLock(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate the key: %v", err)
+	}
+
+	os.Setenv(defaultAppcastKeyEnv,
+		base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	defer os.Unsetenv(defaultAppcastKeyEnv)
+
+	defer func(delay time.Duration) {
+		concurrentUpdateRetryDelay = delay
+	}(concurrentUpdateRetryDelay)
+	concurrentUpdateRetryDelay = 10 * time.Millisecond
+
+	dir := t.TempDir()
+	factory := newSharedTstClientFactory()
+
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+	upload := func(files map[string]string, logger Logger) error {
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, files),
+			Options{
+				ReleaseSuffix: "master",
+				Appcast:       &AppcastConfig{},
+				Logger:        logger,
+			})
+		return err
+	}
+
+	err = upload(map[string]string{"MyApp.AppImage": "linux"}, &tstLogger{})
+	if err != nil {
+		t.Fatalf("Failed to upload the first file: %v", err)
+	}
+
+	// Another build job is replacing the appcast at the moment
+	release := &factory.client.releases[0]
+	for _, name := range []string{
+		defaultAppcastName + lockAssetSuffix,
+		defaultAppcastName + replacementAssetSuffix,
+	} {
+		release.assets = append(release.assets,
+			TstReleaseAsset{id: lastFreeReleaseAssetId, name: name})
+		lastFreeReleaseAssetId++
+	}
+
+	uploadErr := make(chan error, 1)
+	logger := &lockWaitingLogger{waiting: make(chan struct{})}
+	go func() {
+		uploadErr <- upload(map[string]string{"MyApp.dmg": "macos"}, logger)
+	}()
+
+	select {
+	case <-logger.waiting:
+	case err = <-uploadErr:
+		t.Fatalf("The appcast was replaced without waiting for the lock: %v",
+			err)
+	}
+
+	// The other build job finishes the replacement
+	for _, asset := range release.GetAssets() {
+		if isTransientAsset(asset.GetName()) {
+			factory.client.DeleteReleaseAsset(context.Background(),
+				asset.GetID())
+		}
+	}
+
+	err = <-uploadErr
+	if err != nil {
+		t.Fatalf("Failed to upload the second file: %v", err)
+	}
+
+	found := false
+	for _, asset := range factory.client.releases[0].GetAssets() {
+		if isTransientAsset(asset.GetName()) {
+			t.Fatalf("Release asset %s was left behind", asset.GetName())
+		}
+		if asset.GetName() == defaultAppcastName {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("The appcast was not uploaded")
+	}
+}
+
+func TestStripReleaseBodySections(t *testing.T) {
+	body := setReleaseBodySection("Release notes\n", "uploaded-assets",
+		"MyApp.dmg")
+	body = setReleaseBodySection(body, "split-parts", "MyApp.dmg.part1")
+	body += "Travis CI build log: https://travis-ci.org/owner/repo/builds/1\n"
+
+	notes := strings.TrimSpace(stripCiBuildLogs(stripReleaseBodySections(body)))
+	if notes != "Release notes" {
+		t.Fatalf("Unexpected release notes: %q", notes)
+	}
+}
+
+func TestParseEd25519PrivateKey(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate the key: %v", err)
+	}
+
+	for _, data := range [][]byte{privateKey.Seed(), privateKey} {
+		key, err := parseEd25519PrivateKey(
+			base64.StdEncoding.EncodeToString(data))
+		if err != nil || !key.Equal(privateKey) {
+			t.Fatalf("Failed to parse the key: %v", err)
+		}
+	}
+
+	_, err = parseEd25519PrivateKey(
+		base64.StdEncoding.EncodeToString(privateKey[:40]))
+	if err == nil {
+		t.Fatalf("The truncated key was unexpectedly parsed")
+	}
+}
+
+// The decoder resolves the sparkle prefix into the namespace
+type tstAppcast struct {
+	Channel struct {
+		Items []struct {
+			Version            string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle version"`
+			ShortVersionString string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle shortVersionString"`
+			Description        string `xml:"description"`
+			Enclosure          struct {
+				URL         string `xml:"url,attr"`
+				Length      string `xml:"length,attr"`
+				EdSignature string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle edSignature,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
@@ -0,0 +1,312 @@
+package uploader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Formats of archives the uploaded dirs can be packed into
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+	ArchiveTarXz = "tar.xz"
+)
+
+func isValidArchiveFormat(format string) bool {
+	return format == ArchiveZip || format == ArchiveTarGz ||
+		format == ArchiveTarXz
+}
+
+// Modification time of all the archive entries, taken from SOURCE_DATE_EPOCH
+// if it is set so that the archives are reproducible. The default is
+// the earliest time zip archives can represent.
+func archiveModTime() (time.Time, error) {
+	sourceDateEpoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if len(sourceDateEpoch) == 0 {
+		return time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	seconds, err := strconv.ParseInt(sourceDateEpoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(
+			"Invalid SOURCE_DATE_EPOCH %s: %v", sourceDateEpoch, err)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// Packs the dir into the archive named after it within the temporary dir,
+// returns the archive's filename
+func createArchive(dir string, format string, tmpDir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	filename := filepath.Join(tmpDir, filepath.Base(absDir)+"."+format)
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	err = writeArchive(file, absDir, format)
+	if err != nil {
+		return "", fmt.Errorf("Failed to pack %s into %s archive: %v",
+			dir, format, err)
+	}
+
+	return filename, file.Close()
+}
+
+func writeArchive(writer io.Writer, dir string, format string) error {
+	modTime, err := archiveModTime()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case ArchiveZip:
+		return writeZipArchive(writer, dir, modTime)
+	case ArchiveTarGz:
+		gzipWriter, err := gzip.NewWriterLevel(writer, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+
+		err = writeTarArchive(gzipWriter, dir, modTime)
+		if err != nil {
+			return err
+		}
+		return gzipWriter.Close()
+	case ArchiveTarXz:
+		return writeTarXzArchive(writer, dir, modTime)
+	}
+
+	return fmt.Errorf("Unsupported archive format %s", format)
+}
+
+// Calls the function for each entry of the dir in lexical order, the names
+// of entries are slash separated and start with the name of the dir itself
+func walkArchiveEntries(
+	dir string,
+	walkFunc func(name string, filename string, info os.FileInfo) error) error {
+
+	parentDir := filepath.Dir(dir)
+	return filepath.Walk(dir, func(
+		filename string,
+		info os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(parentDir, filename)
+		if err != nil {
+			return err
+		}
+		return walkFunc(filepath.ToSlash(name), filename, info)
+	})
+}
+
+func writeTarArchive(writer io.Writer, dir string, modTime time.Time) error {
+	tarWriter := tar.NewWriter(writer)
+	err := walkArchiveEntries(dir, func(
+		name string,
+		filename string,
+		info os.FileInfo) error {
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			var err error
+			link, err = os.Readlink(filename)
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		// Only the permissions are kept from the file system
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.ModTime = modTime
+		header.AccessTime = time.Time{}
+		header.ChangeTime = time.Time{}
+		header.Uid = 0
+		header.Gid = 0
+		header.Uname = ""
+		header.Gname = ""
+		header.Format = tar.FormatUnknown
+
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFileContent(tarWriter, filename)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tarWriter.Close()
+}
+
+func writeZipArchive(writer io.Writer, dir string, modTime time.Time) error {
+	zipWriter := zip.NewWriter(writer)
+	err := walkArchiveEntries(dir, func(
+		name string,
+		filename string,
+		info os.FileInfo) error {
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+
+		header.Name = name
+		header.Modified = modTime
+		if info.IsDir() {
+			header.Name += "/"
+			header.Method = zip.Store
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		entryWriter, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		// Zip stores symlinks as entries with the link target as content
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(filename)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(entryWriter, link)
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFileContent(entryWriter, filename)
+	})
+	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// There's no xz support within the standard library so the xz tool is used
+func writeTarXzArchive(writer io.Writer, dir string, modTime time.Time) error {
+	xzPath, err := exec.LookPath("xz")
+	if err != nil {
+		return errors.New("The xz tool is required to create tar.xz archives")
+	}
+
+	// Single thread keeps the output reproducible
+	command := exec.Command(xzPath, "-T1", "-6", "-c")
+	command.Stdout = writer
+	command.Stderr = os.Stderr
+
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	err = command.Start()
+	if err != nil {
+		return err
+	}
+
+	err = writeTarArchive(stdin, dir, modTime)
+	stdin.Close()
+	waitErr := command.Wait()
+	if err != nil {
+		return err
+	}
+	return waitErr
+}
+
+func copyFileContent(writer io.Writer, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// Packs the dir into the archive and returns the source of the release asset
+// named after the archive
+func (uploader *Uploader) archiveAssetSource(dir string) (*assetSource, error) {
+	tmpDir, err := uploader.tempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	uploader.logger.Printf("Packing dir %s into %s archive\n", dir,
+		uploader.options.Archive)
+	archiveFilename, err := createArchive(
+		dir, uploader.options.Archive, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(archiveFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &assetSource{
+		filename: dir,
+		name:     filepath.Base(archiveFilename),
+		size:     stat.Size(),
+		open: func() (io.ReadCloser, error) {
+			return os.Open(archiveFilename)
+		},
+	}, nil
+}
+
+// Creates the temporary dir removed once the upload is done on first call
+func (uploader *Uploader) tempDir() (string, error) {
+	if len(uploader.tmpDir) == 0 {
+		tmpDir, err := ioutil.TempDir("", "ciuploadtool")
+		if err != nil {
+			return "", err
+		}
+		uploader.tmpDir = tmpDir
+	}
+	return uploader.tmpDir, nil
+}
+
+func (uploader *Uploader) removeTmpDir() {
+	if len(uploader.tmpDir) != 0 {
+		os.RemoveAll(uploader.tmpDir)
+		uploader.tmpDir = ""
+	}
+}
@@ -0,0 +1,196 @@
+package uploader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTarArchiveKeepsModesAndSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Symlinks and executable bits are not available on Windows")
+	}
+
+	dir := setupSampleArchiveDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	os.Setenv("SOURCE_DATE_EPOCH", "1500000000")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	var archive bytes.Buffer
+	err := writeArchive(&archive, dir, ArchiveTarGz)
+	if err != nil {
+		t.Fatalf("Failed to create tar.gz archive: %v", err)
+	}
+
+	gzipReader, err := gzip.NewReader(&archive)
+	if err != nil {
+		t.Fatalf("Failed to read the gzip stream: %v", err)
+	}
+
+	headers := make(map[string]*tar.Header)
+	var names []string
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read the tar archive: %v", err)
+		}
+		headers[header.Name] = header
+		names = append(names, header.Name)
+	}
+
+	expectedNames := []string{"MyApp.app/", "MyApp.app/bin/",
+		"MyApp.app/bin/myapp", "MyApp.app/latest", "MyApp.app/readme.txt"}
+	if len(names) != len(expectedNames) {
+		t.Fatalf("Unexpected archive entries: %v", names)
+	}
+	for i, name := range expectedNames {
+		if names[i] != name {
+			t.Fatalf("Unexpected archive entries: %v", names)
+		}
+	}
+
+	if headers["MyApp.app/bin/myapp"].FileInfo().Mode().Perm() != 0755 {
+		t.Fatalf("The executable bit of the file was lost")
+	}
+
+	if headers["MyApp.app/readme.txt"].FileInfo().Mode().Perm() != 0644 {
+		t.Fatalf("Unexpected mode of the regular file")
+	}
+
+	link := headers["MyApp.app/latest"]
+	if link.Typeflag != tar.TypeSymlink || link.Linkname != "bin/myapp" {
+		t.Fatalf("The symlink was not preserved")
+	}
+
+	for name, header := range headers {
+		if !header.ModTime.Equal(time.Unix(1500000000, 0)) {
+			t.Fatalf("The modification time of %s was not normalized: %v",
+				name, header.ModTime)
+		}
+	}
+}
+
+func TestArchivesAreReproducible(t *testing.T) {
+	dir := setupSampleArchiveDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	formats := []string{ArchiveZip, ArchiveTarGz}
+	_, err := exec.LookPath("xz")
+	if err == nil {
+		formats = append(formats, ArchiveTarXz)
+	}
+
+	for _, format := range formats {
+		var first bytes.Buffer
+		err := writeArchive(&first, dir, format)
+		if err != nil {
+			t.Fatalf("Failed to create %s archive: %v", format, err)
+		}
+
+		later := time.Now().Add(time.Hour)
+		err = os.Chtimes(filepath.Join(dir, "readme.txt"), later, later)
+		if err != nil {
+			t.Fatalf("Failed to touch the file: %v", err)
+		}
+
+		var second bytes.Buffer
+		err = writeArchive(&second, dir, format)
+		if err != nil {
+			t.Fatalf("Failed to create %s archive: %v", format, err)
+		}
+
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("The %s archives of the same dir differ", format)
+		}
+	}
+}
+
+func TestUploadOfArchivedDirectory(t *testing.T) {
+	dir := setupSampleArchiveDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+
+	client, err := uploadImpl(
+		context.Background(),
+		clientFactoryFunc(newTstClient),
+		ReleaseFactory(newTstRelease),
+		[]string{dir},
+		Options{ReleaseSuffix: "master", Archive: ArchiveZip})
+	if err != nil {
+		t.Fatalf("Failed to upload the archived dir: %v", err)
+	}
+
+	assets := client.(*TstClient).releases[0].GetAssets()
+	if len(assets) != 1 || assets[0].GetName() != "MyApp.app.zip" {
+		t.Fatalf("Unexpected release assets: %v", assets)
+	}
+
+	content := assets[0].(TstReleaseAsset).GetContent()
+	zipReader, err := zip.NewReader(
+		bytes.NewReader([]byte(content)), int64(len(content)))
+	if err != nil {
+		t.Fatalf("The uploaded release asset is not a zip archive: %v", err)
+	}
+
+	found := false
+	for _, file := range zipReader.File {
+		if file.Name == "MyApp.app/bin/myapp" {
+			found = true
+			if runtime.GOOS != "windows" && file.Mode().Perm() != 0755 {
+				t.Fatalf("The executable bit of the file was lost")
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("The file is missing within the uploaded archive")
+	}
+}
+
+// Creates MyApp.app dir with an executable, a regular file and a symlink
+// within a temporary dir
+func setupSampleArchiveDir(t *testing.T) string {
+	tmpDir, err := ioutil.TempDir("", "ciuploadtool")
+	if err != nil {
+		t.Fatalf("Failed to create the temporary dir: %v", err)
+	}
+
+	dir := filepath.Join(tmpDir, "MyApp.app")
+	err = os.MkdirAll(filepath.Join(dir, "bin"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(
+			filepath.Join(dir, "bin", "myapp"), []byte("#!/bin/sh\n"), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(
+			filepath.Join(dir, "readme.txt"), []byte("Readme\n"), 0644)
+	}
+	if err == nil && runtime.GOOS != "windows" {
+		err = os.Symlink("bin/myapp", filepath.Join(dir, "latest"))
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create the sample dir: %v", err)
+	}
+
+	// The umask might have stripped the bits
+	os.Chmod(filepath.Join(dir, "bin", "myapp"), 0755)
+	os.Chmod(filepath.Join(dir, "readme.txt"), 0644)
+	return dir
+}
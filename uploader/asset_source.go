@@ -0,0 +1,422 @@
+package uploader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Command line filename standing for the standard input
+const stdinFilename = "-"
+
+// assetSource provides the content of the release asset to upload. It can be
+// opened more than once so that the asset can be uploaded again if
+// the verification of the uploaded one fails.
+type assetSource struct {
+	// The file the content comes from as given to the uploader, used for
+	// logging and hooks
+	filename string
+
+	name        string
+	contentType string
+	label       string
+	size        int64
+	open        func() (io.ReadCloser, error)
+
+	// Name of the file the part or the manifest of parts was split from
+	// and the index of the part starting from 1
+	splitFrom string
+	partIndex int
+
+	// Names of release assets generated from the source, i.e. its zsync
+	// file, which are replaced along with it
+	companions []string
+
+	// Matches the names of the original file uploaded by the previous builds
+	// if the name carries the build metadata, nil otherwise
+	namePattern *regexp.Regexp
+}
+
+func (source *assetSource) originalName() string {
+	if len(source.splitFrom) != 0 {
+		return source.splitFrom
+	}
+	return source.name
+}
+
+// Checks whether the existing release asset has to be deleted before
+// the source is uploaded. Besides the duplicate, the parts and the manifest
+// remaining from the previous upload of the same file are deleted before
+// the upload of the whole file or its first part.
+func (source *assetSource) replaces(assetName string) bool {
+	if assetName == source.name {
+		return true
+	}
+
+	for _, companion := range source.companions {
+		if assetName == companion {
+			return true
+		}
+	}
+
+	if len(source.splitFrom) != 0 && source.partIndex != 1 {
+		return false
+	}
+
+	original := source.originalName()
+	if assetName == original || isSplitArtifactOf(assetName, original) {
+		return true
+	}
+
+	return source.isPreviousUploadOf(generatedAssetOriginalName(assetName))
+}
+
+// Checks whether the release asset is the original file uploaded by
+// the previous build under the name with different build metadata
+func (source *assetSource) isPreviousUploadOf(assetName string) bool {
+	return source.namePattern != nil && source.namePattern.MatchString(assetName)
+}
+
+func (source *assetSource) assetUpload(content io.Reader) AssetUpload {
+	return AssetUpload{
+		Name:        source.name,
+		ContentType: source.contentType,
+		Label:       source.label,
+		Size:        source.size,
+		Content:     content,
+	}
+}
+
+func fileAssetSource(
+	filename string,
+	assetName string,
+	size int64) *assetSource {
+
+	return &assetSource{
+		filename: filename,
+		name:     assetName,
+		size:     size,
+		open: func() (io.ReadCloser, error) {
+			return os.Open(filename)
+		},
+	}
+}
+
+func memoryAssetSource(
+	filename string,
+	name string,
+	content []byte) *assetSource {
+
+	return &assetSource{
+		filename: filename,
+		name:     name,
+		size:     int64(len(content)),
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(content)), nil
+		},
+	}
+}
+
+// The content of the stream is read into memory since its size has to be
+// known before the upload starts
+func streamAssetSource(name string, stream io.Reader) (*assetSource, error) {
+	content, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Failed to read the content of release asset %s: %v", name, err)
+	}
+	return memoryAssetSource(stdinFilename, name, content), nil
+}
+
+// The reader of the upload can only be read again if it is seekable
+func readerAssetSource(upload AssetUpload) *assetSource {
+	opened := false
+	return &assetSource{
+		filename:    upload.Name,
+		name:        upload.Name,
+		contentType: upload.ContentType,
+		label:       upload.Label,
+		size:        upload.Size,
+		open: func() (io.ReadCloser, error) {
+			if opened {
+				seeker, ok := upload.Content.(io.Seeker)
+				if !ok {
+					return nil, fmt.Errorf("The content of release asset %s "+
+						"can't be read again", upload.Name)
+				}
+
+				_, err := seeker.Seek(0, io.SeekStart)
+				if err != nil {
+					return nil, err
+				}
+			}
+			opened = true
+			return ioutil.NopCloser(upload.Content), nil
+		},
+	}
+}
+
+func assetContentType(upload AssetUpload) string {
+	if len(upload.ContentType) != 0 {
+		return upload.ContentType
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(upload.Name))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	return contentType
+}
+
+// Collects the sources of all the release assets to upload before any API
+// call is made, so that the release is not left half-updated because of
+// missing files or conflicting asset names
+func (uploader *Uploader) collectAssetSources(
+	filenames []string) ([]*assetSource, error) {
+
+	expandedFilenames, err := commandLineFiles(
+		filenames, uploader.options.AllowEmpty, uploader.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []*assetSource
+	for _, filename := range expandedFilenames {
+		if filename == stdinFilename {
+			if len(uploader.options.StdinName) == 0 {
+				return nil, errors.New(
+					"The name of the release asset read from stdin is required")
+			}
+
+			source, err := streamAssetSource(
+				uploader.options.StdinName, uploader.stdin)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, source)
+			continue
+		}
+
+		stat, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		if stat.IsDir() && len(uploader.options.Archive) != 0 {
+			source, err := uploader.archiveAssetSource(filename)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, source)
+			continue
+		}
+
+		if stat.IsDir() && uploader.options.Recursive {
+			dirSources, err := uploader.collectDirAssetSources(filename)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, dirSources...)
+			continue
+		}
+
+		mode := stat.Mode()
+		if !mode.IsRegular() {
+			uploader.logger.Printf("Skipping dir %s\n", filename)
+			continue
+		}
+
+		sources = append(sources,
+			fileAssetSource(filename, filepath.Base(filename), stat.Size()))
+	}
+
+	// The build info is needed for renaming, the client is created but no
+	// API call is made yet
+	if len(uploader.nameTemplate()) != 0 {
+		ready, err := uploader.setup()
+		if err != nil {
+			return nil, err
+		}
+
+		if ready {
+			err = uploader.renameAssetSources(sources)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = uploader.checkGeneratedAssetNames(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err = splitAssetSources(sources, uploader.options.SplitSize)
+	if err != nil {
+		return nil, err
+	}
+
+	filenamesByAssetName := make(map[string]string)
+	for _, source := range sources {
+		otherFilename, ok := filenamesByAssetName[source.name]
+		if ok {
+			return nil, fmt.Errorf("Both %s and %s would be uploaded as "+
+				"release asset %s", otherFilename, source.filename, source.name)
+		}
+		filenamesByAssetName[source.name] = source.filename
+	}
+
+	return sources, nil
+}
+
+// Fails if any file would be uploaded under the name of the release asset
+// generated by the tool: either the generated asset would replace it or
+// the file would be taken for the generated asset
+func (uploader *Uploader) checkGeneratedAssetNames(
+	sources []*assetSource) error {
+
+	generatedNames, err := uploader.generatedAssetNames()
+	if err != nil {
+		return err
+	}
+
+	options := uploader.options
+	for _, source := range sources {
+		generated, ok := generatedNames[source.name]
+		if !ok {
+			originalName := generatedAssetOriginalName(source.name)
+			switch {
+			case isTransientAsset(source.name):
+				generated = "the lock or the replacement of the shared " +
+					"release asset"
+			case options.Zsync && strings.HasSuffix(source.name, zsyncSuffix):
+				generated = "the zsync file of " + originalName
+			case options.SplitSize != 0 &&
+				isSplitArtifactOf(source.name, originalName):
+				generated = "the part of " + originalName
+			case options.DeltaPatches &&
+				isDeltaPatchOf(source.name, originalName):
+				generated = "the delta patch of " + originalName
+			case options.Provenance &&
+				strings.HasSuffix(source.name, provenanceNameSuffix):
+				generated = "the provenance"
+			default:
+				continue
+			}
+		}
+
+		return fmt.Errorf("%s would be uploaded as release asset %s which "+
+			"is reserved for %s", source.filename, source.name, generated)
+	}
+
+	return nil
+}
+
+// Returns the names of the release assets generated by the enabled features
+// mapped to the descriptions of the assets
+func (uploader *Uploader) generatedAssetNames() (map[string]string, error) {
+	options := uploader.options
+	names := make(map[string]string)
+	if options.UpdateFeed != nil {
+		names[updateFeedName(options.UpdateFeed)] = "the update feed"
+	}
+	if options.Appcast != nil {
+		names[appcastDefault(options.Appcast.Name, defaultAppcastName)] =
+			"the appcast"
+	}
+	if options.SBOM {
+		names[sbomName] = "the SBOM"
+	}
+
+	packageNames := make(map[string]string)
+	if options.Scoop != nil {
+		packageNames["the Scoop manifest"] = options.Scoop.Name + ".json"
+	}
+	if options.Homebrew != nil {
+		packageNames["the Homebrew formula"] = options.Homebrew.Name + ".rb"
+	}
+
+	for generated, name := range packageNames {
+		if !strings.HasPrefix(name, ".") {
+			names[name] = generated
+			continue
+		}
+
+		// The package manifests are named after the repository by default
+		ready, err := uploader.setup()
+		if err != nil || !ready {
+			return names, err
+		}
+		names[uploader.client.GetRepo()+name] = generated
+	}
+
+	return names, nil
+}
+
+// Walks the dir in lexical order picking the files matching the include and
+// exclude globs
+func (uploader *Uploader) collectDirAssetSources(
+	dir string) ([]*assetSource, error) {
+
+	var sources []*assetSource
+	err := filepath.Walk(dir, func(
+		filename string,
+		info os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(filename)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if !uploader.globFilter.matches(relPath) {
+			if uploader.options.Verbose {
+				uploader.logger.Printf("Skipping filtered out file %s\n",
+					filename)
+			}
+			return nil
+		}
+
+		sources = append(sources, fileAssetSource(
+			filename,
+			uploader.dirAssetName(relPath),
+			info.Size()))
+		return nil
+	})
+
+	return sources, err
+}
+
+// Asset names of the files within the uploaded dirs are either their base
+// names or their relative paths with components joined by the separator
+func (uploader *Uploader) dirAssetName(relPath string) string {
+	separator := uploader.options.AssetNameSeparator
+	if len(separator) == 0 {
+		return path.Base(relPath)
+	}
+	return strings.Replace(relPath, "/", separator, -1)
+}
@@ -0,0 +1,440 @@
+package uploader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The patches are produced with the algorithm of Colin Percival's bsdiff.
+// The original format compresses the patch with bzip2 which the standard
+// library can only decompress, so the control, diff and extra data are
+// interleaved within single gzip stream instead. The header carries
+// the digests of the old and the new file to detect patching of the wrong
+// file.
+const bsdiffMagic = "BSDIFFGZ"
+
+// Magic, SHA-256 of the old and the new file and the size of the new file
+const bsdiffHeaderSize = 8 + 2*sha256.Size + 8
+
+// Sorts the suffixes of the data with Larsson and Sadakane's qsufsort,
+// the suffix array includes the empty suffix
+func qsufsort(data []byte) []int32 {
+	size := int32(len(data))
+	suffixes := make([]int32, size+1)
+	groups := make([]int32, size+1)
+
+	var buckets [256]int32
+	for _, char := range data {
+		buckets[char]++
+	}
+	for i := 1; i < 256; i++ {
+		buckets[i] += buckets[i-1]
+	}
+	for i := 255; i > 0; i-- {
+		buckets[i] = buckets[i-1]
+	}
+	buckets[0] = 0
+
+	for i, char := range data {
+		buckets[char]++
+		suffixes[buckets[char]] = int32(i)
+	}
+	suffixes[0] = size
+	for i, char := range data {
+		groups[i] = buckets[char]
+	}
+	groups[size] = 0
+	for i := 1; i < 256; i++ {
+		if buckets[i] == buckets[i-1]+1 {
+			suffixes[buckets[i]] = -1
+		}
+	}
+	suffixes[0] = -1
+
+	// Negative entries are the lengths of the runs of sorted suffixes
+	for h := int32(1); suffixes[0] != -(size + 1); h += h {
+		length := int32(0)
+		i := int32(0)
+		for i < size+1 {
+			if suffixes[i] < 0 {
+				length -= suffixes[i]
+				i -= suffixes[i]
+				continue
+			}
+
+			if length != 0 {
+				suffixes[i-length] = -length
+			}
+			length = groups[suffixes[i]] + 1 - i
+			qsufsortSplit(suffixes, groups, i, length, h)
+			i += length
+			length = 0
+		}
+		if length != 0 {
+			suffixes[i-length] = -length
+		}
+	}
+
+	for i := int32(0); i < size+1; i++ {
+		suffixes[groups[i]] = i
+	}
+	return suffixes
+}
+
+func qsufsortSplit(suffixes []int32, groups []int32, start int32, length int32, h int32) {
+	if length < 16 {
+		var j int32
+		for k := start; k < start+length; k += j {
+			j = 1
+			x := groups[suffixes[k]+h]
+			for i := int32(1); k+i < start+length; i++ {
+				if groups[suffixes[k+i]+h] < x {
+					x = groups[suffixes[k+i]+h]
+					j = 0
+				}
+				if groups[suffixes[k+i]+h] == x {
+					suffixes[k+j], suffixes[k+i] = suffixes[k+i], suffixes[k+j]
+					j++
+				}
+			}
+			for i := int32(0); i < j; i++ {
+				groups[suffixes[k+i]] = k + j - 1
+			}
+			if j == 1 {
+				suffixes[k] = -1
+			}
+		}
+		return
+	}
+
+	x := groups[suffixes[start+length/2]+h]
+	var jj, kk int32
+	for i := start; i < start+length; i++ {
+		if groups[suffixes[i]+h] < x {
+			jj++
+		}
+		if groups[suffixes[i]+h] == x {
+			kk++
+		}
+	}
+	jj += start
+	kk += jj
+
+	i, j, k := start, int32(0), int32(0)
+	for i < jj {
+		switch {
+		case groups[suffixes[i]+h] < x:
+			i++
+		case groups[suffixes[i]+h] == x:
+			suffixes[i], suffixes[jj+j] = suffixes[jj+j], suffixes[i]
+			j++
+		default:
+			suffixes[i], suffixes[kk+k] = suffixes[kk+k], suffixes[i]
+			k++
+		}
+	}
+
+	for jj+j < kk {
+		if groups[suffixes[jj+j]+h] == x {
+			j++
+		} else {
+			suffixes[jj+j], suffixes[kk+k] = suffixes[kk+k], suffixes[jj+j]
+			k++
+		}
+	}
+
+	if jj > start {
+		qsufsortSplit(suffixes, groups, start, jj-start, h)
+	}
+
+	for i := int32(0); i < kk-jj; i++ {
+		groups[suffixes[jj+i]] = kk - 1
+	}
+	if jj == kk-1 {
+		suffixes[jj] = -1
+	}
+
+	if start+length > kk {
+		qsufsortSplit(suffixes, groups, kk, start+length-kk, h)
+	}
+}
+
+func bsdiffMatchLength(old []byte, new []byte) int {
+	i := 0
+	for i < len(old) && i < len(new) && old[i] == new[i] {
+		i++
+	}
+	return i
+}
+
+// Finds the longest prefix of new within old using the suffix array
+func bsdiffSearch(
+	suffixes []int32,
+	old []byte,
+	new []byte,
+	start int,
+	end int) (int, int) {
+
+	for end-start >= 2 {
+		middle := start + (end-start)/2
+		suffix := old[suffixes[middle]:]
+		if len(suffix) > len(new) {
+			suffix = suffix[:len(new)]
+		}
+		if bytes.Compare(suffix, new[:len(suffix)]) < 0 {
+			start = middle
+		} else {
+			end = middle
+		}
+	}
+
+	startLength := bsdiffMatchLength(old[suffixes[start]:], new)
+	endLength := bsdiffMatchLength(old[suffixes[end]:], new)
+	if startLength > endLength {
+		return int(suffixes[start]), startLength
+	}
+	return int(suffixes[end]), endLength
+}
+
+// Writes the integer in bsdiff's sign and magnitude little endian encoding
+func bsdiffWriteInt(writer io.Writer, value int64) error {
+	var buffer [8]byte
+	if value < 0 {
+		binary.LittleEndian.PutUint64(buffer[:], uint64(-value))
+		buffer[7] |= 0x80
+	} else {
+		binary.LittleEndian.PutUint64(buffer[:], uint64(value))
+	}
+	_, err := writer.Write(buffer[:])
+	return err
+}
+
+func bsdiffReadInt(reader io.Reader) (int64, error) {
+	var buffer [8]byte
+	_, err := io.ReadFull(reader, buffer[:])
+	if err != nil {
+		return 0, err
+	}
+
+	negative := buffer[7]&0x80 != 0
+	buffer[7] &= 0x7f
+	value := int64(binary.LittleEndian.Uint64(buffer[:]))
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
+
+// Writes the patch turning old into new
+func writeBsdiffPatch(writer io.Writer, old []byte, new []byte) error {
+	oldDigest := sha256.Sum256(old)
+	newDigest := sha256.Sum256(new)
+
+	_, err := io.WriteString(writer, bsdiffMagic)
+	if err == nil {
+		_, err = writer.Write(oldDigest[:])
+	}
+	if err == nil {
+		_, err = writer.Write(newDigest[:])
+	}
+	if err == nil {
+		err = bsdiffWriteInt(writer, int64(len(new)))
+	}
+	if err != nil {
+		return err
+	}
+
+	gzipWriter, err := gzip.NewWriterLevel(writer, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	output := bufio.NewWriter(gzipWriter)
+
+	suffixes := qsufsort(old)
+	oldSize := len(old)
+	newSize := len(new)
+
+	scan, pos, length := 0, 0, 0
+	lastScan, lastPos, lastOffset := 0, 0, 0
+	for scan < newSize {
+		oldScore := 0
+		scan += length
+		for scsc := scan; scan < newSize; scan++ {
+			pos, length = bsdiffSearch(suffixes, old, new[scan:], 0, oldSize)
+
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastOffset < oldSize &&
+					old[scsc+lastOffset] == new[scsc] {
+					oldScore++
+				}
+			}
+
+			if (length == oldScore && length != 0) || length > oldScore+8 {
+				break
+			}
+
+			if scan+lastOffset < oldSize && old[scan+lastOffset] == new[scan] {
+				oldScore--
+			}
+		}
+
+		if length == oldScore && scan != newSize {
+			continue
+		}
+
+		// Extend the match forwards from the last one and backwards from
+		// the current one as long as at least half of the bytes match
+		s, sf, lengthForward := 0, 0, 0
+		for i := 0; lastScan+i < scan && lastPos+i < oldSize; {
+			if old[lastPos+i] == new[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > sf*2-lengthForward {
+				sf = s
+				lengthForward = i
+			}
+		}
+
+		lengthBackward := 0
+		if scan < newSize {
+			s, sb := 0, 0
+			for i := 1; scan >= lastScan+i && pos >= i; i++ {
+				if old[pos-i] == new[scan-i] {
+					s++
+				}
+				if s*2-i > sb*2-lengthBackward {
+					sb = s
+					lengthBackward = i
+				}
+			}
+		}
+
+		if lastScan+lengthForward > scan-lengthBackward {
+			overlap := (lastScan + lengthForward) - (scan - lengthBackward)
+			s, ss, lengthSplit := 0, 0, 0
+			for i := 0; i < overlap; i++ {
+				if new[lastScan+lengthForward-overlap+i] ==
+					old[lastPos+lengthForward-overlap+i] {
+					s++
+				}
+				if new[scan-lengthBackward+i] == old[pos-lengthBackward+i] {
+					s--
+				}
+				if s > ss {
+					ss = s
+					lengthSplit = i + 1
+				}
+			}
+
+			lengthForward += lengthSplit - overlap
+			lengthBackward -= lengthSplit
+		}
+
+		extraLength := (scan - lengthBackward) - (lastScan + lengthForward)
+		err = bsdiffWriteInt(output, int64(lengthForward))
+		if err == nil {
+			err = bsdiffWriteInt(output, int64(extraLength))
+		}
+		if err == nil {
+			err = bsdiffWriteInt(output,
+				int64((pos-lengthBackward)-(lastPos+lengthForward)))
+		}
+		for i := 0; err == nil && i < lengthForward; i++ {
+			err = output.WriteByte(new[lastScan+i] - old[lastPos+i])
+		}
+		if err == nil {
+			_, err = output.Write(
+				new[lastScan+lengthForward : scan-lengthBackward])
+		}
+		if err != nil {
+			return err
+		}
+
+		lastScan = scan - lengthBackward
+		lastPos = pos - lengthBackward
+		lastOffset = pos - scan
+	}
+
+	err = output.Flush()
+	if err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// Applies the patch produced by writeBsdiffPatch to old
+func applyBsdiffPatch(old []byte, patch io.Reader) ([]byte, error) {
+	var header [bsdiffHeaderSize]byte
+	_, err := io.ReadFull(patch, header[:])
+	if err != nil || string(header[:len(bsdiffMagic)]) != bsdiffMagic {
+		return nil, errors.New("Not a patch produced by ciuploadtool")
+	}
+
+	oldDigest := sha256.Sum256(old)
+	if !bytes.Equal(oldDigest[:], header[8:8+sha256.Size]) {
+		return nil, errors.New(
+			"The patch doesn't apply to the file: SHA-256 mismatch")
+	}
+
+	newSize, err := bsdiffReadInt(bytes.NewReader(header[8+2*sha256.Size:]))
+	if err != nil || newSize < 0 {
+		return nil, errors.New("Corrupt patch: invalid size")
+	}
+
+	gzipReader, err := gzip.NewReader(patch)
+	if err != nil {
+		return nil, fmt.Errorf("Corrupt patch: %v", err)
+	}
+	input := bufio.NewReader(gzipReader)
+
+	new := make([]byte, newSize)
+	oldPos, newPos := int64(0), int64(0)
+	for newPos < newSize {
+		var control [3]int64
+		for i := range control {
+			control[i], err = bsdiffReadInt(input)
+			if err != nil {
+				return nil, fmt.Errorf("Corrupt patch: %v", err)
+			}
+		}
+
+		if control[0] < 0 || control[1] < 0 ||
+			newPos+control[0]+control[1] > newSize {
+			return nil, errors.New("Corrupt patch: invalid control data")
+		}
+
+		diff := new[newPos : newPos+control[0]]
+		_, err = io.ReadFull(input, diff)
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt patch: %v", err)
+		}
+		for i := range diff {
+			if oldPos+int64(i) >= 0 && oldPos+int64(i) < int64(len(old)) {
+				diff[i] += old[oldPos+int64(i)]
+			}
+		}
+		newPos += control[0]
+		oldPos += control[0]
+
+		_, err = io.ReadFull(input, new[newPos:newPos+control[1]])
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt patch: %v", err)
+		}
+		newPos += control[1]
+		oldPos += control[2]
+	}
+
+	newDigest := sha256.Sum256(new)
+	if !bytes.Equal(newDigest[:], header[8+sha256.Size:8+2*sha256.Size]) {
+		return nil, errors.New("Corrupt patch: SHA-256 mismatch of the result")
+	}
+
+	return new, nil
+}
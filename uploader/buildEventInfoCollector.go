@@ -7,23 +7,47 @@ import (
 	"strings"
 )
 
-type buildEventInfo struct {
-	token         string
-	tag           string
-	commit        string
-	branch        string
-	repo          string
-	owner         string
-	isPullRequest bool
-	releaseTitle  string
-	isPrerelease  bool
-	isTravisCi    bool
-	buildId       string
+// CI providers the build information can be collected from
+const (
+	ProviderTravisCi = "travis"
+	ProviderAppVeyor = "appveyor"
+)
+
+// BuildInfo describes the build event which binaries are uploaded and
+// the release they are uploaded to
+type BuildInfo struct {
+	Token         string
+	Tag           string
+	Commit        string
+	Branch        string
+	Repo          string
+	Owner         string
+	IsPullRequest bool
+	ReleaseTitle  string
+	IsPrerelease  bool
+	Provider      string
+	BuildId       string
+	JobKey        string
+}
+
+func (info *BuildInfo) isTravisCi() bool {
+	return info.Provider == ProviderTravisCi
+}
+
+// ShortCommit returns the abbreviated SHA of the commit, i.e. for use within
+// templates
+func (info *BuildInfo) ShortCommit() string {
+	return shortCommit(info.Commit)
+}
+
+func (info *BuildInfo) isContinuous() bool {
+	return strings.HasPrefix(info.Tag, "continuous")
 }
 
 func collectBuildEventInfo(
 	releaseSuffix string,
-	verbose bool) (*buildEventInfo, error) {
+	logger Logger,
+	verbose bool) (*BuildInfo, error) {
 
 	// Check whether the app is run during Travis CI or AppVeyor CI build
 	appVeyorEnvVar := os.Getenv("APPVEYOR")
@@ -31,22 +55,22 @@ func collectBuildEventInfo(
 	isTravisCi := travisCiEnvVar == "true"
 	isAppVeyor := appVeyorEnvVar == "True"
 	if !isTravisCi && !isAppVeyor {
-		fmt.Println("Neither Travis CI build nor AppVeyor build. Not doing anything")
+		logger.Printf("Neither Travis CI build nor AppVeyor build. Not doing anything\n")
 		return nil, nil
 	}
 
-	var info buildEventInfo
+	var info BuildInfo
 
 	// Get GitHub API token from the environment variable
 	if isAppVeyor {
-		info.token = os.Getenv("auth_token")
+		info.Token = os.Getenv("auth_token")
 	} else {
-		info.token = os.Getenv("GITHUB_TOKEN")
+		info.Token = os.Getenv("GITHUB_TOKEN")
 	}
 
-	if info.token == "" {
+	if info.Token == "" {
 		if isAppVeyor {
-			fmt.Println("No dev token for AppVeyor CI job, won't do anything")
+			logger.Printf("No dev token for AppVeyor CI job, won't do anything\n")
 			// This happens in AppVeyor CI on pull request builds, will silently
 			// ignore that
 			return nil, nil
@@ -57,70 +81,130 @@ func collectBuildEventInfo(
 
 	// Get various build information from environment variables
 	// specific to Travis CI and AppVeyor CI
-	info.isTravisCi = isTravisCi
+	if isTravisCi {
+		info.Provider = ProviderTravisCi
+	} else {
+		info.Provider = ProviderAppVeyor
+	}
 
 	repoSlug := ""
 
 	if isAppVeyor {
-		fmt.Println("Running on AppVeyor CI")
-		info.branch = os.Getenv("APPVEYOR_REPO_BRANCH")
-		info.tag = os.Getenv("APPVEYOR_REPO_TAG_NAME")
-		info.commit = os.Getenv("APPVEYOR_REPO_COMMIT")
+		logger.Printf("Running on AppVeyor CI\n")
+		info.Branch = os.Getenv("APPVEYOR_REPO_BRANCH")
+		info.Tag = os.Getenv("APPVEYOR_REPO_TAG_NAME")
+		info.Commit = os.Getenv("APPVEYOR_REPO_COMMIT")
 		repoSlug = os.Getenv("APPVEYOR_REPO_NAME")
-		info.buildId = os.Getenv("APPVEYOR_BUILD_VERSION")
-		info.isPullRequest = os.Getenv("APPVEYOR_PULL_REQUEST_NUMBER") != ""
+		info.BuildId = os.Getenv("APPVEYOR_BUILD_VERSION")
+		info.JobKey = jobKey(
+			os.Getenv("APPVEYOR_BUILD_WORKER_IMAGE"),
+			os.Getenv("PLATFORM"),
+			os.Getenv("CONFIGURATION"))
+		info.IsPullRequest = os.Getenv("APPVEYOR_PULL_REQUEST_NUMBER") != ""
 	} else {
-		fmt.Println("Running on Travis CI")
-		info.branch = os.Getenv("TRAVIS_BRANCH")
-		info.tag = os.Getenv("TRAVIS_TAG")
-		info.commit = os.Getenv("TRAVIS_COMMIT")
+		logger.Printf("Running on Travis CI\n")
+		info.Branch = os.Getenv("TRAVIS_BRANCH")
+		info.Tag = os.Getenv("TRAVIS_TAG")
+		info.Commit = os.Getenv("TRAVIS_COMMIT")
 		repoSlug = os.Getenv("TRAVIS_REPO_SLUG")
-		info.buildId = os.Getenv("TRAVIS_BUILD_ID")
-		info.isPullRequest = os.Getenv("TRAVIS_EVENT_TYPE") == "pull_request"
+		info.BuildId = os.Getenv("TRAVIS_BUILD_ID")
+		info.JobKey = jobKey(
+			os.Getenv("TRAVIS_OS_NAME"),
+			os.Getenv("TRAVIS_CPU_ARCH"),
+			os.Getenv("TRAVIS_JOB_NAME"))
+		info.IsPullRequest = os.Getenv("TRAVIS_EVENT_TYPE") == "pull_request"
 	}
 
 	if verbose {
-		fmt.Println("Branch = " + info.branch + ", tag = " + info.tag +
-			", commit = " + info.commit + ", repo slug = " + repoSlug +
-			", build id = " + info.buildId)
+		logger.Printf("Branch = %s, tag = %s, commit = %s, repo slug = %s, "+
+			"build id = %s, job key = %s\n", info.Branch, info.Tag,
+			info.Commit, repoSlug, info.BuildId, info.JobKey)
 	}
 
-	if info.isPullRequest {
-		fmt.Println("Current build is the one triggered by a pull request, won't do anything")
+	if info.IsPullRequest {
+		logger.Printf("Current build is the one triggered by a pull request, won't do anything\n")
 		return nil, nil
 	}
 
-	if len(info.branch) == 0 {
-		fmt.Println("No branch info was found, fallback to \"master\"")
-		info.branch = "master"
+	if len(info.Branch) == 0 {
+		logger.Printf("No branch info was found, fallback to \"master\"\n")
+		info.Branch = "master"
 	}
 
-	fmt.Println("Commit: ", info.commit)
+	logger.Printf("Commit: %s\n", info.Commit)
 
 	repoSlugSplitted := strings.Split(repoSlug, "/")
 	if len(repoSlugSplitted) != 2 {
-		fmt.Printf("Error splitting repo slug into owner and repo: %s\n", repoSlug)
-		os.Exit(-1)
+		return nil, fmt.Errorf(
+			"Error splitting repo slug into owner and repo: %s", repoSlug)
 	}
 
-	info.owner = repoSlugSplitted[0]
-	info.repo = repoSlugSplitted[1]
+	info.Owner = repoSlugSplitted[0]
+	info.Repo = repoSlugSplitted[1]
 
 	if verbose {
-		fmt.Println("Repo = " + info.repo + ", owner = " + info.owner)
+		logger.Printf("Repo = %s, owner = %s\n", info.Repo, info.Owner)
 	}
 
-	if len(info.tag) != 0 && !strings.HasPrefix(info.tag, "continuous") && (len(releaseSuffix) == 0 || releaseSuffix == info.tag) {
-		info.releaseTitle = "Release build (" + info.tag + ")"
+	if len(info.Tag) != 0 && !strings.HasPrefix(info.Tag, "continuous") && (len(releaseSuffix) == 0 || releaseSuffix == info.Tag) {
+		info.ReleaseTitle = "Release build (" + info.Tag + ")"
 	} else if len(releaseSuffix) != 0 {
-		fmt.Printf("Suffix = %s\n", releaseSuffix)
-		info.tag = "continuous-" + releaseSuffix
-		info.releaseTitle = "Continuous build (" + info.tag + ")"
-		info.isPrerelease = true
+		logger.Printf("Suffix = %s\n", releaseSuffix)
+		info.Tag = "continuous-" + releaseSuffix
+		info.ReleaseTitle = "Continuous build (" + info.Tag + ")"
+		info.IsPrerelease = true
 	} else {
-		info.tag = "continuous" // Do not use "latest" as it is reserved by GitHub
-		info.releaseTitle = "Continuous build"
-		info.isPrerelease = true
+		info.Tag = "continuous" // Do not use "latest" as it is reserved by GitHub
+		info.ReleaseTitle = "Continuous build"
+		info.IsPrerelease = true
+	}
+
+	return &info, nil
+}
+
+func collectRepoInfo(
+	repoSlug string,
+	logger Logger,
+	verbose bool) (*BuildInfo, error) {
+
+	var info BuildInfo
+
+	// Prefer the variable used by Travis CI, fallback to the one used by
+	// AppVeyor CI configurations
+	info.Token = os.Getenv("GITHUB_TOKEN")
+	if info.Token == "" {
+		info.Token = os.Getenv("auth_token")
+	}
+
+	if info.Token == "" {
+		return nil, errors.New("No GitHub access token, can't proceed")
+	}
+
+	if os.Getenv("TRAVIS") == "true" {
+		info.Provider = ProviderTravisCi
+	} else if os.Getenv("APPVEYOR") == "True" {
+		info.Provider = ProviderAppVeyor
+	}
+
+	if len(repoSlug) == 0 {
+		if info.isTravisCi() {
+			repoSlug = os.Getenv("TRAVIS_REPO_SLUG")
+		} else {
+			repoSlug = os.Getenv("APPVEYOR_REPO_NAME")
+		}
+	}
+
+	repoSlugSplitted := strings.Split(repoSlug, "/")
+	if len(repoSlugSplitted) != 2 {
+		return nil, fmt.Errorf(
+			"Error splitting repo slug into owner and repo: %s", repoSlug)
+	}
+
+	info.Owner = repoSlugSplitted[0]
+	info.Repo = repoSlugSplitted[1]
+
+	if verbose {
+		logger.Printf("Repo = %s, owner = %s\n", info.Repo, info.Owner)
 	}
 
 	return &info, nil
@@ -0,0 +1,75 @@
+package uploader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Names of release assets recognized as checksum manifests, the manifests
+// are expected to be in the format produced by sha256sum
+var checksumManifestNames = []string{
+	"SHA256SUMS",
+	"SHA256SUMS.txt",
+	"sha256sums.txt",
+	"checksums.txt",
+}
+
+func isChecksumManifestName(name string) bool {
+	for _, manifestName := range checksumManifestNames {
+		if name == manifestName {
+			return true
+		}
+	}
+	return false
+}
+
+func parseChecksumManifest(reader io.Reader) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Malformed checksum manifest line: %s", line)
+		}
+
+		// sha256sum marks files read in binary mode with an asterisk
+		name := strings.TrimPrefix(fields[1], "*")
+		checksums[name] = strings.ToLower(fields[0])
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return checksums, nil
+}
+
+func fileSha256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return readerSha256(file)
+}
+
+func readerSha256(reader io.Reader) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, reader)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -3,21 +3,74 @@ package uploader
 import (
 	"context"
 	"io"
-	"os"
 )
 
 type Client interface {
-	GetContext() context.Context
 	GetOwner() string
 	GetRepo() string
-	GetReleaseByTag(tagName string) (Release, Response, error)
-	CreateRelease(release Release) (Release, Response, error)
-	UpdateRelease(release Release) (Release, Response, error)
-	DeleteRelease(releaseId int64) (Response, error)
-	DeleteTag(tagName string) (Response, error)
-	ListReleaseAssets(releaseId int64) ([]ReleaseAsset, Response, error)
-	DeleteReleaseAsset(assetId int64) (Response, error)
-	UploadReleaseAsset(releaseId int64, assetName string, assetFile *os.File) (ReleaseAsset, Response, error)
+	GetReleaseByTag(ctx context.Context, tagName string) (Release, Response, error)
+	CreateRelease(ctx context.Context, release Release) (Release, Response, error)
+	UpdateRelease(ctx context.Context, release Release) (Release, Response, error)
+	DeleteRelease(ctx context.Context, releaseId int64) (Response, error)
+	DeleteTag(ctx context.Context, tagName string) (Response, error)
+	UpdateTagRef(ctx context.Context, tagName string, commit string) (Response, error)
+	ListReleaseAssets(ctx context.Context, releaseId int64) ([]ReleaseAsset, Response, error)
+	DeleteReleaseAsset(ctx context.Context, assetId int64) (Response, error)
+	UploadReleaseAsset(ctx context.Context, releaseId int64, upload AssetUpload) (ReleaseAsset, Response, error)
+	DownloadReleaseAsset(ctx context.Context, assetId int64) (io.ReadCloser, Response, error)
+}
+
+// AssetUpload is the content of the release asset to be uploaded. Size must
+// be the exact number of bytes Content yields since GitHub requires
+// the content length to be known before the upload starts.
+type AssetUpload struct {
+	Name string
+
+	// MIME type of the content, if empty it is guessed from the name
+	ContentType string
+
+	// Optional display name shown instead of the name on the release page
+	Label string
+
+	Size    int64
+	Content io.Reader
+}
+
+// ReleaseAssetLabelUpdater is implemented by clients which can change
+// the labels of the existing release assets without re-uploading them
+type ReleaseAssetLabelUpdater interface {
+	UpdateReleaseAssetLabel(ctx context.Context, assetId int64, label string) (ReleaseAsset, Response, error)
+}
+
+// ReleaseAssetRenamer is implemented by clients which can change the names
+// of the existing release assets
+type ReleaseAssetRenamer interface {
+	RenameReleaseAsset(ctx context.Context, assetId int64, name string) (ReleaseAsset, Response, error)
+}
+
+// ReleaseAssetCopier is implemented by clients which can copy the release
+// asset into another release on the server side, keeping its name, label
+// and content type
+type ReleaseAssetCopier interface {
+	CopyReleaseAsset(ctx context.Context, assetId int64, releaseId int64) (ReleaseAsset, Response, error)
+}
+
+// RepositoryFileUpdater is implemented by clients which can commit files
+// into other repositories, i.e. package manifests into Scoop buckets or
+// Homebrew taps
+type RepositoryFileUpdater interface {
+	UpdateRepositoryFile(ctx context.Context, file RepositoryFile) (Response, error)
+}
+
+// RepositoryFile is the content of the file to be created or updated within
+// the branch of the repository, the default branch if Branch is empty
+type RepositoryFile struct {
+	Owner   string
+	Repo    string
+	Branch  string
+	Path    string
+	Content []byte
+	Message string
 }
 
 type Release interface {
@@ -27,7 +80,9 @@ type Release interface {
 	SetBody(body string)
 	GetTagName() string
 	GetTargetCommitish() string
+	SetTargetCommitish(commitish string)
 	GetDraft() bool
+	SetDraft(draft bool)
 	GetPrerelease() bool
 	GetAssets() []ReleaseAsset
 }
@@ -43,5 +98,8 @@ type Response interface {
 type ReleaseAsset interface {
 	GetID() int64
 	GetName() string
+	GetSize() int64
+	GetLabel() string
+	GetContentType() string
 	GetDescription() string
 }
@@ -1,21 +1,109 @@
 package uploader
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
-func commandLineFiles(files []string) []string {
-	if runtime.GOOS == "windows" {
-		args := make([]string, 0, len(files))
-		for _, name := range files {
-			if matches, err := filepath.Glob(name); err != nil {
-				args = append(args, name) // Invalid pattern
-			} else if matches != nil { // At least one match
-				args = append(args, matches...)
+// Expands the glob patterns among the files the same way on all platforms
+// since the patterns are not always expanded by the shell, i.e. when they
+// are quoted within CI configs. Patterns starting with "!" remove the files
+// matched by the preceding patterns.
+func commandLineFiles(
+	files []string,
+	allowEmpty bool,
+	logger Logger) ([]string, error) {
+
+	args := make([]string, 0, len(files))
+	for _, name := range files {
+		if name == stdinFilename {
+			args = append(args, name)
+			continue
+		}
+
+		negated := strings.HasPrefix(name, "!")
+		pattern := strings.TrimPrefix(name, "!")
+
+		// Existing files are taken literally even if their names look like
+		// patterns
+		_, err := os.Stat(pattern)
+		if err == nil || !hasGlobMeta(pattern) {
+			if negated {
+				args = removeFiles(args, []string{pattern})
+			} else {
+				args = append(args, pattern)
 			}
+			continue
+		}
+
+		matches, err := expandGlob(pattern, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		if negated {
+			args = removeFiles(args, matches)
+			continue
+		}
+
+		if len(matches) == 0 && !allowEmpty {
+			return nil, fmt.Errorf("Pattern %s doesn't match any files", pattern)
+		}
+
+		args = append(args, matches...)
+	}
+
+	return removeDuplicateFiles(args), nil
+}
+
+func expandGlob(pattern string, logger Logger) ([]string, error) {
+	if runtime.GOOS == "windows" {
+		pattern = filepath.ToSlash(pattern)
+	}
+
+	var matches []string
+	for _, alternative := range expandBraces(pattern) {
+		err := validateGlob(alternative)
+		if err != nil {
+			return nil, err
+		}
+
+		alternativeMatches, err := globFiles(alternative, logger)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, alternativeMatches...)
+	}
+	return matches, nil
+}
+
+func removeFiles(files []string, removedFiles []string) []string {
+	removed := make(map[string]bool)
+	for _, file := range removedFiles {
+		removed[filepath.Clean(file)] = true
+	}
+
+	remainingFiles := make([]string, 0, len(files))
+	for _, file := range files {
+		if !removed[filepath.Clean(file)] {
+			remainingFiles = append(remainingFiles, file)
+		}
+	}
+	return remainingFiles
+}
+
+func removeDuplicateFiles(files []string) []string {
+	found := make(map[string]bool)
+	uniqueFiles := make([]string, 0, len(files))
+	for _, file := range files {
+		if found[filepath.Clean(file)] {
+			continue
 		}
-		return args
+		found[filepath.Clean(file)] = true
+		uniqueFiles = append(uniqueFiles, file)
 	}
-	return files
+	return uniqueFiles
 }
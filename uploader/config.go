@@ -0,0 +1,153 @@
+package uploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config is the content of the JSON config file with the settings which
+// don't fit into command line flags
+type Config struct {
+	UpdateFeed *UpdateFeedConfig `json:"update_feed"`
+	Appcast    *AppcastConfig    `json:"appcast"`
+	Scoop      *ScoopConfig      `json:"scoop"`
+	Homebrew   *HomebrewConfig   `json:"homebrew"`
+	Assets     []AssetConfig     `json:"assets"`
+}
+
+// AssetConfig sets the label and the content type of the release assets
+// which names match the glob pattern
+type AssetConfig struct {
+	Pattern string `json:"pattern"`
+
+	// Optional text/template template of the display name of the asset
+	// executed with BuildInfo and the Name of the asset as data,
+	// i.e. "Windows x64 installer ({{.ShortCommit}})"
+	Label string `json:"label"`
+
+	// Optional MIME type of the content overriding the one guessed from
+	// the name of the asset
+	ContentType string `json:"content_type"`
+}
+
+// UpdateFeedConfig describes the update feed manifest for in-app
+// auto-updaters uploaded along with the binaries
+type UpdateFeedConfig struct {
+	// Name of the release asset with the manifest, latest.json by default
+	Name string `json:"name"`
+
+	// Glob patterns of release asset names by platform keys,
+	// i.e. "linux-x86_64": "*-x86_64.AppImage"
+	Platforms map[string]string `json:"platforms"`
+}
+
+// AppcastConfig describes the Sparkle appcast for macOS builds uploaded
+// along with the binaries
+type AppcastConfig struct {
+	// Name of the release asset with the appcast, appcast.xml by default
+	Name string `json:"name"`
+
+	// Glob pattern of the name of the release asset the appcast points to,
+	// "*.{dmg,zip}" by default
+	Pattern string `json:"pattern"`
+
+	// Title of the appcast channel, the name of the repo by default
+	Title string `json:"title"`
+
+	// Optional text/template templates for the sparkle:version and
+	// sparkle:shortVersionString executed with BuildInfo as data,
+	// the version is the tag without "v" prefix by default
+	Version      string `json:"version"`
+	ShortVersion string `json:"short_version"`
+
+	// Optional minimum macOS version required by the app
+	MinimumSystemVersion string `json:"minimum_system_version"`
+
+	// Environment variable with the ed25519 private key signing the binary,
+	// SPARKLE_ED_PRIVATE_KEY by default
+	KeyEnv string `json:"key_env"`
+}
+
+// ScoopConfig describes the Scoop manifest generated for tagged releases
+type ScoopConfig struct {
+	// Name of the app, the manifest is uploaded as <name>.json; the name of
+	// the repo by default
+	Name string `json:"name"`
+
+	// Glob pattern of the name of the release asset with the app or glob
+	// patterns by Scoop architectures: "64bit", "32bit" and "arm64"
+	Pattern       string            `json:"pattern"`
+	Architectures map[string]string `json:"architectures"`
+
+	Description string   `json:"description"`
+	Homepage    string   `json:"homepage"`
+	License     string   `json:"license"`
+	Bin         []string `json:"bin"`
+	ExtractDir  string   `json:"extract_dir"`
+
+	// Optional bucket repository the manifest is committed into,
+	// bucket/<name>.json by default
+	Bucket *RepositoryFileConfig `json:"bucket"`
+}
+
+// HomebrewConfig describes the Homebrew formula or cask generated for
+// tagged releases
+type HomebrewConfig struct {
+	// Name of the formula or the token of the cask, it is uploaded as
+	// <name>.rb; the name of the repo by default
+	Name string `json:"name"`
+
+	// Generate the cask installing the app instead of the formula
+	Cask bool `json:"cask"`
+
+	// Glob pattern of the name of the release asset with the binaries
+	Pattern string `json:"pattern"`
+
+	Description string `json:"description"`
+	Homepage    string `json:"homepage"`
+	License     string `json:"license"`
+
+	// Binaries installed by the formula
+	Bin []string `json:"bin"`
+
+	// App installed by the cask, i.e. "MyApp.app"
+	App string `json:"app"`
+
+	// Optional tap repository the formula or the cask is committed into,
+	// Formula/<name>.rb or Casks/<name>.rb by default
+	Tap *RepositoryFileConfig `json:"tap"`
+}
+
+// RepositoryFileConfig describes where the generated file is committed
+type RepositoryFileConfig struct {
+	// Repository as owner/repo
+	Repo string `json:"repo"`
+
+	// Branch to commit to, the default branch of the repository by default
+	Branch string `json:"branch"`
+
+	// Path of the file within the repository
+	Path string `json:"path"`
+}
+
+// LoadConfig reads the JSON config file, unknown keys are rejected so that
+// typos don't go unnoticed
+func LoadConfig(filename string) (*Config, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var config Config
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse config file %s: %v",
+			filename, err)
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,255 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Suffix of the delta patches between the binaries of consecutive
+// continuous builds
+const deltaPatchSuffix = ".patch"
+
+// Length of the abbreviated commit SHAs within the names of patches
+const shortCommitLength = 7
+
+// Computing the patch takes the memory many times the size of the binaries,
+// so no patches are computed for the binaries larger than that
+var maxDeltaPatchBinarySize int64 = 64 << 20
+
+// The binary of the previous build the patch is computed against
+type previousAsset struct {
+	filename string
+	commit   string
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > shortCommitLength {
+		return commit[:shortCommitLength]
+	}
+	return commit
+}
+
+func deltaPatchName(name string, oldCommit string, newCommit string) string {
+	return fmt.Sprintf("%s.%s-%s%s", name, shortCommit(oldCommit),
+		shortCommit(newCommit), deltaPatchSuffix)
+}
+
+func isDeltaPatchOf(assetName string, name string) bool {
+	if !strings.HasPrefix(assetName, name+".") ||
+		!strings.HasSuffix(assetName, deltaPatchSuffix) {
+		return false
+	}
+
+	commits := strings.Split(strings.TrimSuffix(
+		assetName[len(name)+1:], deltaPatchSuffix), "-")
+	if len(commits) != 2 {
+		return false
+	}
+
+	for _, commit := range commits {
+		if len(commit) == 0 || strings.Trim(commit, "0123456789abcdef") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Downloads the binaries of the previous continuous build which are about to
+// be replaced by the binaries with the same names. Patches are an
+// optimization so failures are only logged.
+func (uploader *Uploader) downloadPreviousAssets(
+	ctx context.Context,
+	release Release) {
+
+	logger := uploader.logger
+	assets, response, err := uploader.client.ListReleaseAssets(
+		ctx, release.GetID())
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		logger.Printf("Failed to list release assets of the previous build, "+
+			"no delta patches will be uploaded: %v\n", err)
+		return
+	}
+
+	tmpDir, err := uploader.tempDir()
+	if err != nil {
+		logger.Printf("Failed to create temporary dir for the binaries of "+
+			"the previous build: %v\n", err)
+		return
+	}
+
+	for _, asset := range assets {
+		name := asset.GetName()
+		source := uploader.deltaSource(name)
+		if asset.GetID() == 0 || source == nil {
+			continue
+		}
+
+		if asset.GetSize() > maxDeltaPatchBinarySize ||
+			source.size > maxDeltaPatchBinarySize {
+			logger.Printf("Not computing delta patch for %s larger than "+
+				"%d bytes\n", source.name, maxDeltaPatchBinarySize)
+			continue
+		}
+
+		logger.Printf("Downloading %s of the previous build to compute "+
+			"delta patch\n", name)
+		filename := filepath.Join(tmpDir, "previous-"+name)
+		err = uploader.downloadReleaseAssetToFile(ctx, asset, filename)
+		if err != nil {
+			logger.Printf("Failed to download %s of the previous build: %v\n",
+				name, err)
+			continue
+		}
+
+		uploader.previousAssets[source.name] = previousAsset{
+			filename: filename,
+			commit:   release.GetTargetCommitish(),
+		}
+	}
+}
+
+// Returns the source replacing the binary of the previous build, which name
+// might carry other build metadata
+func (uploader *Uploader) deltaSource(assetName string) *assetSource {
+	for _, source := range uploader.deltaSources {
+		if assetName == source.name || source.isPreviousUploadOf(assetName) {
+			return source
+		}
+	}
+	return nil
+}
+
+func (uploader *Uploader) downloadReleaseAssetToFile(
+	ctx context.Context,
+	asset ReleaseAsset,
+	filename string) error {
+
+	content, response, err := uploader.client.DownloadReleaseAsset(
+		ctx, asset.GetID())
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, content)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Adds the patch from the binary of the previous build after each source
+// replacing one. The patches of the earlier builds are replaced along with
+// the binary since they are computed against binaries no longer available.
+// Patches are an optimization so failures to compute them are only logged.
+func (uploader *Uploader) addDeltaPatchSources(
+	sources []*assetSource) []*assetSource {
+
+	var allSources []*assetSource
+	for _, source := range sources {
+		allSources = append(allSources, source)
+		if len(source.splitFrom) != 0 {
+			continue
+		}
+
+		for _, asset := range uploader.existingReleaseAssets {
+			if isDeltaPatchOf(asset.GetName(), source.name) {
+				source.companions = append(source.companions, asset.GetName())
+			}
+		}
+
+		previous, ok := uploader.previousAssets[source.name]
+		if !ok {
+			continue
+		}
+
+		uploader.logger.Printf("Computing delta patch for %s from the "+
+			"previous build\n", source.name)
+		patch, err := deltaPatch(previous.filename, source)
+		if err != nil {
+			uploader.logger.Printf("Failed to compute delta patch for %s: %v\n",
+				source.name, err)
+			continue
+		}
+
+		patchSource := memoryAssetSource(source.filename,
+			deltaPatchName(source.name, previous.commit, uploader.info.Commit),
+			patch)
+		patchSource.contentType = "application/octet-stream"
+		allSources = append(allSources, patchSource)
+	}
+
+	return allSources
+}
+
+func deltaPatch(oldFilename string, source *assetSource) ([]byte, error) {
+	old, err := ioutil.ReadFile(oldFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := source.open()
+	if err != nil {
+		return nil, err
+	}
+	new, err := ioutil.ReadAll(content)
+	content.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var patch bytes.Buffer
+	err = writeBsdiffPatch(&patch, old, new)
+	if err != nil {
+		return nil, err
+	}
+	return patch.Bytes(), nil
+}
+
+// ApplyPatch reconstructs the new binary from the old one and the delta
+// patch uploaded along with the new binary
+func ApplyPatch(oldFilename string, patchFilename string, newFilename string) error {
+	old, err := ioutil.ReadFile(oldFilename)
+	if err != nil {
+		return err
+	}
+
+	patch, err := os.Open(patchFilename)
+	if err != nil {
+		return err
+	}
+	defer patch.Close()
+
+	new, err := applyBsdiffPatch(old, patch)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	stat, err := os.Stat(oldFilename)
+	if err == nil {
+		mode = stat.Mode().Perm()
+	}
+	return ioutil.WriteFile(newFilename, new, mode)
+}
@@ -0,0 +1,240 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestQsufsort(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, size := range []int{0, 1, 2, 15, 16, 17, 100, 1000} {
+		data := make([]byte, size)
+		for i := range data {
+			// Small alphabet produces long common prefixes
+			data[i] = byte('a' + random.Intn(3))
+		}
+
+		expected := make([]int, size+1)
+		for i := range expected {
+			expected[i] = i
+		}
+		sort.Slice(expected, func(i, j int) bool {
+			return bytes.Compare(data[expected[i]:], data[expected[j]:]) < 0
+		})
+
+		suffixes := qsufsort(data)
+		for i := range expected {
+			if int(suffixes[i]) != expected[i] {
+				t.Fatalf("Unexpected suffix array of %q: %v", data, suffixes)
+			}
+		}
+	}
+}
+
+func TestBsdiffRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	old := make([]byte, 100000)
+	random.Read(old)
+
+	// Changes scattered over the binary along with inserted and removed
+	// chunks, as usual between builds
+	new := append([]byte{}, old[:30000]...)
+	new = append(new, []byte("inserted chunk")...)
+	new = append(new, old[30000:60000]...)
+	new = append(new, old[61000:]...)
+	for i := 0; i < 100; i++ {
+		new[random.Intn(len(new))]++
+	}
+
+	testCases := []struct {
+		old []byte
+		new []byte
+	}{
+		{old, new},
+		{nil, []byte("new content")},
+		{[]byte("old content"), nil},
+		{[]byte("same"), []byte("same")},
+	}
+
+	for _, testCase := range testCases {
+		var patch bytes.Buffer
+		err := writeBsdiffPatch(&patch, testCase.old, testCase.new)
+		if err != nil {
+			t.Fatalf("Failed to compute the patch: %v", err)
+		}
+
+		if len(testCase.new) > 1000 && patch.Len() > len(testCase.new)/10 {
+			t.Fatalf("The patch is too large: %d bytes", patch.Len())
+		}
+
+		result, err := applyBsdiffPatch(
+			testCase.old, bytes.NewReader(patch.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to apply the patch: %v", err)
+		}
+		if !bytes.Equal(result, testCase.new) {
+			t.Fatalf("The patched binary differs from the new one")
+		}
+
+		_, err = applyBsdiffPatch(
+			append(testCase.old, 'x'), bytes.NewReader(patch.Bytes()))
+		if err == nil {
+			t.Fatalf("The patch was unexpectedly applied to other binary")
+		}
+	}
+}
+
+func TestDeltaPatchesBetweenContinuousBuilds(t *testing.T) {
+	dir := t.TempDir()
+
+	factory := newSharedTstClientFactory()
+
+	// The release is recreated for the second build and its tag is moved
+	// for the third one
+	builds := []struct {
+		commit    string
+		content   string
+		updateTag bool
+	}{
+		{"1111111aaaa", "first build of the app", false},
+		{"2222222bbbb", "second build of the app", false},
+		{"3333333cccc", "third build of the app!", true},
+	}
+
+	filename := filepath.Join(dir, "app.bin")
+	for i, build := range builds {
+		setupTravisCiEnvVars(build.commit, "master", "continuous-master",
+			"d1vanov/ciuploadtool", false)
+
+		err := ioutil.WriteFile(filename, []byte(build.content), 0755)
+		if err != nil {
+			t.Fatalf("Failed to write the sample file: %v", err)
+		}
+
+		_, err = uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{filename},
+			Options{
+				ReleaseSuffix: "master",
+				DeltaPatches:  true,
+				UpdateTag:     build.updateTag,
+			})
+		if err != nil {
+			t.Fatalf("Failed to upload the file: %v", err)
+		}
+
+		assets := factory.client.releases[len(factory.client.releases)-1].GetAssets()
+		if i == 0 {
+			if len(assets) != 1 {
+				t.Fatalf("Unexpected release assets: %v", assets)
+			}
+			continue
+		}
+
+		patchName := deltaPatchName("app.bin", builds[i-1].commit,
+			build.commit)
+		var patch []byte
+		for _, asset := range assets {
+			if asset.GetName() == patchName {
+				patch = []byte(asset.(TstReleaseAsset).GetContent())
+			}
+		}
+		if len(assets) != 2 || patch == nil {
+			t.Fatalf("Expected the binary and patch %s, found %v",
+				patchName, assets)
+		}
+
+		result, err := applyBsdiffPatch(
+			[]byte(builds[i-1].content), bytes.NewReader(patch))
+		if err != nil || string(result) != build.content {
+			t.Fatalf("Failed to apply the patch: %v", err)
+		}
+	}
+}
+
+func TestDeltaPatchIsSkippedForLargeBinaries(t *testing.T) {
+	dir := t.TempDir()
+	factory := newSharedTstClientFactory()
+
+	defer func(size int64) {
+		maxDeltaPatchBinarySize = size
+	}(maxDeltaPatchBinarySize)
+	maxDeltaPatchBinarySize = 8
+
+	filename := filepath.Join(dir, "app.bin")
+	for _, commit := range []string{"1111111aaaa", "2222222bbbb"} {
+		setupTravisCiEnvVars(commit, "master", "continuous-master",
+			"d1vanov/ciuploadtool", false)
+
+		err := ioutil.WriteFile(filename, []byte("build "+commit), 0755)
+		if err != nil {
+			t.Fatalf("Failed to write the sample file: %v", err)
+		}
+
+		_, err = uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{filename},
+			Options{ReleaseSuffix: "master", DeltaPatches: true})
+		if err != nil {
+			t.Fatalf("Failed to upload the file: %v", err)
+		}
+	}
+
+	assets := factory.client.releases[0].GetAssets()
+	if len(assets) != 1 || assets[0].GetName() != "app.bin" {
+		t.Fatalf("Unexpected release assets: %v", assets)
+	}
+}
+
+func TestDeltaPatchFailureIsOnlyLogged(t *testing.T) {
+	logger := &tstLogger{}
+	uploader := &Uploader{
+		logger: logger,
+		info:   &BuildInfo{Commit: "2222222bbbb"},
+		previousAssets: map[string]previousAsset{
+			"app.bin": {
+				filename: filepath.Join(t.TempDir(), "missing"),
+				commit:   "1111111aaaa",
+			},
+		},
+	}
+
+	sources := uploader.addDeltaPatchSources([]*assetSource{
+		memoryAssetSource("app.bin", "app.bin", []byte("new build")),
+	})
+	if len(sources) != 1 || sources[0].name != "app.bin" {
+		t.Fatalf("Unexpected sources: %v", sources)
+	}
+
+	if len(logger.messages) != 2 ||
+		!strings.HasPrefix(logger.messages[1], "Failed to compute delta patch") {
+		t.Fatalf("The failure was not logged: %v", logger.messages)
+	}
+}
+
+func TestIsDeltaPatchOf(t *testing.T) {
+	if !isDeltaPatchOf("app.bin.1234567-89abcde.patch", "app.bin") {
+		t.Fatalf("The patch was not recognized")
+	}
+
+	for _, name := range []string{
+		"app.bin.patch",
+		"app.bin.1234567.patch",
+		"app.bin.xyz-1234567.patch",
+		"app.bin2.1234567-89abcde.patch",
+	} {
+		if isDeltaPatchOf(name, "app.bin") {
+			t.Fatalf("%s was unexpectedly recognized as the patch", name)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package uploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Download downloads the assets of the release with the given tag matching
+// the pattern into the directory, verifying their checksums if the release
+// has the checksum manifest
+func Download(
+	ctx context.Context,
+	tag string,
+	pattern string,
+	outDir string,
+	repoSlug string,
+	verbose bool) error {
+
+	_, err := downloadImpl(
+		ctx,
+		clientFactoryFunc(newGitHubClient),
+		tag,
+		pattern,
+		outDir,
+		repoSlug,
+		stdoutLogger{},
+		verbose)
+	return err
+}
+
+func downloadImpl(
+	ctx context.Context,
+	clientFactory clientFactoryFunc,
+	tag string,
+	pattern string,
+	outDir string,
+	repoSlug string,
+	logger Logger,
+	verbose bool) (Client, error) {
+
+	if len(tag) == 0 {
+		return nil, errors.New("The tag of the release to download from is required")
+	}
+
+	if len(pattern) == 0 {
+		pattern = "*"
+	}
+
+	// Check the pattern before any API call is made
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid asset name pattern %s: %v", pattern, err)
+	}
+
+	info, err := collectRepoInfo(repoSlug, logger, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	client := clientFactory(info.Token, info.Owner, info.Repo)
+
+	release, response, err := client.GetReleaseByTag(ctx, tag)
+	response.CloseBody()
+	if err != nil {
+		return client, fmt.Errorf("Failed to find release %s: %v", tag, err)
+	}
+
+	err = response.Check()
+	if err != nil {
+		return client, fmt.Errorf(
+			"Bad response on attempt to find the release: %v", err)
+	}
+
+	assets, response, err := client.ListReleaseAssets(ctx, release.GetID())
+	response.CloseBody()
+	if err != nil {
+		return client, err
+	}
+
+	err = response.Check()
+	if err != nil {
+		return client, fmt.Errorf(
+			"Bad response on attempt to list release assets: %v", err)
+	}
+
+	var checksums map[string]string
+	var matchingAssets []ReleaseAsset
+	for _, asset := range assets {
+		if verbose {
+			logger.Printf("Examing release asset: %s\n", asset.GetDescription())
+		}
+
+		if checksums == nil && isChecksumManifestName(asset.GetName()) {
+			logger.Printf("Found checksum manifest %s\n", asset.GetName())
+			checksums, err = downloadChecksumManifest(ctx, client, asset)
+			if err != nil {
+				return client, err
+			}
+		}
+
+		matches, _ := path.Match(pattern, asset.GetName())
+		if matches && !isTransientAsset(asset.GetName()) {
+			matchingAssets = append(matchingAssets, asset)
+		}
+	}
+
+	if len(matchingAssets) == 0 {
+		return client, fmt.Errorf(
+			"No assets of release %s match pattern %s", tag, pattern)
+	}
+
+	if len(outDir) != 0 {
+		err = os.MkdirAll(outDir, 0755)
+		if err != nil {
+			return client, err
+		}
+	}
+
+	mismatches := 0
+	for _, asset := range matchingAssets {
+		filename := filepath.Join(outDir, asset.GetName())
+		logger.Printf("Downloading release asset %s to %s\n",
+			asset.GetName(), filename)
+
+		digest, err := downloadReleaseAssetToFile(ctx, client, asset, filename)
+		if err != nil {
+			return client, err
+		}
+
+		if checksums == nil {
+			continue
+		}
+
+		expectedDigest, ok := checksums[asset.GetName()]
+		if !ok {
+			if !isChecksumManifestName(asset.GetName()) {
+				logger.Printf("No checksum for release asset %s within "+
+					"the manifest\n", asset.GetName())
+			}
+			continue
+		}
+
+		if expectedDigest != digest {
+			logger.Printf("Checksum mismatch for release asset %s: expected "+
+				"%s, got %s\n", asset.GetName(), expectedDigest, digest)
+			os.Remove(filename)
+			mismatches++
+		} else if verbose {
+			logger.Printf("Verified checksum of release asset %s\n",
+				asset.GetName())
+		}
+	}
+
+	if mismatches != 0 {
+		return client, fmt.Errorf(
+			"Checksum verification failed for %d release asset(s)", mismatches)
+	}
+
+	return client, nil
+}
+
+func downloadChecksumManifest(
+	ctx context.Context,
+	client Client,
+	asset ReleaseAsset) (map[string]string, error) {
+
+	content, response, err := client.DownloadReleaseAsset(ctx, asset.GetID())
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Bad response on attempt to download checksum manifest: %v", err)
+	}
+
+	checksums, err := parseChecksumManifest(content)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Failed to parse checksum manifest %s: %v", asset.GetName(), err)
+	}
+
+	return checksums, nil
+}
+
+func downloadReleaseAssetToFile(
+	ctx context.Context,
+	client Client,
+	asset ReleaseAsset,
+	filename string) (string, error) {
+
+	content, response, err := client.DownloadReleaseAsset(ctx, asset.GetID())
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return "", fmt.Errorf(
+			"Bad response on attempt to download release asset: %v", err)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), content)
+	if err != nil {
+		return "", fmt.Errorf(
+			"Failed to download release asset %s: %v", asset.GetName(), err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,138 @@
+package uploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadOfMatchingReleaseAssets(t *testing.T) {
+	assetContents := map[string]string{
+		"first.AppImage":  "First AppImage content",
+		"second.AppImage": "Second AppImage content",
+		"third.zip":       "Zip content",
+	}
+
+	manifest := ""
+	for _, name := range []string{"first.AppImage", "second.AppImage", "third.zip"} {
+		manifest += tstSha256(assetContents[name]) + "  " + name + "\n"
+	}
+
+	outDir := t.TempDir()
+
+	setupTravisCiEnvVars(
+		generateRandomString(16), "master", "", "d1vanov/ciuploadtool", false)
+
+	_, err := downloadImpl(
+		context.Background(),
+		clientFactoryFunc(newTstClientWithAssets(assetContents, manifest)),
+		"continuous-master",
+		"*.AppImage",
+		outDir,
+		"",
+		stdoutLogger{},
+		false)
+	if err != nil {
+		t.Fatalf("Failed to download release assets: %v", err)
+	}
+
+	files, err := ioutil.ReadDir(outDir)
+	if err != nil {
+		t.Fatalf("Failed to list the output dir: %v", err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("Detected wrong number of downloaded files: want 2, have %d",
+			len(files))
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(outDir, file.Name()))
+		if err != nil {
+			t.Fatalf("Failed to read the downloaded file: %v", err)
+		}
+
+		if string(content) != assetContents[file.Name()] {
+			t.Fatalf("The contents of the downloaded file %s don't match "+
+				"the release asset's contents", file.Name())
+		}
+	}
+}
+
+func TestDownloadOfReleaseAssetWithChecksumMismatch(t *testing.T) {
+	assetContents := map[string]string{
+		"first.AppImage":  "First AppImage content",
+		"second.AppImage": "Second AppImage content",
+	}
+
+	manifest := tstSha256(assetContents["first.AppImage"]) +
+		"  first.AppImage\n" + tstSha256("Truncated") + " *second.AppImage\n"
+
+	outDir := t.TempDir()
+
+	setupTravisCiEnvVars(
+		generateRandomString(16), "master", "", "d1vanov/ciuploadtool", false)
+
+	_, err := downloadImpl(
+		context.Background(),
+		clientFactoryFunc(newTstClientWithAssets(assetContents, manifest)),
+		"continuous-master",
+		"*.AppImage",
+		outDir,
+		"",
+		stdoutLogger{},
+		false)
+	if err == nil {
+		t.Fatalf("Download of release asset with mismatching checksum " +
+			"unexpectedly succeeded")
+	}
+
+	_, err = os.Stat(filepath.Join(outDir, "second.AppImage"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("The release asset with mismatching checksum was not removed")
+	}
+}
+
+func newTstClientWithAssets(
+	assetContents map[string]string,
+	manifest string) clientFactoryFunc {
+
+	return func(gitHubToken string, owner string, repo string) Client {
+		tstRelease := TstRelease{
+			id:              lastFreeReleaseId,
+			name:            "Continuous build (continuous-master)",
+			tagName:         "continuous-master",
+			targetCommitish: generateRandomString(16),
+			isPrerelease:    true,
+		}
+		lastFreeReleaseId++
+
+		contents := map[string]string{"SHA256SUMS": manifest}
+		for name, content := range assetContents {
+			contents[name] = content
+		}
+
+		for name, content := range contents {
+			tstRelease.assets = append(tstRelease.assets, TstReleaseAsset{
+				id:      lastFreeReleaseAssetId,
+				name:    name,
+				content: content,
+			})
+			lastFreeReleaseAssetId++
+		}
+
+		tstClient := newTstClient(gitHubToken, owner, repo).(*TstClient)
+		tstClient.releases = append(tstClient.releases, tstRelease)
+		tstClient.tagNames = append(tstClient.tagNames, tstRelease.tagName)
+		return tstClient
+	}
+}
+
+func tstSha256(content string) string {
+	digest := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(digest[:])
+}
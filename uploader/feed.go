@@ -0,0 +1,206 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default name of the update feed manifest release asset
+const defaultUpdateFeedName = "latest.json"
+
+// Update feed manifest polled by in-app auto-updaters
+type updateFeed struct {
+	Version   string                        `json:"version"`
+	Tag       string                        `json:"tag"`
+	Commit    string                        `json:"commit"`
+	PubDate   string                        `json:"pub_date"`
+	Platforms map[string]updateFeedPlatform `json:"platforms"`
+}
+
+type updateFeedPlatform struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+func validateUpdateFeedConfig(config *UpdateFeedConfig) error {
+	if strings.ContainsAny(config.Name, "/\\") {
+		return fmt.Errorf("Invalid update feed name %s", config.Name)
+	}
+
+	if len(config.Platforms) == 0 {
+		return errors.New("No platforms are configured for the update feed")
+	}
+
+	for platform, pattern := range config.Platforms {
+		err := validateGlob(pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid pattern of platform %s: %v",
+				platform, err)
+		}
+	}
+
+	return nil
+}
+
+func updateFeedName(config *UpdateFeedConfig) string {
+	if len(config.Name) == 0 {
+		return defaultUpdateFeedName
+	}
+	return config.Name
+}
+
+func releaseAssetDownloadURL(
+	owner string,
+	repo string,
+	tag string,
+	name string) string {
+
+	return fmt.Sprintf("https://github.com/%s/%s/releases/download/%s/%s",
+		owner, repo, url.PathEscape(tag), url.PathEscape(name))
+}
+
+// Matches the release assets uploaded by the current build job against
+// the platform patterns. Assets may serve several platforms, i.e. universal
+// macOS binaries, but each platform must be served by a single asset.
+func (uploader *Uploader) updateFeedPlatforms() (
+	map[string]updateFeedPlatform, error) {
+
+	config := uploader.options.UpdateFeed
+	tag := uploader.info.Tag
+	platforms := make(map[string]updateFeedPlatform)
+	for _, asset := range uploader.result.Assets {
+		digest, ok := uploader.assetDigests[asset.GetName()]
+		if !ok {
+			continue
+		}
+
+		for platform, pattern := range config.Platforms {
+			if !matchGlob(pattern, asset.GetName()) {
+				continue
+			}
+
+			existing, ok := platforms[platform]
+			if ok {
+				return nil, fmt.Errorf("Both %s and %s match the pattern "+
+					"of platform %s", existing.Name, asset.GetName(), platform)
+			}
+
+			platforms[platform] = updateFeedPlatform{
+				Name: asset.GetName(),
+				URL: releaseAssetDownloadURL(uploader.client.GetOwner(),
+					uploader.client.GetRepo(), tag, asset.GetName()),
+				Size:   asset.GetSize(),
+				Sha256: digest,
+			}
+		}
+	}
+
+	return platforms, nil
+}
+
+// Merges the platforms of the current build job into the feed manifest
+// within the release. Other build jobs of the matrix contribute their own
+// platforms to the same manifest so it's read and replaced holding its lock.
+func (uploader *Uploader) updateFeed(ctx context.Context) error {
+	platforms, err := uploader.updateFeedPlatforms()
+	if err != nil {
+		return err
+	}
+
+	logger := uploader.logger
+	name := updateFeedName(uploader.options.UpdateFeed)
+	if len(platforms) == 0 {
+		logger.Printf("No uploaded binaries match the platforms of "+
+			"the update feed, leaving %s as is\n", name)
+		return nil
+	}
+
+	return uploader.withLock(ctx, name, func() error {
+		feed, err := uploader.readUpdateFeed(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		// The platforms built for another commit are stale
+		info := uploader.info
+		if feed == nil || feed.Commit != info.Commit {
+			feed = &updateFeed{Platforms: make(map[string]updateFeedPlatform)}
+		}
+
+		feed.Version = strings.TrimPrefix(info.Tag, "v")
+		feed.Tag = info.Tag
+		feed.Commit = info.Commit
+		feed.PubDate = time.Now().UTC().Format(time.RFC3339)
+		for platform, entry := range platforms {
+			feed.Platforms[platform] = entry
+		}
+
+		content, err := json.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		logger.Printf("Uploading update feed %s for platforms %s\n", name,
+			strings.Join(sortedUpdateFeedPlatforms(platforms), ", "))
+		source := memoryAssetSource(name, name, append(content, '\n'))
+		source.contentType = "application/json"
+		_, err = uploader.replaceReleaseAsset(ctx, source)
+		return err
+	})
+}
+
+// Returns nil feed if there's no feed manifest within the release yet
+func (uploader *Uploader) readUpdateFeed(
+	ctx context.Context,
+	name string) (*updateFeed, error) {
+
+	feedAsset, err := uploader.findReleaseAsset(ctx, name)
+	if err != nil || feedAsset == nil {
+		return nil, err
+	}
+
+	content, response, err := uploader.client.DownloadReleaseAsset(
+		ctx, feedAsset.GetID())
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return nil, fmt.Errorf("Bad response on attempt to download "+
+			"the update feed: %v", err)
+	}
+
+	var feed updateFeed
+	err = json.NewDecoder(content).Decode(&feed)
+	if err != nil {
+		uploader.logger.Printf("Replacing malformed update feed %s: %v\n",
+			name, err)
+		return nil, nil
+	}
+
+	if feed.Platforms == nil {
+		feed.Platforms = make(map[string]updateFeedPlatform)
+	}
+	return &feed, nil
+}
+
+func sortedUpdateFeedPlatforms(
+	platforms map[string]updateFeedPlatform) []string {
+
+	keys := make([]string, 0, len(platforms))
+	for platform := range platforms {
+		keys = append(keys, platform)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,243 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateFeedIsMergedAcrossBuildJobs(t *testing.T) {
+	dir := t.TempDir()
+
+	// All the build jobs upload to the same release
+	factory := newSharedTstClientFactory()
+
+	config := &UpdateFeedConfig{
+		Platforms: map[string]string{
+			"linux-x86_64":   "*-x86_64.AppImage",
+			"windows-x86_64": "*-win64.zip",
+		},
+	}
+
+	commit := generateRandomString(16)
+	jobs := []struct {
+		commit   string
+		filename string
+		content  string
+		expected []string
+	}{
+		{commit, "app-x86_64.AppImage", "linux", []string{"linux-x86_64"}},
+		{commit, "app-win64.zip", "windows",
+			[]string{"linux-x86_64", "windows-x86_64"}},
+		{generateRandomString(16), "app-win64.zip", "windows",
+			[]string{"windows-x86_64"}},
+	}
+
+	for _, job := range jobs {
+		setupTravisCiEnvVars(job.commit, "master", "continuous-master",
+			"d1vanov/ciuploadtool", false)
+
+		filename := filepath.Join(dir, job.filename)
+		err := ioutil.WriteFile(filename, []byte(job.content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write the sample file: %v", err)
+		}
+
+		_, err = uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{filename},
+			Options{ReleaseSuffix: "master", UpdateFeed: config})
+		if err != nil {
+			t.Fatalf("Failed to upload the file: %v", err)
+		}
+
+		var feedContent string
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			if asset.GetName() == defaultUpdateFeedName {
+				feedContent = asset.(TstReleaseAsset).GetContent()
+			}
+		}
+
+		var feed updateFeed
+		err = json.Unmarshal([]byte(feedContent), &feed)
+		if err != nil {
+			t.Fatalf("Failed to parse the update feed: %v: %s", err,
+				feedContent)
+		}
+
+		if feed.Commit != job.commit || feed.Tag != "continuous-master" ||
+			len(feed.PubDate) == 0 {
+			t.Fatalf("Unexpected update feed: %s", feedContent)
+		}
+
+		if len(feed.Platforms) != len(job.expected) {
+			t.Fatalf("Unexpected platforms within the update feed: %s",
+				feedContent)
+		}
+
+		for _, platform := range job.expected {
+			entry, ok := feed.Platforms[platform]
+			if !ok {
+				t.Fatalf("Platform %s is missing within the update feed: %s",
+					platform, feedContent)
+			}
+
+			content := "linux"
+			if strings.HasPrefix(platform, "windows") {
+				content = "windows"
+			}
+			if entry.Size != int64(len(content)) ||
+				entry.Sha256 != tstSha256(content) ||
+				!strings.HasSuffix(entry.URL,
+					"/d1vanov/ciuploadtool/releases/download/"+
+						"continuous-master/"+entry.Name) {
+				t.Fatalf("Unexpected entry of platform %s: %+v",
+					platform, entry)
+			}
+		}
+	}
+}
+
+// Logger signalling once the build job waits for the lock held by another one
+type lockWaitingLogger struct {
+	waiting chan struct{}
+	once    sync.Once
+}
+
+func (logger *lockWaitingLogger) Printf(format string, args ...interface{}) {
+	if strings.HasPrefix(format, "Waiting for another build job to unlock") {
+		logger.once.Do(func() { close(logger.waiting) })
+	}
+}
+
+func TestUpdateFeedIsNotOverwrittenByConcurrentBuildJob(t *testing.T) {
+	dir := t.TempDir()
+	factory := newSharedTstClientFactory()
+
+	defer func(delay time.Duration) {
+		concurrentUpdateRetryDelay = delay
+	}(concurrentUpdateRetryDelay)
+	concurrentUpdateRetryDelay = 10 * time.Millisecond
+
+	config := &UpdateFeedConfig{
+		Platforms: map[string]string{
+			"linux-x86_64":   "*-x86_64.AppImage",
+			"macos-x86_64":   "*.dmg",
+			"windows-x86_64": "*-win64.zip",
+		},
+	}
+
+	commit := generateRandomString(16)
+	upload := func(name string, content string, logger Logger) error {
+		filenames := writeSampleFiles(t, dir, map[string]string{name: content})
+		setupTravisCiEnvVars(commit, "master", "continuous-master",
+			"d1vanov/ciuploadtool", false)
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			filenames,
+			Options{ReleaseSuffix: "master", UpdateFeed: config,
+				Logger: logger})
+		return err
+	}
+
+	err := upload("app-x86_64.AppImage", "linux", &tstLogger{})
+	if err != nil {
+		t.Fatalf("Failed to upload the first file: %v", err)
+	}
+
+	// The late build job runs once the early one is about to read the feed
+	// so the early one would drop the platform of the late one from the feed
+	// if the late one didn't wait for the early one
+	lateErr := make(chan error, 1)
+	lateLogger := &lockWaitingLogger{waiting: make(chan struct{})}
+	factory.client.hooks = map[string]func(){
+		"DownloadReleaseAsset": func() {
+			go func() {
+				lateErr <- upload("app.dmg", "macos", lateLogger)
+			}()
+
+			select {
+			case <-lateLogger.waiting:
+			case err := <-lateErr:
+				lateErr <- err
+				t.Errorf("The late build job didn't wait for the early one")
+			}
+		},
+	}
+
+	err = upload("app-win64.zip", "windows", &tstLogger{})
+	if err != nil {
+		t.Fatalf("Failed to upload the file of the early build job: %v", err)
+	}
+
+	err = <-lateErr
+	if err != nil {
+		t.Fatalf("Failed to upload the file of the late build job: %v", err)
+	}
+
+	var feedContent string
+	for _, asset := range factory.client.releases[0].GetAssets() {
+		if asset.GetName() == defaultUpdateFeedName {
+			feedContent = asset.(TstReleaseAsset).GetContent()
+		}
+		if isTransientAsset(asset.GetName()) {
+			t.Fatalf("Release asset %s was left behind", asset.GetName())
+		}
+	}
+
+	var feed updateFeed
+	err = json.Unmarshal([]byte(feedContent), &feed)
+	if err != nil {
+		t.Fatalf("Failed to parse the update feed: %v: %s", err, feedContent)
+	}
+
+	for platform := range config.Platforms {
+		_, ok := feed.Platforms[platform]
+		if !ok {
+			t.Fatalf("Platform %s is missing within the update feed: %s",
+				platform, feedContent)
+		}
+	}
+}
+
+func TestLoadConfigRejectsUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "ciuploadtool.json")
+	err := ioutil.WriteFile(filename, []byte(`{"update_feed": {
+		"name": "feed.json",
+		"platforms": {"darwin": "*.dmg"}
+	}}`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the config file: %v", err)
+	}
+
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("Failed to load the config file: %v", err)
+	}
+
+	if config.UpdateFeed == nil || config.UpdateFeed.Name != "feed.json" ||
+		config.UpdateFeed.Platforms["darwin"] != "*.dmg" {
+		t.Fatalf("Unexpected config: %+v", config.UpdateFeed)
+	}
+
+	err = ioutil.WriteFile(filename, []byte(`{"update_fed": {}}`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the config file: %v", err)
+	}
+
+	_, err = LoadConfig(filename)
+	if err == nil {
+		t.Fatalf("The config with unknown key was unexpectedly loaded")
+	}
+}
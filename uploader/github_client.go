@@ -8,14 +8,20 @@ import (
 	"golang.org/x/oauth2"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 )
 
+// Number of items requested per page of listings, the maximum GitHub allows
+const listPageSize = 100
+
+// Maximum number of annotated tags pointing to each other followed while
+// looking up the commit of the tag
+const maxTagDereferenceDepth = 8
+
 type GitHubClient struct {
 	client     *github.Client
 	httpClient *http.Client
-	ctx        context.Context
 	owner      string
 	repo       string
 }
@@ -41,14 +47,13 @@ func newGitHubClient(gitHubToken string, owner string, repo string) Client {
 	return GitHubClient{
 		client:     client,
 		httpClient: tokenizedClient,
-		ctx:        ctx,
 		owner:      owner,
 		repo:       repo}
 }
 
 func newGitHubRelease(
 	releaseBody string,
-	info *buildEventInfo,
+	info *BuildInfo,
 	verbose bool) Release {
 
 	release := GitHubRelease{
@@ -56,20 +61,16 @@ func newGitHubRelease(
 		repoTag: new(github.RepositoryTag)}
 
 	release.release.TagName = new(string)
-	*release.release.TagName = info.tag
+	*release.release.TagName = info.Tag
 	release.release.TargetCommitish = new(string)
-	*release.release.TargetCommitish = info.commit
+	*release.release.TargetCommitish = info.Commit
 	release.release.Name = new(string)
-	*release.release.Name = info.releaseTitle
+	*release.release.Name = info.ReleaseTitle
 	release.release.Body = new(string)
 	*release.release.Body = releaseBody
 	release.release.Prerelease = new(bool)
-	*release.release.Prerelease = info.isPrerelease
-	return updateBuildLogWithinReleaseBody(release, info, verbose)
-}
-
-func (client GitHubClient) GetContext() context.Context {
-	return client.ctx
+	*release.release.Prerelease = info.IsPrerelease
+	return updateBuildLogWithinReleaseBody(release, info)
 }
 
 func (client GitHubClient) GetOwner() string {
@@ -81,60 +82,138 @@ func (client GitHubClient) GetRepo() string {
 }
 
 func (client GitHubClient) GetReleaseByTag(
+	ctx context.Context,
 	tagName string) (Release, Response, error) {
 
 	if client.client == nil {
 		return GitHubRelease{}, GitHubResponse{}, errors.New("GitHub client is nil")
 	}
 	gitHubRelease, gitHubResponse, err := client.client.Repositories.GetReleaseByTag(
-		client.ctx,
+		ctx,
 		client.owner,
 		client.repo,
 		tagName)
 	if err != nil {
+		if gitHubResponse != nil && gitHubResponse.StatusCode == http.StatusNotFound {
+			// Draft releases are not reachable by tag as their tags don't
+			// exist until the releases are published
+			return client.getDraftReleaseByTag(ctx, tagName, gitHubResponse)
+		}
 		return GitHubRelease{}, GitHubResponse{}, err
 	}
 
-	tagList, gitHubTagListResponse, err := client.client.Repositories.ListTags(
-		client.ctx,
+	commit, err := client.getTagCommit(ctx, tagName)
+	if err != nil {
+		return GitHubRelease{}, GitHubResponse{}, fmt.Errorf(
+			"Failed to create GitHub release: failed to locate tag %s: %v",
+			tagName, err)
+	}
+
+	release := GitHubRelease{
+		release: gitHubRelease,
+		repoTag: &github.RepositoryTag{
+			Name:   github.String(tagName),
+			Commit: &github.Commit{SHA: github.String(commit)},
+		},
+	}
+	return release, GitHubResponse{response: gitHubResponse}, nil
+}
+
+// Looks up the commit the tag points to by the tag's ref. Unlike listing
+// the tags it doesn't depend on the number of tags within the repo.
+func (client GitHubClient) getTagCommit(
+	ctx context.Context,
+	tagName string) (string, error) {
+
+	ref, gitHubResponse, err := client.client.Git.GetRef(
+		ctx,
 		client.owner,
 		client.repo,
-		nil)
+		"tags/"+tagName)
 	if err != nil {
-		return GitHubRelease{}, GitHubResponse{}, err
+		return "", err
 	}
 
-	tagResp := GitHubResponse{response: gitHubTagListResponse}
-	err = tagResp.Check()
+	err = GitHubResponse{response: gitHubResponse}.Check()
 	if err != nil {
-		return GitHubRelease{}, tagResp, err
+		return "", err
+	}
+
+	// Annotated tags point to tag objects which in turn point to commits
+	object := ref.GetObject()
+	for depth := 0; object.GetType() == "tag"; depth++ {
+		if depth >= maxTagDereferenceDepth {
+			return "", fmt.Errorf("Too deeply nested annotated tag %s", tagName)
+		}
+
+		tag, _, err := client.client.Git.GetTag(
+			ctx,
+			client.owner,
+			client.repo,
+			object.GetSHA())
+		if err != nil {
+			return "", err
+		}
+		object = tag.GetObject()
+	}
+
+	if object.GetType() != "commit" {
+		return "", fmt.Errorf("Tag %s points to %s rather than to commit",
+			tagName, object.GetType())
 	}
 
-	release := GitHubRelease{release: gitHubRelease}
+	return object.GetSHA(), nil
+}
+
+// Looks for the draft release among all releases, if there's none
+// the response to the lookup by tag telling the release was not found is
+// returned so the callers can tell the missing release from the failure
+func (client GitHubClient) getDraftReleaseByTag(
+	ctx context.Context,
+	tagName string,
+	notFoundResponse *github.Response) (Release, Response, error) {
+
+	options := &github.ListOptions{PerPage: listPageSize}
+	for {
+		releaseList, gitHubResponse, err := client.client.Repositories.ListReleases(
+			ctx,
+			client.owner,
+			client.repo,
+			options)
+		if err != nil {
+			return GitHubRelease{}, GitHubResponse{}, err
+		}
 
-	for _, tag := range tagList {
-		if tag == nil {
-			continue
+		for _, gitHubRelease := range releaseList {
+			if gitHubRelease == nil || !gitHubRelease.GetDraft() {
+				continue
+			}
+			if gitHubRelease.GetTagName() != tagName {
+				continue
+			}
+			return GitHubRelease{release: gitHubRelease},
+				GitHubResponse{response: gitHubResponse}, nil
 		}
-		if tag.GetName() != tagName {
-			continue
+
+		if gitHubResponse.NextPage == 0 {
+			break
 		}
-		release.repoTag = tag
-		return release, GitHubResponse{response: gitHubResponse}, nil
+		options.Page = gitHubResponse.NextPage
 	}
 
-	return GitHubRelease{}, GitHubResponse{}, errors.New(
-		"Failed to create GitHub release: failed to locate tag")
+	return GitHubRelease{}, GitHubResponse{response: notFoundResponse},
+		errors.New("Failed to find GitHub release by tag")
 }
 
 func (client GitHubClient) CreateRelease(
+	ctx context.Context,
 	release Release) (Release, Response, error) {
 
 	if client.client == nil {
 		return GitHubRelease{}, GitHubResponse{}, errors.New("GitHub client is nil")
 	}
 	gitHubRelease, gitHubResponse, err := client.client.Repositories.CreateRelease(
-		client.ctx,
+		ctx,
 		client.owner,
 		client.repo,
 		release.(GitHubRelease).release)
@@ -143,13 +222,14 @@ func (client GitHubClient) CreateRelease(
 }
 
 func (client GitHubClient) UpdateRelease(
+	ctx context.Context,
 	release Release) (Release, Response, error) {
 
 	if client.client == nil {
 		return GitHubRelease{}, GitHubResponse{}, errors.New("GitHub client is nil")
 	}
 	gitHubRelease, gitHubResponse, err := client.client.Repositories.EditRelease(
-		client.ctx,
+		ctx,
 		client.owner,
 		client.repo,
 		release.GetID(),
@@ -158,19 +238,19 @@ func (client GitHubClient) UpdateRelease(
 		GitHubResponse{response: gitHubResponse}, err
 }
 
-func (client GitHubClient) DeleteRelease(releaseId int64) (Response, error) {
+func (client GitHubClient) DeleteRelease(ctx context.Context, releaseId int64) (Response, error) {
 	if client.client == nil {
 		return GitHubResponse{}, errors.New("GitHub client is nil")
 	}
 	gitHubResponse, err := client.client.Repositories.DeleteRelease(
-		client.ctx,
+		ctx,
 		client.owner,
 		client.repo,
 		releaseId)
 	return GitHubResponse{response: gitHubResponse}, err
 }
 
-func (client GitHubClient) DeleteTag(tagName string) (Response, error) {
+func (client GitHubClient) DeleteTag(ctx context.Context, tagName string) (Response, error) {
 	if client.client == nil {
 		return GitHubResponse{}, errors.New("GitHub client is nil")
 	}
@@ -179,70 +259,237 @@ func (client GitHubClient) DeleteTag(tagName string) (Response, error) {
 	}
 	// GitHub guys haven't really created any actual API for tag deletion
 	// so need to do it the hard way
-	deleteUrl := "https://api.github.com/repos/" + client.owner + "/" +
-		client.repo + "/git/refs/tags/" + tagName
-	request, err := http.NewRequest("DELETE", deleteUrl, nil)
+	deleteUrl := client.client.BaseURL.String() + "repos/" + client.owner +
+		"/" + client.repo + "/git/refs/tags/" + tagName
+	request, err := http.NewRequestWithContext(ctx, "DELETE", deleteUrl, nil)
 	if err != nil {
 		return GitHubResponse{}, err
 	}
 
 	gitHubResponse, err := client.httpClient.Do(request)
 	return GitHubResponse{
-			response: &github.Response{gitHubResponse, 0, 0, 0, 0, github.Rate{}}},
+			response: &github.Response{Response: gitHubResponse}},
 		err
 }
 
+func (client GitHubClient) UpdateTagRef(
+	ctx context.Context,
+	tagName string,
+	commit string) (Response, error) {
+
+	if client.client == nil {
+		return GitHubResponse{}, errors.New("GitHub client is nil")
+	}
+	ref := github.Reference{
+		Ref:    github.String("tags/" + tagName),
+		Object: &github.GitObject{SHA: github.String(commit)}}
+	_, gitHubResponse, err := client.client.Git.UpdateRef(
+		ctx,
+		client.owner,
+		client.repo,
+		&ref,
+		true)
+	return GitHubResponse{response: gitHubResponse}, err
+}
+
 func (client GitHubClient) ListReleaseAssets(
+	ctx context.Context,
 	releaseId int64) ([]ReleaseAsset, Response, error) {
 
 	if client.client == nil {
 		return nil, GitHubResponse{}, errors.New("GitHub client is nil")
 	}
-	gitHubReleaseAssets, gitHubResponse, err := client.client.Repositories.ListReleaseAssets(
-		client.ctx,
-		client.owner,
-		client.repo,
-		releaseId,
-		nil)
-	releaseAssets := make([]ReleaseAsset, 0, len(gitHubReleaseAssets))
-	for _, gitHubReleaseAsset := range gitHubReleaseAssets {
-		releaseAssets = append(releaseAssets, GitHubReleaseAsset{
-			asset: gitHubReleaseAsset})
+	var releaseAssets []ReleaseAsset
+	options := &github.ListOptions{PerPage: listPageSize}
+	for {
+		gitHubReleaseAssets, gitHubResponse, err := client.client.Repositories.ListReleaseAssets(
+			ctx,
+			client.owner,
+			client.repo,
+			releaseId,
+			options)
+		for _, gitHubReleaseAsset := range gitHubReleaseAssets {
+			releaseAssets = append(releaseAssets, GitHubReleaseAsset{
+				asset: gitHubReleaseAsset})
+		}
+		if err != nil || gitHubResponse.NextPage == 0 {
+			return releaseAssets, GitHubResponse{response: gitHubResponse}, err
+		}
+		options.Page = gitHubResponse.NextPage
 	}
-	return releaseAssets, GitHubResponse{response: gitHubResponse}, err
 }
 
-func (client GitHubClient) DeleteReleaseAsset(assetId int64) (Response, error) {
+func (client GitHubClient) DeleteReleaseAsset(ctx context.Context, assetId int64) (Response, error) {
 	if client.client == nil {
 		return GitHubResponse{}, errors.New("GitHub client is nil")
 	}
 	gitHubResponse, err := client.client.Repositories.DeleteReleaseAsset(
-		client.ctx,
+		ctx,
 		client.owner,
 		client.repo,
 		assetId)
 	return GitHubResponse{response: gitHubResponse}, err
 }
 
-func (client GitHubClient) UploadReleaseAsset(releaseId int64, assetName string,
-	assetFile *os.File) (ReleaseAsset, Response, error) {
+func (client GitHubClient) UploadReleaseAsset(
+	ctx context.Context,
+	releaseId int64,
+	upload AssetUpload) (ReleaseAsset, Response, error) {
+
+	if client.client == nil {
+		return GitHubReleaseAsset{}, GitHubResponse{},
+			errors.New("GitHub client is nil")
+	}
+
+	// The upload is done by hand since go-github can only upload files
+	uploadUrl := fmt.Sprintf("repos/%s/%s/releases/%d/assets?name=%s",
+		client.owner, client.repo, releaseId, url.QueryEscape(upload.Name))
+	if len(upload.Label) != 0 {
+		uploadUrl += "&label=" + url.QueryEscape(upload.Label)
+	}
+	request, err := client.client.NewUploadRequest(
+		uploadUrl,
+		upload.Content,
+		upload.Size,
+		assetContentType(upload))
+	if err != nil {
+		return GitHubReleaseAsset{}, GitHubResponse{}, err
+	}
+
+	gitHubReleaseAsset := new(github.ReleaseAsset)
+	gitHubResponse, err := client.client.Do(ctx, request, gitHubReleaseAsset)
+	return GitHubReleaseAsset{asset: gitHubReleaseAsset},
+		GitHubResponse{response: gitHubResponse}, err
+}
+
+func (client GitHubClient) UpdateReleaseAssetLabel(
+	ctx context.Context,
+	assetId int64,
+	label string) (ReleaseAsset, Response, error) {
+
+	if client.client == nil {
+		return GitHubReleaseAsset{}, GitHubResponse{},
+			errors.New("GitHub client is nil")
+	}
+
+	gitHubReleaseAsset, gitHubResponse, err := client.client.Repositories.EditReleaseAsset(
+		ctx,
+		client.owner,
+		client.repo,
+		assetId,
+		&github.ReleaseAsset{Label: github.String(label)})
+	return GitHubReleaseAsset{asset: gitHubReleaseAsset},
+		GitHubResponse{response: gitHubResponse}, err
+}
+
+func (client GitHubClient) RenameReleaseAsset(
+	ctx context.Context,
+	assetId int64,
+	name string) (ReleaseAsset, Response, error) {
+
 	if client.client == nil {
 		return GitHubReleaseAsset{}, GitHubResponse{},
 			errors.New("GitHub client is nil")
 	}
-	var options github.UploadOptions
-	options.Name = assetName
-	gitHubReleaseAsset, gitHubResponse, err := client.client.Repositories.UploadReleaseAsset(
-		client.ctx,
+
+	gitHubReleaseAsset, gitHubResponse, err := client.client.Repositories.EditReleaseAsset(
+		ctx,
 		client.owner,
 		client.repo,
-		releaseId,
-		&options,
-		assetFile)
+		assetId,
+		&github.ReleaseAsset{Name: github.String(name)})
 	return GitHubReleaseAsset{asset: gitHubReleaseAsset},
 		GitHubResponse{response: gitHubResponse}, err
 }
 
+func (client GitHubClient) DownloadReleaseAsset(
+	ctx context.Context,
+	assetId int64) (io.ReadCloser, Response, error) {
+
+	if client.client == nil {
+		return nil, GitHubResponse{}, errors.New("GitHub client is nil")
+	}
+	content, redirectUrl, err := client.client.Repositories.DownloadReleaseAsset(
+		ctx,
+		client.owner,
+		client.repo,
+		assetId)
+	if err != nil {
+		return nil, GitHubResponse{}, err
+	}
+
+	if content != nil {
+		return content, GitHubResponse{
+			response: &github.Response{Response: &http.Response{
+				StatusCode: http.StatusOK,
+				Status:     "200 OK",
+				Body:       content}}}, nil
+	}
+
+	// The asset is served from the storage GitHub redirects to; the redirect
+	// URL is pre-signed so it must not be requested with the GitHub token
+	request, err := http.NewRequestWithContext(ctx, "GET", redirectUrl, nil)
+	if err != nil {
+		return nil, GitHubResponse{}, err
+	}
+
+	httpResponse, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, GitHubResponse{}, err
+	}
+
+	return httpResponse.Body,
+		GitHubResponse{response: &github.Response{Response: httpResponse}}, nil
+}
+
+func (client GitHubClient) UpdateRepositoryFile(
+	ctx context.Context,
+	file RepositoryFile) (Response, error) {
+
+	if client.client == nil {
+		return GitHubResponse{}, errors.New("GitHub client is nil")
+	}
+
+	options := github.RepositoryContentFileOptions{
+		Message: github.String(file.Message),
+		Content: file.Content,
+	}
+	var getOptions *github.RepositoryContentGetOptions
+	if len(file.Branch) != 0 {
+		options.Branch = github.String(file.Branch)
+		getOptions = &github.RepositoryContentGetOptions{Ref: file.Branch}
+	}
+
+	// Updating the existing file requires the SHA of its blob
+	existing, _, gitHubResponse, err := client.client.Repositories.GetContents(
+		ctx,
+		file.Owner,
+		file.Repo,
+		file.Path,
+		getOptions)
+	if err != nil && (gitHubResponse == nil ||
+		gitHubResponse.StatusCode != http.StatusNotFound) {
+		return GitHubResponse{response: gitHubResponse}, err
+	}
+
+	if existing != nil {
+		// Committing the same content would only produce an empty commit
+		content, err := existing.GetContent()
+		if err == nil && content == string(file.Content) {
+			return GitHubResponse{response: gitHubResponse}, nil
+		}
+		options.SHA = existing.SHA
+	}
+
+	_, gitHubResponse, err = client.client.Repositories.UpdateFile(
+		ctx,
+		file.Owner,
+		file.Repo,
+		file.Path,
+		&options)
+	return GitHubResponse{response: gitHubResponse}, err
+}
+
 func (response GitHubResponse) Check() error {
 	if response.response == nil {
 		return errors.New("Response is nil")
@@ -327,6 +574,11 @@ func (release GitHubRelease) GetTagName() string {
 
 func (release GitHubRelease) GetTargetCommitish() string {
 	if release.repoTag == nil {
+		// Draft releases have no tag yet, their target is the commit
+		// the tag would be created at
+		if release.GetDraft() {
+			return release.release.GetTargetCommitish()
+		}
 		return ""
 	}
 	commit := release.repoTag.GetCommit()
@@ -336,6 +588,15 @@ func (release GitHubRelease) GetTargetCommitish() string {
 	return commit.GetSHA()
 }
 
+func (release GitHubRelease) SetTargetCommitish(commitish string) {
+	if release.release != nil {
+		release.release.TargetCommitish = &commitish
+	}
+	if release.repoTag != nil && release.repoTag.Commit != nil {
+		release.repoTag.Commit.SHA = &commitish
+	}
+}
+
 func (release GitHubRelease) GetDraft() bool {
 	if release.release == nil {
 		return false
@@ -343,6 +604,12 @@ func (release GitHubRelease) GetDraft() bool {
 	return release.release.GetDraft()
 }
 
+func (release GitHubRelease) SetDraft(draft bool) {
+	if release.release != nil {
+		release.release.Draft = &draft
+	}
+}
+
 func (release GitHubRelease) GetPrerelease() bool {
 	if release.release == nil {
 		return false
@@ -378,6 +645,27 @@ func (releaseAsset GitHubReleaseAsset) GetName() string {
 	return releaseAsset.asset.GetName()
 }
 
+func (releaseAsset GitHubReleaseAsset) GetSize() int64 {
+	if releaseAsset.asset == nil {
+		return 0
+	}
+	return int64(releaseAsset.asset.GetSize())
+}
+
+func (releaseAsset GitHubReleaseAsset) GetLabel() string {
+	if releaseAsset.asset == nil {
+		return ""
+	}
+	return releaseAsset.asset.GetLabel()
+}
+
+func (releaseAsset GitHubReleaseAsset) GetContentType() string {
+	if releaseAsset.asset == nil {
+		return ""
+	}
+	return releaseAsset.asset.GetContentType()
+}
+
 func (releaseAsset GitHubReleaseAsset) GetDescription() string {
 	if releaseAsset.asset == nil {
 		return ""
@@ -0,0 +1,297 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestGitHubClientListsAllPagesOfReleaseAssets(t *testing.T) {
+	var assets []interface{}
+	for i := 1; i <= 250; i++ {
+		assets = append(assets, map[string]interface{}{
+			"id":   i,
+			"name": "asset" + strconv.Itoa(i) + ".zip",
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases/1/assets",
+		func(w http.ResponseWriter, r *http.Request) {
+			writeTstPage(t, w, r, assets)
+		})
+
+	client, server := newTstGitHubClient(mux)
+	defer server.Close()
+
+	releaseAssets, response, err := client.ListReleaseAssets(
+		context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Failed to list release assets: %v", err)
+	}
+
+	err = response.Check()
+	if err != nil {
+		t.Fatalf("Bad response on attempt to list release assets: %v", err)
+	}
+
+	if len(releaseAssets) != len(assets) {
+		t.Fatalf("Detected wrong number of release assets: want %d, have %d",
+			len(assets), len(releaseAssets))
+	}
+
+	for i, asset := range releaseAssets {
+		if asset.GetID() != int64(i+1) {
+			t.Fatalf("Unexpected release asset at position %d: %s", i,
+				asset.GetDescription())
+		}
+	}
+}
+
+func TestGitHubClientFindsDraftReleaseBeyondFirstPage(t *testing.T) {
+	var releases []interface{}
+	for i := 1; i <= 150; i++ {
+		releases = append(releases, map[string]interface{}{
+			"id":       i,
+			"tag_name": "v0." + strconv.Itoa(i),
+		})
+	}
+	releases = append(releases, map[string]interface{}{
+		"id":               151,
+		"tag_name":         "continuous",
+		"target_commitish": "0123456789abcdef",
+		"draft":            true,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases/tags/continuous",
+		func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		})
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases",
+		func(w http.ResponseWriter, r *http.Request) {
+			writeTstPage(t, w, r, releases)
+		})
+
+	client, server := newTstGitHubClient(mux)
+	defer server.Close()
+
+	release, _, err := client.GetReleaseByTag(context.Background(), "continuous")
+	if err != nil {
+		t.Fatalf("Failed to find the draft release: %v", err)
+	}
+
+	if release.GetID() != 151 || !release.GetDraft() {
+		t.Fatalf("Found wrong release: id = %d, draft = %v", release.GetID(),
+			release.GetDraft())
+	}
+
+	if release.GetTargetCommitish() != "0123456789abcdef" {
+		t.Fatalf("Unexpected target commitish of the draft release: %s",
+			release.GetTargetCommitish())
+	}
+}
+
+func TestGitHubClientReportsMissingReleaseAsNotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases/tags/continuous",
+		func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		})
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases",
+		func(w http.ResponseWriter, r *http.Request) {
+			writeTstJson(t, w, []interface{}{})
+		})
+
+	client, server := newTstGitHubClient(mux)
+	defer server.Close()
+
+	_, response, err := client.GetReleaseByTag(context.Background(),
+		"continuous")
+	if err == nil {
+		t.Fatalf("The missing release was unexpectedly found")
+	}
+
+	if response.GetStatusCode() != http.StatusNotFound {
+		t.Fatalf("Unexpected status code of the missing release lookup: %d",
+			response.GetStatusCode())
+	}
+}
+
+func TestGitHubClientResolvesAnnotatedTagByRef(t *testing.T) {
+	commit := "0123456789abcdef"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases/tags/continuous",
+		func(w http.ResponseWriter, r *http.Request) {
+			writeTstJson(t, w, map[string]interface{}{
+				"id":       1,
+				"tag_name": "continuous",
+			})
+		})
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/git/refs/tags/continuous",
+		func(w http.ResponseWriter, r *http.Request) {
+			writeTstJson(t, w, map[string]interface{}{
+				"ref": "refs/tags/continuous",
+				"object": map[string]interface{}{
+					"type": "tag",
+					"sha":  "fedcba9876543210",
+				},
+			})
+		})
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/git/tags/fedcba9876543210",
+		func(w http.ResponseWriter, r *http.Request) {
+			writeTstJson(t, w, map[string]interface{}{
+				"sha": "fedcba9876543210",
+				"object": map[string]interface{}{
+					"type": "commit",
+					"sha":  commit,
+				},
+			})
+		})
+	// The tag has to be found without listing the tags
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/tags",
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("Unexpected listing of the tags")
+			http.NotFound(w, r)
+		})
+
+	client, server := newTstGitHubClient(mux)
+	defer server.Close()
+
+	release, _, err := client.GetReleaseByTag(context.Background(), "continuous")
+	if err != nil {
+		t.Fatalf("Failed to find the release: %v", err)
+	}
+
+	if release.GetTargetCommitish() != commit {
+		t.Fatalf("Unexpected target commitish of the release: %s",
+			release.GetTargetCommitish())
+	}
+}
+
+func TestGitHubClientDeletesTagOfMismatchingRelease(t *testing.T) {
+	releaseDeleted := false
+	tagDeleted := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases/1",
+		func(w http.ResponseWriter, r *http.Request) {
+			releaseDeleted = r.Method == "DELETE"
+			w.WriteHeader(http.StatusNoContent)
+		})
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases/tags/continuous",
+		func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		})
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/releases",
+		func(w http.ResponseWriter, r *http.Request) {
+			writeTstJson(t, w, []interface{}{})
+		})
+	mux.HandleFunc("/repos/d1vanov/ciuploadtool/git/refs/tags/continuous",
+		func(w http.ResponseWriter, r *http.Request) {
+			tagDeleted = r.Method == "DELETE"
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+	client, server := newTstGitHubClient(mux)
+	defer server.Close()
+
+	uploader := &Uploader{
+		client: client,
+		logger: &tstLogger{},
+		info: &BuildInfo{
+			Tag:          "continuous",
+			Commit:       "0123456789abcdef",
+			IsPrerelease: true,
+		},
+	}
+
+	release := GitHubRelease{
+		release: &github.RepositoryRelease{
+			ID:         github.Int64(1),
+			TagName:    github.String("continuous"),
+			Prerelease: github.Bool(true),
+		},
+		repoTag: &github.RepositoryTag{
+			Name:   github.String("continuous"),
+			Commit: &github.Commit{SHA: github.String("fedcba9876543210")},
+		},
+	}
+
+	concurrentRelease, err := uploader.deleteMismatchingRelease(
+		context.Background(), release)
+	if err != nil {
+		t.Fatalf("Failed to delete the mismatching release: %v", err)
+	}
+
+	if concurrentRelease != nil {
+		t.Fatalf("Unexpected release recreated by another build job")
+	}
+
+	if !releaseDeleted || !tagDeleted {
+		t.Fatalf("The release and its tag were not deleted: release %v, "+
+			"tag %v", releaseDeleted, tagDeleted)
+	}
+}
+
+func newTstGitHubClient(handler http.Handler) (GitHubClient, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	client := github.NewClient(server.Client())
+	baseUrl, _ := url.Parse(server.URL + "/")
+	client.BaseURL = baseUrl
+	client.UploadURL = baseUrl
+	return GitHubClient{
+		client:     client,
+		httpClient: server.Client(),
+		owner:      "d1vanov",
+		repo:       "ciuploadtool"}, server
+}
+
+// Writes the requested page of items along with the Link header pointing to
+// the next page the way GitHub does
+func writeTstPage(
+	t *testing.T,
+	w http.ResponseWriter,
+	r *http.Request,
+	items []interface{}) {
+
+	query := r.URL.Query()
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(query.Get("per_page"))
+	if err != nil {
+		perPage = 30
+	}
+
+	begin := (page - 1) * perPage
+	if begin > len(items) {
+		begin = len(items)
+	}
+	end := begin + perPage
+	if end >= len(items) {
+		end = len(items)
+	} else {
+		w.Header().Set("Link", fmt.Sprintf(
+			"<http://%s%s?page=%d&per_page=%d>; rel=\"next\"",
+			r.Host, r.URL.Path, page+1, perPage))
+	}
+
+	writeTstJson(t, w, items[begin:end])
+}
+
+func writeTstJson(t *testing.T, w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		t.Errorf("Failed to write the response: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// globFilter selects the files found within the uploaded dirs by their
+// slash separated paths relative to the dir
+type globFilter struct {
+	include []string
+	exclude []string
+}
+
+func newGlobFilter(include []string, exclude []string) (*globFilter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		err := validateGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &globFilter{include: include, exclude: exclude}, nil
+}
+
+func (filter *globFilter) matches(relPath string) bool {
+	if len(filter.include) != 0 && !matchAnyGlob(filter.include, relPath) {
+		return false
+	}
+	return !matchAnyGlob(filter.exclude, relPath)
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateGlob(pattern string) error {
+	if len(pattern) == 0 {
+		return errors.New("Empty glob pattern")
+	}
+
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "**" {
+			continue
+		}
+
+		_, err := path.Match(segment, "")
+		if err != nil {
+			return fmt.Errorf("Invalid glob pattern %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches the slash separated name against the pattern in which "**" stands
+// for any number of path components. Patterns without slashes are matched
+// against the last component of the name only, so "*.zip" matches zip files
+// at any depth.
+func matchGlob(pattern string, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	return matchGlobSegments(
+		strings.Split(pattern, "/"),
+		strings.Split(name, "/"))
+}
+
+func matchGlobSegments(patternSegments []string, nameSegments []string) bool {
+	for len(patternSegments) != 0 {
+		segment := patternSegments[0]
+		if segment == "**" {
+			for i := 0; i <= len(nameSegments); i++ {
+				if matchGlobSegments(patternSegments[1:], nameSegments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(nameSegments) == 0 {
+			return false
+		}
+
+		matched, err := path.Match(segment, nameSegments[0])
+		if err != nil || !matched {
+			return false
+		}
+
+		patternSegments = patternSegments[1:]
+		nameSegments = nameSegments[1:]
+	}
+
+	return len(nameSegments) == 0
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[{")
+}
+
+// Matches the name against the pattern which may contain brace alternatives
+func matchBracedGlob(pattern string, name string) bool {
+	return matchAnyGlob(expandBraces(pattern), name)
+}
+
+func validateBracedGlob(pattern string) error {
+	for _, alternative := range expandBraces(pattern) {
+		err := validateGlob(alternative)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expands the brace alternatives, i.e. "app.{zip,tar.gz}" into "app.zip" and
+// "app.tar.gz"; braces may be nested
+func expandBraces(pattern string) []string {
+	begin := strings.Index(pattern, "{")
+	if begin < 0 {
+		return []string{pattern}
+	}
+
+	depth := 0
+	alternativeBegin := begin + 1
+	var alternatives []string
+	for i := begin; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, pattern[alternativeBegin:i])
+				alternativeBegin = i + 1
+			}
+		case '}':
+			depth--
+			if depth != 0 {
+				continue
+			}
+
+			// Braces without alternatives are taken literally
+			if len(alternatives) == 0 {
+				var expanded []string
+				for _, rest := range expandBraces(pattern[i+1:]) {
+					expanded = append(expanded, pattern[:i+1]+rest)
+				}
+				return expanded
+			}
+
+			alternatives = append(alternatives, pattern[alternativeBegin:i])
+			var expanded []string
+			for _, alternative := range alternatives {
+				expanded = append(expanded, expandBraces(
+					pattern[:begin]+alternative+pattern[i+1:])...)
+			}
+			return expanded
+		}
+	}
+
+	// Unbalanced braces are taken literally
+	return []string{pattern}
+}
+
+// Finds the files matching the slash separated pattern. Patterns without
+// "**" match dirs as well as files the way filepath.Glob does, while "**"
+// patterns match files only. Dirs which can't be read are skipped.
+func globFiles(pattern string, logger Logger) ([]string, error) {
+	segments := strings.Split(pattern, "/")
+	metaIndex := 0
+	for metaIndex < len(segments) && !hasGlobMeta(segments[metaIndex]) {
+		metaIndex++
+	}
+
+	if metaIndex == len(segments) {
+		_, err := os.Stat(filepath.FromSlash(pattern))
+		if err != nil {
+			return nil, nil
+		}
+		return []string{filepath.FromSlash(pattern)}, nil
+	}
+
+	root := strings.Join(segments[:metaIndex], "/")
+	if len(root) == 0 {
+		if metaIndex == 0 {
+			root = "."
+		} else {
+			root = "/"
+		}
+	} else if strings.HasSuffix(root, ":") {
+		// Windows drive
+		root += "/"
+	}
+
+	_, err := os.Stat(filepath.FromSlash(root))
+	if err != nil {
+		return nil, nil
+	}
+
+	patternSegments := segments[metaIndex:]
+	matchDirs := true
+	for _, segment := range patternSegments {
+		if segment == "**" {
+			matchDirs = false
+		}
+	}
+
+	var matches []string
+	err = filepath.Walk(filepath.FromSlash(root), func(
+		filename string,
+		info os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			if filename == filepath.FromSlash(root) {
+				return err
+			}
+
+			logger.Printf("Skipping %s which can't be read: %v\n",
+				filename, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relPath, err := filepath.Rel(filepath.FromSlash(root), filename)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		relSegments := strings.Split(filepath.ToSlash(relPath), "/")
+		if info.IsDir() {
+			if matchDirs && matchGlobSegments(patternSegments, relSegments) {
+				matches = append(matches, filename)
+			}
+			if !matchGlobPrefix(patternSegments, relSegments) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if matchGlobSegments(patternSegments, relSegments) {
+			matches = append(matches, filename)
+		}
+		return nil
+	})
+
+	return matches, err
+}
+
+// Checks whether the paths within the dir might match the pattern, which
+// allows not to walk the dirs which can't contain any matches
+func matchGlobPrefix(patternSegments []string, dirSegments []string) bool {
+	for _, dirSegment := range dirSegments {
+		if len(patternSegments) == 0 {
+			return false
+		}
+
+		segment := patternSegments[0]
+		if segment == "**" {
+			return true
+		}
+
+		matched, err := path.Match(segment, dirSegment)
+		if err != nil || !matched {
+			return false
+		}
+		patternSegments = patternSegments[1:]
+	}
+	return len(patternSegments) != 0
+}
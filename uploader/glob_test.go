@@ -0,0 +1,174 @@
+package uploader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGlobMatching(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"*.zip", "app.zip", true},
+		{"*.zip", "linux/x64/app.zip", true},
+		{"*.zip", "app.tar.gz", false},
+		{"linux/*.zip", "linux/app.zip", true},
+		{"linux/*.zip", "linux/x64/app.zip", false},
+		{"linux/**/*.zip", "linux/app.zip", true},
+		{"linux/**/*.zip", "linux/x64/debug/app.zip", true},
+		{"linux/**/*.zip", "windows/x64/app.zip", false},
+		{"**/debug/**", "linux/x64/debug/app.zip", true},
+		{"**/debug/**", "linux/x64/release/app.zip", false},
+		{"**", "linux/x64/app.zip", true},
+		{"?pp.[a-z]*", "app.zip", true},
+	}
+
+	for _, testCase := range testCases {
+		matches := matchGlob(testCase.pattern, testCase.name)
+		if matches != testCase.matches {
+			t.Fatalf("Unexpected result of matching %s against pattern %s: "+
+				"want %v, have %v", testCase.name, testCase.pattern,
+				testCase.matches, matches)
+		}
+	}
+
+	err := validateGlob("linux/[x64")
+	if err == nil {
+		t.Fatalf("Invalid glob pattern was unexpectedly accepted")
+	}
+}
+
+func TestBraceExpansion(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		expanded []string
+	}{
+		{"app.zip", []string{"app.zip"}},
+		{"app.{zip,tar.gz}", []string{"app.zip", "app.tar.gz"}},
+		{"{linux,win{32,64}}/*", []string{"linux/*", "win32/*", "win64/*"}},
+		{"app{}.zip", []string{"app{}.zip"}},
+		{"app{.zip", []string{"app{.zip"}},
+	}
+
+	for _, testCase := range testCases {
+		expanded := expandBraces(testCase.pattern)
+		if !reflect.DeepEqual(expanded, testCase.expanded) {
+			t.Fatalf("Unexpected expansion of %s: want %v, have %v",
+				testCase.pattern, testCase.expanded, expanded)
+		}
+	}
+}
+
+func TestCommandLineFilesExpansion(t *testing.T) {
+	dir, err := setupSampleAssetDir(map[string]string{
+		"app.AppImage":            "Linux binary",
+		"app.zip":                 "Windows binary",
+		"app.dmg":                 "macOS binary",
+		"debug/app.debug.zip":     "Debug symbols",
+		"debug/nested/extra.zip":  "Extra symbols",
+		"debug/nested/readme.txt": "Readme",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the sample dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.ToSlash(dir)
+
+	testCases := []struct {
+		patterns []string
+		files    []string
+	}{
+		{[]string{root + "/*.{AppImage,dmg}"},
+			[]string{"app.AppImage", "app.dmg"}},
+		{[]string{root + "/**/*.zip"},
+			[]string{"app.zip", "debug/app.debug.zip", "debug/nested/extra.zip"}},
+		{[]string{root + "/**/*.zip", "!" + root + "/debug/**"},
+			[]string{"app.zip"}},
+		{[]string{root + "/app.zip", root + "/*.zip"},
+			[]string{"app.zip"}},
+		{[]string{root + "/debug/*"},
+			[]string{"debug/app.debug.zip", "debug/nested"}},
+	}
+
+	for _, testCase := range testCases {
+		files, err := commandLineFiles(testCase.patterns, false,
+			stdoutLogger{})
+		if err != nil {
+			t.Fatalf("Failed to expand %v: %v", testCase.patterns, err)
+		}
+
+		var relFiles []string
+		for _, file := range files {
+			relFile, err := filepath.Rel(dir, file)
+			if err != nil {
+				t.Fatalf("Unexpected file %s: %v", file, err)
+			}
+			relFiles = append(relFiles, filepath.ToSlash(relFile))
+		}
+		sort.Strings(relFiles)
+
+		if !reflect.DeepEqual(relFiles, testCase.files) {
+			t.Fatalf("Unexpected expansion of %v: want %v, have %v",
+				testCase.patterns, testCase.files, relFiles)
+		}
+	}
+
+	_, err = commandLineFiles([]string{root + "/*.exe"}, false,
+		stdoutLogger{})
+	if err == nil {
+		t.Fatalf("Pattern matching nothing was unexpectedly accepted")
+	}
+
+	files, err := commandLineFiles([]string{root + "/*.exe"}, true,
+		stdoutLogger{})
+	if err != nil || len(files) != 0 {
+		t.Fatalf("Unexpected expansion of pattern matching nothing with "+
+			"allowed empty matches: %v, %v", files, err)
+	}
+}
+
+func TestUnreadableDirIsSkippedByExpansion(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("Permissions of dirs don't restrict reading them")
+	}
+
+	dir, err := setupSampleAssetDir(map[string]string{
+		"linux/app.zip":   "Linux binary",
+		"private/app.zip": "Private binary",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the sample dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "private")
+	err = os.Chmod(private, 0)
+	if err != nil {
+		t.Fatalf("Failed to make the dir unreadable: %v", err)
+	}
+	defer os.Chmod(private, 0755)
+
+	logger := &tstLogger{}
+	files, err := commandLineFiles(
+		[]string{filepath.ToSlash(dir) + "/**/*.zip"}, false, logger)
+	if err != nil {
+		t.Fatalf("Failed to expand the pattern: %v", err)
+	}
+
+	if len(files) != 1 || files[0] != filepath.Join(dir, "linux", "app.zip") {
+		t.Fatalf("Unexpected expansion: %v", files)
+	}
+
+	if len(logger.messages) != 1 ||
+		!strings.Contains(logger.messages[0], private) {
+		t.Fatalf("The unreadable dir was not reported: %v", logger.messages)
+	}
+}
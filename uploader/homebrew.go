@@ -0,0 +1,130 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+func validateHomebrewConfig(config *HomebrewConfig) error {
+	if strings.ContainsAny(config.Name, "/\\") {
+		return fmt.Errorf("Invalid Homebrew formula name %s", config.Name)
+	}
+
+	err := validateBracedGlob(config.Pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid pattern of Homebrew formula: %v", err)
+	}
+
+	if config.Cask && len(config.App) == 0 {
+		return errors.New("The app installed by Homebrew cask must be set")
+	}
+
+	if !config.Cask && len(config.Bin) == 0 {
+		return errors.New(
+			"The binaries installed by Homebrew formula must be set")
+	}
+
+	return validateRepositoryFileConfig(config.Tap)
+}
+
+// Converts the formula name into the name of its class the way Homebrew
+// does, i.e. "my-app" into "MyApp" and "app@2" into "AppAT2"
+func homebrewClassName(name string) string {
+	var className strings.Builder
+	upper := true
+	for _, char := range strings.Replace(name, "@", "AT", -1) {
+		if char == '-' || char == '_' || char == '.' {
+			upper = true
+			continue
+		}
+
+		if upper {
+			char = unicode.ToUpper(char)
+			upper = false
+		}
+		className.WriteRune(char)
+	}
+	return className.String()
+}
+
+func homebrewFormula(
+	config *HomebrewConfig,
+	name string,
+	version string,
+	asset packageAsset) []byte {
+
+	var formula bytes.Buffer
+	fmt.Fprintf(&formula, "class %s < Formula\n", homebrewClassName(name))
+	if len(config.Description) != 0 {
+		fmt.Fprintf(&formula, "  desc %s\n", rubyString(config.Description))
+	}
+	if len(config.Homepage) != 0 {
+		fmt.Fprintf(&formula, "  homepage %s\n", rubyString(config.Homepage))
+	}
+	fmt.Fprintf(&formula, "  url %s\n", rubyString(asset.url))
+	fmt.Fprintf(&formula, "  version %s\n", rubyString(version))
+	fmt.Fprintf(&formula, "  sha256 %s\n", rubyString(asset.sha256))
+	if len(config.License) != 0 {
+		fmt.Fprintf(&formula, "  license %s\n", rubyString(config.License))
+	}
+
+	formula.WriteString("\n  def install\n")
+	for _, bin := range config.Bin {
+		fmt.Fprintf(&formula, "    bin.install %s\n", rubyString(bin))
+	}
+	formula.WriteString("  end\nend\n")
+	return formula.Bytes()
+}
+
+func homebrewCask(
+	config *HomebrewConfig,
+	name string,
+	version string,
+	asset packageAsset) []byte {
+
+	var cask bytes.Buffer
+	fmt.Fprintf(&cask, "cask %s do\n", rubyString(name))
+	fmt.Fprintf(&cask, "  version %s\n", rubyString(version))
+	fmt.Fprintf(&cask, "  sha256 %s\n\n", rubyString(asset.sha256))
+	fmt.Fprintf(&cask, "  url %s\n", rubyString(asset.url))
+	fmt.Fprintf(&cask, "  name %s\n", rubyString(
+		strings.TrimSuffix(config.App, ".app")))
+	if len(config.Description) != 0 {
+		fmt.Fprintf(&cask, "  desc %s\n", rubyString(config.Description))
+	}
+	if len(config.Homepage) != 0 {
+		fmt.Fprintf(&cask, "  homepage %s\n", rubyString(config.Homepage))
+	}
+	fmt.Fprintf(&cask, "\n  app %s\nend\n", rubyString(config.App))
+	return cask.Bytes()
+}
+
+func (uploader *Uploader) updateHomebrewManifest(ctx context.Context) error {
+	config := uploader.options.Homebrew
+	name := config.Name
+	if len(name) == 0 {
+		name = uploader.client.GetRepo()
+	}
+	manifestName := name + ".rb"
+
+	assets, err := uploader.packageAssets(
+		ctx, manifestName, map[string]string{"": config.Pattern})
+	if err != nil || assets == nil {
+		return err
+	}
+
+	version := strings.TrimPrefix(uploader.info.Tag, "v")
+	content := homebrewFormula(config, name, version, assets[""])
+	path := "Formula/" + manifestName
+	if config.Cask {
+		content = homebrewCask(config, name, version, assets[""])
+		path = "Casks/" + manifestName
+	}
+
+	return uploader.publishPackageManifest(ctx, manifestName,
+		"text/x-ruby", content, config.Tap, path)
+}
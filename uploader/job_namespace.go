@@ -0,0 +1,193 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Placements of the job key within the names of the uploaded files
+const (
+	JobNamespacePrefix = "prefix"
+	JobNamespaceSuffix = "suffix"
+)
+
+// Joins the job key with the names of the uploaded files. It doesn't depend
+// on any option since changing it would rename the assets of every build job.
+const jobKeySeparator = "_"
+
+// Name of the release body section listing the release assets uploaded by
+// each build job
+const jobAssetsSection = "job-assets"
+
+func isValidJobNamespace(namespace string) bool {
+	return namespace == JobNamespacePrefix || namespace == JobNamespaceSuffix
+}
+
+// Joins the non-empty parts describing the build job, i.e. its OS,
+// architecture and configuration, into the key usable within asset names
+func jobKey(parts ...string) string {
+	var words []string
+	for _, part := range parts {
+		word := strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' ||
+				r == '_' {
+				return r
+			}
+			if r >= 'A' && r <= 'Z' {
+				return r - 'A' + 'a'
+			}
+			return ' '
+		}, part)
+		words = append(words, strings.Fields(word)...)
+	}
+	return strings.Join(words, "-")
+}
+
+// Returns the name template given explicitly or the one placing the job key
+// into the names of the uploaded files
+func (uploader *Uploader) nameTemplate() string {
+	switch uploader.options.JobNamespace {
+	case JobNamespacePrefix:
+		return "{{.JobKey}}" + jobKeySeparator + "{{.Name}}"
+	case JobNamespaceSuffix:
+		return "{{.Stem}}" + jobKeySeparator + "{{.JobKey}}{{.Ext}}"
+	}
+	return uploader.options.NameTemplate
+}
+
+// Returns the keys of the build jobs by the names of the release assets
+// they have uploaded
+func jobAssetsSectionOwners(body string) map[string]string {
+	owners := make(map[string]string)
+	content, ok := releaseBodySection(body, jobAssetsSection)
+	if !ok {
+		return owners
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(line, "* `") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(line, "* `"), "`: ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		for _, name := range strings.Split(parts[1], ", ") {
+			owners[strings.Trim(name, "`")] = parts[0]
+		}
+	}
+	return owners
+}
+
+func jobAssetsSectionContent(owners map[string]string) string {
+	namesByJob := make(map[string][]string)
+	for name, key := range owners {
+		namesByJob[key] = append(namesByJob[key], name)
+	}
+
+	keys := make([]string, 0, len(namesByJob))
+	for key := range namesByJob {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	content := "Binaries uploaded by build jobs:\n"
+	for _, key := range keys {
+		names := namesByJob[key]
+		sort.Strings(names)
+		content += "\n* `" + key + "`: `" + strings.Join(names, "`, `") + "`"
+	}
+	return content
+}
+
+// Fails if any of the sources would replace the release asset uploaded by
+// another build job
+func (uploader *Uploader) checkJobAssetOwners(sources []*assetSource) error {
+	owners := jobAssetsSectionOwners(uploader.release.GetBody())
+	for _, asset := range uploader.existingReleaseAssets {
+		owner, ok := owners[asset.GetName()]
+		if !ok || owner == uploader.info.JobKey {
+			continue
+		}
+
+		for _, source := range sources {
+			if source.replaces(asset.GetName()) {
+				return fmt.Errorf("Release asset %s uploaded from %s would "+
+					"replace release asset %s uploaded by build job %s",
+					source.name, source.filename, asset.GetName(), owner)
+			}
+		}
+	}
+	return nil
+}
+
+// Deletes the release assets the previous build of the current job has
+// uploaded but the current one hasn't and lists the uploaded ones as owned
+// by the job within the release body. The entries of the assets no longer
+// present within the release are dropped from the list.
+func (uploader *Uploader) recordJobAssets(
+	ctx context.Context,
+	sources []*assetSource) error {
+
+	logger := uploader.logger
+	key := uploader.info.JobKey
+
+	names := make(map[string]bool)
+	for _, source := range sources {
+		names[source.name] = true
+	}
+
+	owners := jobAssetsSectionOwners(uploader.release.GetBody())
+	for _, asset := range uploader.existingReleaseAssets {
+		if asset.GetID() == 0 || owners[asset.GetName()] != key ||
+			names[asset.GetName()] {
+			continue
+		}
+
+		logger.Printf("Deleting release asset %s uploaded by the previous "+
+			"build of job %s\n", asset.GetName(), key)
+		err := uploader.deleteReleaseAsset(ctx, asset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return uploader.updateRelease(ctx, "list the binaries of job "+key+
+		" within the release body", func(release Release) error {
+		assets, response, err := uploader.client.ListReleaseAssets(
+			ctx, release.GetID())
+		response.CloseBody()
+		if err == nil {
+			err = response.Check()
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to list release assets: %v", err)
+		}
+
+		existingNames := make(map[string]bool)
+		for _, asset := range assets {
+			existingNames[asset.GetName()] = true
+		}
+
+		// The assets deleted since they were listed are forgotten
+		owners := jobAssetsSectionOwners(release.GetBody())
+		for name, owner := range owners {
+			if owner == key || !existingNames[name] {
+				delete(owners, name)
+			}
+		}
+		for name := range names {
+			owners[name] = key
+		}
+
+		release.SetBody(setReleaseBodySection(
+			release.GetBody(),
+			jobAssetsSection,
+			jobAssetsSectionContent(owners)))
+		return nil
+	})
+}
@@ -0,0 +1,176 @@
+package uploader
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestJobKey(t *testing.T) {
+	for expected, parts := range map[string][]string{
+		"linux-amd64":                    {"linux", "amd64", ""},
+		"visual-studio-2019-x64-release": {"Visual Studio 2019", "x64", "Release"},
+		"osx-gcc_9.2":                    {"osx", "", "(gcc_9.2)"},
+	} {
+		key := jobKey(parts...)
+		if key != expected {
+			t.Fatalf("Unexpected job key of %v: %s, expected %s", parts, key,
+				expected)
+		}
+	}
+}
+
+func TestJobNamespace(t *testing.T) {
+	dir := t.TempDir()
+	defer os.Unsetenv("TRAVIS_OS_NAME")
+	defer os.Unsetenv("TRAVIS_CPU_ARCH")
+
+	factory := newSharedTstClientFactory()
+
+	commit := generateRandomString(16)
+	uploadWithSeparator := func(
+		osName string,
+		namespace string,
+		separator string,
+		files map[string]string) {
+
+		setupTravisCiEnvVars(commit, "master", "v1.2.0",
+			"d1vanov/ciuploadtool", false)
+		os.Setenv("TRAVIS_OS_NAME", osName)
+		os.Setenv("TRAVIS_CPU_ARCH", "amd64")
+
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, files),
+			Options{JobNamespace: namespace, AssetNameSeparator: separator})
+		if err != nil {
+			t.Fatalf("Failed to upload the files of job %s: %v", osName, err)
+		}
+	}
+	upload := func(osName string, namespace string, files map[string]string) {
+		uploadWithSeparator(osName, namespace, "", files)
+	}
+
+	checkAssets := func(expectedContents map[string]string) {
+		contents := make(map[string]string)
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			contents[asset.GetName()] = asset.(TstReleaseAsset).content
+		}
+
+		if len(contents) != len(expectedContents) {
+			t.Fatalf("Unexpected release assets: %v, expected %v", contents,
+				expectedContents)
+		}
+		for name, content := range expectedContents {
+			if contents[name] != content {
+				t.Fatalf("Unexpected content of release asset %s: %q, "+
+					"expected %q", name, contents[name], content)
+			}
+		}
+	}
+
+	upload("linux", JobNamespacePrefix, map[string]string{
+		"app.zip":   "linux app",
+		"notes.txt": "linux notes",
+	})
+	upload("osx", JobNamespacePrefix, map[string]string{"app.zip": "osx app"})
+	checkAssets(map[string]string{
+		"linux-amd64_app.zip":   "linux app",
+		"linux-amd64_notes.txt": "linux notes",
+		"osx-amd64_app.zip":     "osx app",
+	})
+
+	// The rebuild of the Linux job no longer producing the notes replaces
+	// only the files of the job
+	upload("linux", JobNamespacePrefix, map[string]string{
+		"app.zip": "rebuilt linux app",
+	})
+	checkAssets(map[string]string{
+		"linux-amd64_app.zip": "rebuilt linux app",
+		"osx-amd64_app.zip":   "osx app",
+	})
+
+	owners := jobAssetsSectionOwners(factory.client.releases[0].GetBody())
+	var ownerList []string
+	for name, key := range owners {
+		ownerList = append(ownerList, name+":"+key)
+	}
+	sort.Strings(ownerList)
+	if strings.Join(ownerList, ",") !=
+		"linux-amd64_app.zip:linux-amd64,osx-amd64_app.zip:osx-amd64" {
+		t.Fatalf("Unexpected owners of release assets: %v", ownerList)
+	}
+
+	upload("windows", JobNamespaceSuffix, map[string]string{
+		"app.tar.gz": "windows app",
+	})
+	checkAssets(map[string]string{
+		"linux-amd64_app.zip":      "rebuilt linux app",
+		"osx-amd64_app.zip":        "osx app",
+		"app_windows-amd64.tar.gz": "windows app",
+	})
+
+	// The separator of the names of files within directories doesn't change
+	// the names of the assets uploaded earlier
+	uploadWithSeparator("osx", JobNamespacePrefix, "-", map[string]string{
+		"app.zip": "rebuilt osx app",
+	})
+	checkAssets(map[string]string{
+		"linux-amd64_app.zip":      "rebuilt linux app",
+		"osx-amd64_app.zip":        "rebuilt osx app",
+		"app_windows-amd64.tar.gz": "windows app",
+	})
+}
+
+func TestJobNamespaceConflicts(t *testing.T) {
+	dir := t.TempDir()
+
+	commit := generateRandomString(16)
+	setupTravisCiEnvVars(commit, "master", "v1.2.0", "d1vanov/ciuploadtool",
+		false)
+
+	factory := newSharedTstClientFactory()
+	factory.setup = func(tstClient *TstClient) {
+		tstClient.releases = append(tstClient.releases, TstRelease{
+			id:              lastFreeReleaseId,
+			name:            "Release v1.2.0",
+			tagName:         "v1.2.0",
+			targetCommitish: commit,
+			body: setReleaseBodySection("", jobAssetsSection,
+				jobAssetsSectionContent(map[string]string{
+					"linux_app.zip": "osx",
+				})),
+			assets: []TstReleaseAsset{
+				{id: lastFreeReleaseAssetId, name: "linux_app.zip"},
+			},
+		})
+		lastFreeReleaseId++
+		lastFreeReleaseAssetId++
+	}
+
+	files := writeSampleFiles(t, dir, map[string]string{"app.zip": "app"})
+	_, err := uploadImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		ReleaseFactory(newTstRelease),
+		files,
+		Options{JobNamespace: JobNamespacePrefix, JobKey: "linux"})
+	if err == nil {
+		t.Fatalf("The release asset of another build job was replaced")
+	}
+
+	for _, options := range []Options{
+		{JobNamespace: "infix"},
+		{JobNamespace: JobNamespacePrefix, NameTemplate: "{{.Name}}"},
+		{JobNamespace: JobNamespacePrefix, JobKey: "linux/amd64"},
+	} {
+		_, err = New(options)
+		if err == nil {
+			t.Fatalf("Invalid options were accepted: %+v", options)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+)
+
+// Data of label templates: the build info along with the name of the asset
+type assetLabelData struct {
+	*BuildInfo
+	Name string
+}
+
+func validateAssetConfigs(configs []AssetConfig) error {
+	for _, config := range configs {
+		if len(config.Pattern) == 0 {
+			return errors.New("The pattern of release assets to set " +
+				"the label or the content type for is empty")
+		}
+
+		err := validateBracedGlob(config.Pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid pattern of release assets %s: %v",
+				config.Pattern, err)
+		}
+
+		_, err = parseTemplate(config.Label)
+		if err != nil {
+			return fmt.Errorf("Invalid label template of release assets "+
+				"%s: %v", config.Pattern, err)
+		}
+
+		if len(config.ContentType) != 0 {
+			_, _, err = mime.ParseMediaType(config.ContentType)
+			if err != nil {
+				return fmt.Errorf("Invalid content type of release assets "+
+					"%s: %v", config.Pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the label and the content type set for the release asset by
+// the first matching configs setting them, empty if none sets them
+func assetLabelAndContentType(
+	configs []AssetConfig,
+	info *BuildInfo,
+	name string) (string, string, error) {
+
+	var label string
+	var contentType string
+	for _, config := range configs {
+		if !matchBracedGlob(config.Pattern, name) {
+			continue
+		}
+
+		if len(label) == 0 && len(config.Label) != 0 {
+			var err error
+			label, err = executeTemplate(config.Label,
+				assetLabelData{BuildInfo: info, Name: name})
+			if err != nil {
+				return "", "", fmt.Errorf("Failed to execute label template "+
+					"for release asset %s: %v", name, err)
+			}
+		}
+
+		if len(contentType) == 0 {
+			contentType = config.ContentType
+		}
+	}
+
+	return label, contentType, nil
+}
+
+// UpdateLabels sets the labels of the existing assets of the release with
+// the given tag according to the configs without re-uploading the assets.
+// Content types can't be changed this way as GitHub only sets them on upload.
+func UpdateLabels(
+	ctx context.Context,
+	tag string,
+	configs []AssetConfig,
+	repoSlug string,
+	verbose bool) error {
+
+	_, err := updateLabelsImpl(
+		ctx,
+		clientFactoryFunc(newGitHubClient),
+		tag,
+		configs,
+		repoSlug,
+		stdoutLogger{},
+		verbose)
+	return err
+}
+
+func updateLabelsImpl(
+	ctx context.Context,
+	clientFactory clientFactoryFunc,
+	tag string,
+	configs []AssetConfig,
+	repoSlug string,
+	logger Logger,
+	verbose bool) (Client, error) {
+
+	if len(tag) == 0 {
+		return nil, errors.New("The tag of the release to label assets of " +
+			"is required")
+	}
+
+	err := validateAssetConfigs(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := collectRepoInfo(repoSlug, logger, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	client := clientFactory(info.Token, info.Owner, info.Repo)
+	labelUpdater, ok := client.(ReleaseAssetLabelUpdater)
+	if !ok {
+		return client, errors.New(
+			"The client can't update labels of release assets")
+	}
+
+	release, response, err := client.GetReleaseByTag(ctx, tag)
+	response.CloseBody()
+	if err != nil {
+		return client, fmt.Errorf("Failed to find release %s: %v", tag, err)
+	}
+
+	err = response.Check()
+	if err != nil {
+		return client, fmt.Errorf(
+			"Bad response on attempt to find the release: %v", err)
+	}
+
+	// Labels are executed with the build info of the release
+	info.Tag = tag
+	info.Commit = release.GetTargetCommitish()
+
+	assets, response, err := client.ListReleaseAssets(ctx, release.GetID())
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		return client, fmt.Errorf("Failed to list release assets: %v", err)
+	}
+
+	for _, asset := range assets {
+		label, _, err := assetLabelAndContentType(
+			configs, info, asset.GetName())
+		if err != nil {
+			return client, err
+		}
+
+		if len(label) == 0 || label == asset.GetLabel() {
+			if verbose {
+				logger.Printf("Leaving the label of release asset %s as is\n",
+					asset.GetName())
+			}
+			continue
+		}
+
+		logger.Printf("Setting the label of release asset %s to %s\n",
+			asset.GetName(), label)
+		_, response, err := labelUpdater.UpdateReleaseAssetLabel(
+			ctx, asset.GetID(), label)
+		response.CloseBody()
+		if err == nil {
+			err = response.Check()
+		}
+		if err != nil {
+			return client, fmt.Errorf("Failed to set the label of release "+
+				"asset %s: %v", asset.GetName(), err)
+		}
+	}
+
+	return client, nil
+}
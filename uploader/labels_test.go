@@ -0,0 +1,98 @@
+package uploader
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAssetLabelsAndContentTypes(t *testing.T) {
+	dir := t.TempDir()
+
+	factory := newSharedTstClientFactory()
+
+	commit := generateRandomString(16)
+	setupTravisCiEnvVars(commit, "master", "v1.2.0", "d1vanov/ciuploadtool",
+		false)
+
+	configs := []AssetConfig{
+		{
+			Pattern:     "*.{exe,msi}",
+			Label:       "Windows x64 installer ({{.ShortCommit}})",
+			ContentType: "application/vnd.microsoft.portable-executable",
+		},
+		{Pattern: "*", Label: "{{.Name}} of {{.Tag}}"},
+	}
+
+	_, err := uploadImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		ReleaseFactory(newTstRelease),
+		writeSampleFiles(t, dir, map[string]string{
+			"setup.exe":  "installer",
+			"app.tar.gz": "archive",
+		}),
+		Options{Assets: configs})
+	if err != nil {
+		t.Fatalf("Failed to upload the files: %v", err)
+	}
+
+	assets := make(map[string]TstReleaseAsset)
+	for _, asset := range factory.client.releases[0].GetAssets() {
+		assets[asset.GetName()] = asset.(TstReleaseAsset)
+	}
+
+	installer := assets["setup.exe"]
+	if installer.GetLabel() != "Windows x64 installer ("+commit[:7]+")" ||
+		installer.GetContentType() != configs[0].ContentType {
+		t.Fatalf("Unexpected label or content type of the installer: %s, %s",
+			installer.GetLabel(), installer.GetContentType())
+	}
+
+	archive := assets["app.tar.gz"]
+	if archive.GetLabel() != "app.tar.gz of v1.2.0" ||
+		archive.GetContentType() == configs[0].ContentType {
+		t.Fatalf("Unexpected label or content type of the archive: %s, %s",
+			archive.GetLabel(), archive.GetContentType())
+	}
+
+	// Labels are updated without re-uploading the assets
+	configs[0].Label = "Windows installer"
+	_, err = updateLabelsImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		"v1.2.0",
+		configs,
+		"",
+		stdoutLogger{},
+		false)
+	if err != nil {
+		t.Fatalf("Failed to update labels: %v", err)
+	}
+
+	for _, asset := range factory.client.releases[0].GetAssets() {
+		if asset.GetName() == "setup.exe" &&
+			(asset.GetLabel() != "Windows installer" ||
+				asset.GetID() != installer.GetID()) {
+			t.Fatalf("The label of the installer wasn't updated: %s",
+				asset.GetDescription())
+		}
+		if asset.GetName() == "app.tar.gz" &&
+			asset.GetLabel() != archive.GetLabel() {
+			t.Fatalf("Unexpected label of the archive: %s", asset.GetLabel())
+		}
+	}
+}
+
+func TestInvalidAssetConfigs(t *testing.T) {
+	for _, config := range []AssetConfig{
+		{Pattern: "", Label: "label"},
+		{Pattern: "[", Label: "label"},
+		{Pattern: "*", Label: "{{.ShortCommit"},
+		{Pattern: "*", ContentType: "application/"},
+	} {
+		_, err := New(Options{Assets: []AssetConfig{config}})
+		if err == nil {
+			t.Fatalf("Invalid asset config was accepted: %+v", config)
+		}
+	}
+}
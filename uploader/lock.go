@@ -0,0 +1,242 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Build jobs of the matrix change the shared parts of the release, i.e.
+// the merged manifests and the release body, holding the locks of these
+// parts. The lock is the release asset named after the locked part: GitHub
+// refuses to upload the asset with the name taken by another asset, so only
+// one build job at a time manages to upload it.
+
+// Suffix of the lock release assets
+const lockAssetSuffix = ".ciuploadtool-lock"
+
+// Suffix of the replacement of the shared release asset during its upload
+const replacementAssetSuffix = ".ciuploadtool-new"
+
+// Number of attempts to make the change which other build jobs make
+// concurrently and the delay between the attempts
+const maxConcurrentUpdateAttempts = 60
+
+var concurrentUpdateRetryDelay = 2 * time.Second
+
+// The lock held for longer than the timeout is considered left behind by
+// the build job which was killed, it is deleted by the job waiting for it
+var lockTimeout = time.Minute
+
+// Time given to the release of the lock after the change was cancelled
+var unlockTimeout = 30 * time.Second
+
+// Tells whether the release asset is the lock or the replacement being
+// uploaded rather than the asset published by the build
+func isTransientAsset(name string) bool {
+	return strings.HasSuffix(name, lockAssetSuffix) ||
+		strings.HasSuffix(name, replacementAssetSuffix)
+}
+
+// Calls the update until it reports it's done, waiting between the attempts.
+// Fails if the update fails or isn't done after maxConcurrentUpdateAttempts.
+func retryConcurrentUpdate(
+	ctx context.Context,
+	what string,
+	update func(attempt int) (bool, error)) error {
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(concurrentUpdateRetryDelay):
+			}
+		}
+
+		done, err := update(attempt)
+		if err != nil || done {
+			return err
+		}
+
+		if attempt >= maxConcurrentUpdateAttempts {
+			return fmt.Errorf("Failed to %s after %d attempts", what, attempt)
+		}
+	}
+}
+
+// Makes the change of the named part of the release holding its lock
+func (uploader *Uploader) withLock(
+	ctx context.Context,
+	name string,
+	change func() error) error {
+
+	lock, err := uploader.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	err = change()
+	unlockErr := uploader.unlock(name, lock)
+	if err != nil {
+		return err
+	}
+	return unlockErr
+}
+
+func (uploader *Uploader) lock(
+	ctx context.Context,
+	name string) (ReleaseAsset, error) {
+
+	client := uploader.client
+	logger := uploader.logger
+	info := uploader.info
+	releaseId := uploader.release.GetID()
+	lockName := name + lockAssetSuffix
+	content := []byte(fmt.Sprintf("Locked by build %s of commit %s\n",
+		info.BuildId, info.Commit))
+
+	var lock ReleaseAsset
+	var heldLockId int64
+	var heldSince time.Time
+	err := retryConcurrentUpdate(ctx, "lock "+name, func(int) (bool, error) {
+		asset, response, err := client.UploadReleaseAsset(ctx, releaseId,
+			AssetUpload{
+				Name:        lockName,
+				ContentType: "text/plain",
+				Size:        int64(len(content)),
+				Content:     bytes.NewReader(content),
+			})
+		response.CloseBody()
+		if err == nil {
+			err = response.Check()
+		}
+		if err == nil {
+			lock = asset
+			return true, nil
+		}
+
+		if response.GetStatusCode() != http.StatusUnprocessableEntity {
+			return false, fmt.Errorf("Failed to lock %s: %v", name, err)
+		}
+
+		// Another build job holds the lock
+		heldLock, err := uploader.findReleaseAsset(ctx, lockName)
+		if err != nil || heldLock == nil {
+			return false, err
+		}
+
+		if heldLock.GetID() != heldLockId {
+			logger.Printf("Waiting for another build job to unlock %s\n", name)
+			heldLockId = heldLock.GetID()
+			heldSince = time.Now()
+			return false, nil
+		}
+
+		if time.Since(heldSince) < lockTimeout {
+			return false, nil
+		}
+
+		logger.Printf("Deleting the lock of %s held for more than %v\n",
+			name, lockTimeout)
+		response, err = client.DeleteReleaseAsset(ctx, heldLock.GetID())
+		response.CloseBody()
+		if err != nil && response.GetStatusCode() != http.StatusNotFound {
+			return false, fmt.Errorf("Failed to delete the lock of %s: %v",
+				name, err)
+		}
+		return false, nil
+	})
+	return lock, err
+}
+
+// Deletes the lock even if the change was cancelled, otherwise other build
+// jobs would wait for the lock to time out
+func (uploader *Uploader) unlock(name string, lock ReleaseAsset) error {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+
+	err := uploader.deleteReleaseAsset(ctx, lock)
+	if err != nil {
+		return fmt.Errorf("Failed to unlock %s: %v", name, err)
+	}
+	return nil
+}
+
+// Returns nil if the release has no asset with the name
+func (uploader *Uploader) findReleaseAsset(
+	ctx context.Context,
+	name string) (ReleaseAsset, error) {
+
+	assets, response, err := uploader.client.ListReleaseAssets(
+		ctx, uploader.release.GetID())
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list release assets to find %s: %v",
+			name, err)
+	}
+
+	for _, asset := range assets {
+		if asset.GetID() != 0 && asset.GetName() == name {
+			return asset, nil
+		}
+	}
+	return nil, nil
+}
+
+// Name of the lock of the release body and the other release properties
+const releaseBodyLockName = "release-body"
+
+// Applies the change to the up to date release holding the lock of
+// the release body, so the changes other build jobs make to the body in
+// the meantime are kept
+func (uploader *Uploader) updateRelease(
+	ctx context.Context,
+	what string,
+	change func(release Release) error) error {
+
+	return uploader.withLock(ctx, releaseBodyLockName, func() error {
+		release, err := uploader.currentRelease(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = change(release)
+		if err != nil {
+			return err
+		}
+
+		release, response, err := uploader.client.UpdateRelease(ctx, release)
+		response.CloseBody()
+		if err == nil {
+			err = response.Check()
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to %s: %v", what, err)
+		}
+
+		uploader.release = release
+		uploader.result.Release = release
+		return nil
+	})
+}
+
+// Returns the release with the body possibly updated by other build jobs
+func (uploader *Uploader) currentRelease(ctx context.Context) (Release, error) {
+	release, response, err := uploader.client.GetReleaseByTag(
+		ctx, uploader.info.Tag)
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the release: %v", err)
+	}
+	return release, nil
+}
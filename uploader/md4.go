@@ -0,0 +1,65 @@
+package uploader
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// MD4 as described in RFC 1320, zsync uses it for the checksums of blocks.
+// It is long broken as cryptographic hash but zsync only relies on it to
+// detect the blocks which are already present locally.
+func md4Sum(data []byte) [16]byte {
+	message := make([]byte, len(data), len(data)+72)
+	copy(message, data)
+	message = append(message, 0x80)
+	for len(message)%64 != 56 {
+		message = append(message, 0)
+	}
+	message = binary.LittleEndian.AppendUint64(message, uint64(len(data))*8)
+
+	state := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	var x [16]uint32
+	for offset := 0; offset < len(message); offset += 64 {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(message[offset+4*i:])
+		}
+
+		// After each step the variables are rotated so that a always holds
+		// the one updated next
+		a, b, c, d := state[0], state[1], state[2], state[3]
+		for i := 0; i < 16; i++ {
+			a = bits.RotateLeft32(a+(b&c|^b&d)+x[i], md4Shifts[0][i%4])
+			a, b, c, d = d, a, b, c
+		}
+		for i := 0; i < 16; i++ {
+			a = bits.RotateLeft32(a+(b&c|b&d|c&d)+x[md4Order[1][i]]+0x5a827999,
+				md4Shifts[1][i%4])
+			a, b, c, d = d, a, b, c
+		}
+		for i := 0; i < 16; i++ {
+			a = bits.RotateLeft32(a+(b^c^d)+x[md4Order[2][i]]+0x6ed9eba1,
+				md4Shifts[2][i%4])
+			a, b, c, d = d, a, b, c
+		}
+
+		state[0] += a
+		state[1] += b
+		state[2] += c
+		state[3] += d
+	}
+
+	var sum [16]byte
+	for i, value := range state {
+		binary.LittleEndian.PutUint32(sum[4*i:], value)
+	}
+	return sum
+}
+
+var md4Shifts = [3][4]int{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+
+// Order of message words within the second and the third rounds
+var md4Order = [3][16]int{
+	{},
+	{0, 4, 8, 12, 1, 5, 9, 13, 2, 6, 10, 14, 3, 7, 11, 15},
+	{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15},
+}
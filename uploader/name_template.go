@@ -0,0 +1,151 @@
+package uploader
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Extensions kept whole when the name is split into the stem and
+// the extension
+var compoundExtensions = []string{".tar.gz", ".tar.xz", ".tar.bz2", ".tar.zst"}
+
+// Placeholders of the build metadata differing between the builds, they are
+// replaced with the patterns matching any value when the names of assets
+// uploaded by the previous builds are matched
+var volatileNamePlaceholders = map[string]string{
+	"\x00commit\x00":   "[0-9a-fA-F]*",
+	"\x00build-id\x00": "[0-9A-Za-z_]*",
+}
+
+// Data of name templates: the build info along with the parts of the name
+// the file would be uploaded as without the template
+type assetNameData struct {
+	*BuildInfo
+	Name        string
+	Stem        string
+	Ext         string
+	ShortCommit string
+	Platform    string
+}
+
+func splitAssetName(name string) (string, string) {
+	lowerName := strings.ToLower(name)
+	for _, ext := range compoundExtensions {
+		if strings.HasSuffix(lowerName, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)], name[len(name)-len(ext):]
+		}
+	}
+
+	ext := filepath.Ext(name)
+	return name[:len(name)-len(ext)], ext
+}
+
+func newAssetNameData(info *BuildInfo, name string) assetNameData {
+	stem, ext := splitAssetName(name)
+	return assetNameData{
+		BuildInfo:   info,
+		Name:        name,
+		Stem:        stem,
+		Ext:         ext,
+		ShortCommit: info.ShortCommit(),
+		Platform:    runtime.GOOS + "-" + runtime.GOARCH,
+	}
+}
+
+// Returns the pattern matching the names the template produces for the file
+// with any commit and build id, so that the assets uploaded by the previous
+// builds are replaced rather than piled up
+func assetNamePattern(
+	nameTemplate string,
+	info *BuildInfo,
+	name string) (*regexp.Regexp, error) {
+
+	placeholderInfo := *info
+	placeholderInfo.Commit = "\x00commit\x00"
+	placeholderInfo.BuildId = "\x00build-id\x00"
+	data := newAssetNameData(&placeholderInfo, name)
+	data.ShortCommit = placeholderInfo.Commit
+
+	text, err := executeTemplate(nameTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+
+	expression := regexp.QuoteMeta(text)
+	for placeholder, pattern := range volatileNamePlaceholders {
+		expression = strings.Replace(expression, placeholder, pattern, -1)
+	}
+	return regexp.Compile("^" + expression + "$")
+}
+
+// Renames the sources according to the name template
+func (uploader *Uploader) renameAssetSources(sources []*assetSource) error {
+	nameTemplate := uploader.nameTemplate()
+	info := uploader.info
+	for _, source := range sources {
+		name, err := executeTemplate(nameTemplate,
+			newAssetNameData(info, source.name))
+		if err != nil {
+			return fmt.Errorf("Failed to execute name template for %s: %v",
+				source.filename, err)
+		}
+
+		if len(name) == 0 || strings.ContainsAny(name, "/\\") {
+			return fmt.Errorf("Invalid release asset name %q produced by "+
+				"name template for %s", name, source.filename)
+		}
+
+		pattern, err := assetNamePattern(nameTemplate, info, source.name)
+		if err != nil {
+			return err
+		}
+
+		// Templates transforming the build metadata can't be matched
+		// against the names of the previous builds
+		if pattern.MatchString(name) {
+			source.namePattern = pattern
+		}
+		source.name = name
+	}
+
+	for _, source := range sources {
+		if source.namePattern == nil {
+			continue
+		}
+
+		for _, other := range sources {
+			if other != source && source.namePattern.MatchString(other.name) {
+				return fmt.Errorf("Release asset %s uploaded from %s would "+
+					"replace release asset %s uploaded from %s", source.name,
+					source.filename, other.name, other.filename)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Strips the suffixes of the release assets generated from the asset, i.e.
+// of its zsync file, delta patches or parts
+func generatedAssetOriginalName(assetName string) string {
+	name := strings.TrimSuffix(assetName, zsyncSuffix)
+	name = strings.TrimSuffix(name, splitManifestSuffix)
+
+	if strings.HasSuffix(name, deltaPatchSuffix) {
+		index := strings.LastIndex(
+			strings.TrimSuffix(name, deltaPatchSuffix), ".")
+		if index > 0 && isDeltaPatchOf(name, name[:index]) {
+			return name[:index]
+		}
+	}
+
+	index := strings.LastIndex(name, ".part")
+	if index > 0 && isSplitArtifactOf(name, name[:index]) {
+		return name[:index]
+	}
+
+	return name
+}
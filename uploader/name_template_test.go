@@ -0,0 +1,107 @@
+package uploader
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSplitAssetName(t *testing.T) {
+	for name, expected := range map[string][2]string{
+		"app.exe":        {"app", ".exe"},
+		"app-1.2.tar.gz": {"app-1.2", ".tar.gz"},
+		"app.TAR.XZ":     {"app", ".TAR.XZ"},
+		"app":            {"app", ""},
+		".tar.gz":        {".tar", ".gz"},
+	} {
+		stem, ext := splitAssetName(name)
+		if stem != expected[0] || ext != expected[1] {
+			t.Fatalf("Unexpected stem and extension of %s: %s, %s", name,
+				stem, ext)
+		}
+	}
+}
+
+func TestNameTemplateReplacesPreviousBuilds(t *testing.T) {
+	dir := t.TempDir()
+
+	factory := newSharedTstClientFactory()
+
+	nameTemplate := "{{.Stem}}-{{.ShortCommit}}-{{.BuildId}}-" +
+		"{{.Platform}}{{.Ext}}"
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+
+	// The second build rebuilds the same commit, the release is reused for
+	// the third one
+	for _, commit := range []string{
+		"0123456789abcdef", "0123456789abcdef", "fedcba9876543210"} {
+
+		setupTravisCiEnvVars(commit, "master", "continuous-master",
+			"d1vanov/ciuploadtool", false)
+
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, map[string]string{
+				"app.tar.gz":     "app " + commit,
+				"app-cli.tar.gz": "cli " + commit,
+			}),
+			Options{
+				ReleaseSuffix: "master",
+				UpdateTag:     true,
+				NameTemplate:  nameTemplate,
+			})
+		if err != nil {
+			t.Fatalf("Failed to upload the files: %v", err)
+		}
+
+		buildId := os.Getenv("TRAVIS_BUILD_ID")
+		expectedNames := []string{
+			"app-cli-" + commit[:7] + "-" + buildId + "-" + platform + ".tar.gz",
+			"app-" + commit[:7] + "-" + buildId + "-" + platform + ".tar.gz",
+		}
+		sort.Strings(expectedNames)
+
+		var names []string
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			names = append(names, asset.GetName())
+		}
+		sort.Strings(names)
+
+		if strings.Join(names, ",") != strings.Join(expectedNames, ",") {
+			t.Fatalf("Unexpected release assets: %v, expected %v", names,
+				expectedNames)
+		}
+	}
+}
+
+func TestNameTemplateConflicts(t *testing.T) {
+	dir := t.TempDir()
+
+	setupTravisCiEnvVars("0123456789abcdef", "master", "v1.2.0",
+		"d1vanov/ciuploadtool", false)
+
+	for _, nameTemplate := range []string{
+		"{{.ShortCommit}}{{.Ext}}",
+		"app/{{.Name}}",
+		"{{.Unknown}}",
+	} {
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(newTstClient),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, map[string]string{
+				"a.bin": "a",
+				"b.bin": "b",
+			}),
+			Options{NameTemplate: nameTemplate})
+		if err == nil {
+			t.Fatalf("Name template %s was unexpectedly accepted",
+				nameTemplate)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package uploader
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options configure the Uploader
+type Options struct {
+	// Client used to access GitHub API. If nil, GitHub client is created
+	// using the token from the build info.
+	Client Client
+
+	// ReleaseFactory creates releases of the type the Client works with.
+	// Must be set if a custom Client is used.
+	ReleaseFactory ReleaseFactory
+
+	// BuildInfo describes the build event. If nil, the info is collected
+	// from Travis CI or AppVeyor CI environment variables.
+	BuildInfo *BuildInfo
+
+	// Suffix for the tag of continuous releases, used only when the build
+	// info is collected from the environment
+	ReleaseSuffix string
+
+	// Body of created releases
+	ReleaseBody string
+
+	// Optional text/template templates for the title and the body of created
+	// releases, executed with BuildInfo as data
+	ReleaseTitleTemplate string
+	ReleaseBodyTemplate  string
+
+	// Create releases as drafts and publish them once all ExpectedAssets
+	// are uploaded
+	Draft          bool
+	ExpectedAssets []string
+
+	// List the release assets uploaded by the current build within
+	// the release body and delete the ones no job of the build has uploaded
+	// once all ExpectedAssets are uploaded or on Finalize
+	Prune bool
+
+	// Move the tag of continuous release to the current commit instead of
+	// recreating the release
+	UpdateTag bool
+
+	// Verify uploaded assets by downloading them back
+	Verify bool
+
+	// Don't fail if a glob pattern among the uploaded files matches nothing
+	AllowEmpty bool
+
+	// Pack each of the uploaded dirs into the archive of the given format
+	// named after the dir: ArchiveZip, ArchiveTarGz or ArchiveTarXz
+	Archive string
+
+	// Upload the files within the dirs given among the uploaded files,
+	// optionally filtered by globs matched against the slash separated paths
+	// relative to the dir; "**" matches any number of path components
+	Recursive bool
+	Include   []string
+	Exclude   []string
+
+	// Separator joining the path components of files found within the dirs
+	// into release asset names, if empty the base names of files are used
+	AssetNameSeparator string
+
+	// Optional text/template template of release asset names executed with
+	// BuildInfo, the Name the file would be uploaded as, its Stem and Ext,
+	// ShortCommit and Platform as data. The assets uploaded by the previous
+	// builds under the names with other commit or build id are replaced.
+	NameTemplate string
+
+	// Place the key of the build job, i.e. its OS, architecture and
+	// configuration, before or after the names of the uploaded files and
+	// list the release assets uploaded by each job within the release body,
+	// so that a rebuild of the job only replaces the files of the job.
+	// JobKey overrides the key collected from CI env vars.
+	JobNamespace string
+	JobKey       string
+
+	// Files larger than the split size are uploaded as parts named
+	// <name>.part001, <name>.part002 and so on along with the manifest of
+	// their checksums, zero means no splitting
+	SplitSize int64
+
+	// Upload the patches from the binaries of the previous continuous build
+	// replaced by the uploaded ones, named <name>.<old commit>-<new commit>.patch
+	DeltaPatches bool
+
+	// Upload zsync files for delta updates of AppImages next to them
+	Zsync bool
+
+	// Upload SPDX SBOM describing the uploaded binaries, merged with
+	// the binaries uploaded to the same release by other build jobs
+	SBOM bool
+
+	// Upload in-toto provenance of the uploaded binaries as <tag>.intoto.jsonl
+	// signed with ed25519 private key from the environment variable,
+	// by default PROVENANCE_PRIVATE_KEY
+	Provenance       bool
+	ProvenanceKeyEnv string
+
+	// Name of the release asset uploaded from stdin, required if "-" is
+	// among the uploaded files
+	StdinName string
+
+	// Upload the update feed manifest for in-app auto-updaters listing
+	// the uploaded binaries by platforms, nil means no feed
+	UpdateFeed *UpdateFeedConfig
+
+	// Upload the Sparkle appcast pointing to the uploaded macOS binary,
+	// nil means no appcast
+	Appcast *AppcastConfig
+
+	// Upload Scoop manifest and Homebrew formula or cask pointing to
+	// the binaries of tagged releases, nil means no manifest
+	Scoop    *ScoopConfig
+	Homebrew *HomebrewConfig
+
+	// Labels and content types of release assets by glob patterns of their
+	// names; for each of the two the first entry setting it is used
+	Assets []AssetConfig
+
+	// Maximum duration of the upload of a single file, zero means no limit
+	UploadTimeout time.Duration
+
+	Hooks Hooks
+
+	// Logger receives all the output, by default it is printed to stdout
+	Logger Logger
+
+	Verbose bool
+}
+
+// Hooks are optional callbacks invoked by the Uploader, returning an error
+// from any of them aborts the upload
+type Hooks struct {
+	// Called once the release binaries are uploaded to is found or created
+	ReleasePrepared func(release Release) error
+
+	// Called before the upload of each file
+	BeforeUpload func(filename string) error
+
+	// Called after the successful upload of each file
+	AfterUpload func(filename string, asset ReleaseAsset) error
+}
+
+// Logger receives the progress messages of the Uploader, the messages are
+// formatted like with fmt.Printf and end with the newline
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// Result describes what the Uploader has done
+type Result struct {
+	// Skipped is true if the build event doesn't require any uploading,
+	// i.e. for pull request builds
+	Skipped bool
+
+	// Release the binaries were uploaded to
+	Release Release
+
+	// Created is true if the release was created rather than reused
+	Created bool
+
+	// Assets uploaded to the release
+	Assets []ReleaseAsset
+}
+
+type stdoutLogger struct{}
+
+func (logger stdoutLogger) Printf(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
+}
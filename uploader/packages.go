@@ -0,0 +1,219 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Release asset the package manifest points to
+type packageAsset struct {
+	name   string
+	url    string
+	sha256 string
+}
+
+func validateRepositoryFileConfig(config *RepositoryFileConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	parts := strings.Split(config.Repo, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return fmt.Errorf("Invalid repository %s, expected owner/repo",
+			config.Repo)
+	}
+	return nil
+}
+
+// Package manifests are only generated for tagged releases, the binaries of
+// continuous releases are replaced too often for package managers to follow
+func (uploader *Uploader) updatePackageManifests(ctx context.Context) error {
+	options := uploader.options
+	if options.Scoop == nil && options.Homebrew == nil {
+		return nil
+	}
+
+	if uploader.info.isContinuous() {
+		uploader.logger.Printf("Not generating package manifests for " +
+			"continuous release\n")
+		return nil
+	}
+
+	if options.Scoop != nil {
+		err := uploader.updateScoopManifest(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Homebrew != nil {
+		err := uploader.updateHomebrewManifest(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Finds the release assets matching the patterns of the package manifest.
+// The binaries might be uploaded by several build jobs so the manifest is
+// generated by the job which uploads the last of them, or by the one which
+// publishes the draft release; nil is returned if the manifest should be
+// left as is.
+func (uploader *Uploader) packageAssets(
+	ctx context.Context,
+	manifest string,
+	patterns map[string]string) (map[string]packageAsset, error) {
+
+	client := uploader.client
+	assets, response, err := client.ListReleaseAssets(
+		ctx, uploader.release.GetID())
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list release assets for %s: %v",
+			manifest, err)
+	}
+
+	matchedAssets := make(map[string]ReleaseAsset)
+	uploadedByJob := false
+	for key, pattern := range patterns {
+		for _, asset := range assets {
+			if asset.GetID() == 0 || !matchBracedGlob(pattern, asset.GetName()) {
+				continue
+			}
+
+			matchedAsset, ok := matchedAssets[key]
+			if ok {
+				return nil, fmt.Errorf("Both %s and %s match the pattern %s "+
+					"of %s", matchedAsset.GetName(), asset.GetName(), pattern,
+					manifest)
+			}
+			matchedAssets[key] = asset
+
+			_, ok = uploader.assetDigests[asset.GetName()]
+			uploadedByJob = uploadedByJob || ok
+		}
+
+		_, ok := matchedAssets[key]
+		if !ok {
+			uploader.logger.Printf("No release assets match the pattern %s "+
+				"of %s yet, leaving it as is\n", pattern, manifest)
+			return nil, nil
+		}
+	}
+
+	if !uploadedByJob && !uploader.published {
+		return nil, nil
+	}
+
+	packageAssets := make(map[string]packageAsset)
+	for key, asset := range matchedAssets {
+		digest, ok := uploader.assetDigests[asset.GetName()]
+		if !ok {
+			digest, err = uploader.releaseAssetSha256(ctx, asset)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		packageAssets[key] = packageAsset{
+			name: asset.GetName(),
+			url: releaseAssetDownloadURL(client.GetOwner(), client.GetRepo(),
+				uploader.info.Tag, asset.GetName()),
+			sha256: digest,
+		}
+	}
+
+	return packageAssets, nil
+}
+
+// Computes the digest of the release asset uploaded by another build job
+func (uploader *Uploader) releaseAssetSha256(
+	ctx context.Context,
+	asset ReleaseAsset) (string, error) {
+
+	content, response, err := uploader.client.DownloadReleaseAsset(
+		ctx, asset.GetID())
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return "", fmt.Errorf("Bad response on attempt to download %s: %v",
+			asset.GetName(), err)
+	}
+
+	return readerSha256(content)
+}
+
+// Uploads the package manifest as the release asset and commits it into
+// the repository if it is configured. Several build jobs or the finalizing
+// one might upload the manifest at once, so it's replaced holding its lock.
+func (uploader *Uploader) publishPackageManifest(
+	ctx context.Context,
+	name string,
+	contentType string,
+	content []byte,
+	repositoryFile *RepositoryFileConfig,
+	defaultPath string) error {
+
+	err := uploader.withLock(ctx, name, func() error {
+		uploader.logger.Printf("Uploading package manifest %s\n", name)
+		source := memoryAssetSource(name, name, content)
+		source.contentType = contentType
+		_, err := uploader.replaceReleaseAsset(ctx, source)
+		return err
+	})
+	if err != nil || repositoryFile == nil {
+		return err
+	}
+
+	updater, ok := uploader.client.(RepositoryFileUpdater)
+	if !ok {
+		return errors.New("The client can't commit files into repositories")
+	}
+
+	path := repositoryFile.Path
+	if len(path) == 0 {
+		path = defaultPath
+	}
+
+	uploader.logger.Printf("Committing %s into %s\n", path,
+		repositoryFile.Repo)
+	parts := strings.Split(repositoryFile.Repo, "/")
+	response, err := updater.UpdateRepositoryFile(ctx, RepositoryFile{
+		Owner:   parts[0],
+		Repo:    parts[1],
+		Branch:  repositoryFile.Branch,
+		Path:    path,
+		Content: content,
+		Message: fmt.Sprintf("Update %s to %s", name, uploader.info.Tag),
+	})
+	response.CloseBody()
+	if err != nil {
+		return err
+	}
+
+	err = response.Check()
+	if err != nil {
+		return fmt.Errorf("Bad response on attempt to commit %s into %s: %v",
+			path, repositoryFile.Repo, err)
+	}
+
+	return nil
+}
+
+// Quotes the string for Ruby, "#" is escaped to prevent interpolation
+func rubyString(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "#", `\#`,
+		"\n", `\n`)
+	return `"` + replacer.Replace(text) + `"`
+}
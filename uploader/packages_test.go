@@ -0,0 +1,291 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackageManifestsOfTaggedRelease(t *testing.T) {
+	dir := t.TempDir()
+
+	// Both build jobs upload to the same release
+	factory := newSharedTstClientFactory()
+
+	options := Options{
+		Scoop: &ScoopConfig{
+			Architectures: map[string]string{
+				"64bit": "*-win64.zip",
+				"arm64": "*-arm64.zip",
+			},
+			Bin: []string{"app.exe"},
+		},
+		Homebrew: &HomebrewConfig{
+			Name:        "my-app",
+			Pattern:     "*-macos.tar.gz",
+			Description: "App with \"quotes\" and #{interpolation}",
+			Bin:         []string{"app"},
+			Tap:         &RepositoryFileConfig{Repo: "d1vanov/homebrew-tap"},
+		},
+	}
+
+	commit := generateRandomString(16)
+	jobs := []struct {
+		files     map[string]string
+		manifests []string
+	}{
+		{map[string]string{"app-win64.zip": "win64",
+			"app-macos.tar.gz": "macos"}, []string{"my-app.rb"}},
+		{map[string]string{"app-arm64.zip": "arm64"},
+			[]string{"my-app.rb", "ciuploadtool.json"}},
+	}
+
+	manifests := make(map[string]string)
+	for _, job := range jobs {
+		setupTravisCiEnvVars(commit, "master", "v1.2.0",
+			"d1vanov/ciuploadtool", false)
+
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, job.files),
+			options)
+		if err != nil {
+			t.Fatalf("Failed to upload the files: %v", err)
+		}
+
+		manifests = make(map[string]string)
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			name := asset.GetName()
+			if strings.HasSuffix(name, ".rb") ||
+				strings.HasSuffix(name, ".json") {
+				manifests[name] = asset.(TstReleaseAsset).GetContent()
+			}
+		}
+
+		if len(manifests) != len(job.manifests) {
+			t.Fatalf("Unexpected package manifests: %v", manifests)
+		}
+		for _, name := range job.manifests {
+			_, ok := manifests[name]
+			if !ok {
+				t.Fatalf("Package manifest %s is missing", name)
+			}
+		}
+	}
+
+	formula := manifests["my-app.rb"]
+	for _, line := range []string{
+		"class MyApp < Formula\n",
+		"  desc \"App with \\\"quotes\\\" and \\#{interpolation}\"\n",
+		"  url \"https://github.com/d1vanov/ciuploadtool/releases/download/" +
+			"v1.2.0/app-macos.tar.gz\"\n",
+		"  version \"1.2.0\"\n",
+		"  sha256 \"" + tstSha256("macos") + "\"\n",
+		"    bin.install \"app\"\n",
+	} {
+		if !strings.Contains(formula, line) {
+			t.Fatalf("Line %q is missing within the formula: %s", line,
+				formula)
+		}
+	}
+
+	committedFormula := factory.client.repositoryFiles["d1vanov/homebrew-tap//Formula/my-app.rb"]
+	if committedFormula != formula {
+		t.Fatalf("Unexpected formula committed into the tap: %s",
+			committedFormula)
+	}
+
+	// The digest of the binary uploaded by the first job is computed from
+	// the release asset
+	var manifest scoopManifest
+	err := json.Unmarshal([]byte(manifests["ciuploadtool.json"]), &manifest)
+	if err != nil {
+		t.Fatalf("Failed to parse the Scoop manifest: %v", err)
+	}
+
+	if manifest.Version != "1.2.0" ||
+		manifest.Architecture["64bit"].Hash != tstSha256("win64") ||
+		manifest.Architecture["arm64"].Hash != tstSha256("arm64") ||
+		!strings.HasSuffix(manifest.Architecture["arm64"].URL,
+			"/v1.2.0/app-arm64.zip") {
+		t.Fatalf("Unexpected Scoop manifest: %s",
+			manifests["ciuploadtool.json"])
+	}
+
+	// No package manifests for continuous releases
+	setupTravisCiEnvVars(commit, "master", "", "d1vanov/ciuploadtool", false)
+	factory = newSharedTstClientFactory()
+	_, err = uploadImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		ReleaseFactory(newTstRelease),
+		writeSampleFiles(t, dir, map[string]string{"app-macos.tar.gz": "macos"}),
+		options)
+	if err != nil {
+		t.Fatalf("Failed to upload the files: %v", err)
+	}
+
+	assets := factory.client.releases[0].GetAssets()
+	if len(assets) != 1 || len(factory.client.repositoryFiles) != 0 {
+		t.Fatalf("Unexpected release assets of continuous release: %v", assets)
+	}
+}
+
+func TestPackageManifestsOfDraftReleaseAreDeferred(t *testing.T) {
+	dir := t.TempDir()
+	factory := newSharedTstClientFactory()
+
+	options := Options{
+		Draft: true,
+		Homebrew: &HomebrewConfig{
+			Name:    "my-app",
+			Pattern: "*-macos.tar.gz",
+			Bin:     []string{"app"},
+			Tap:     &RepositoryFileConfig{Repo: "d1vanov/homebrew-tap"},
+		},
+	}
+
+	commit := generateRandomString(16)
+	setupTravisCiEnvVars(commit, "master", "v1.2.0", "d1vanov/ciuploadtool",
+		false)
+	_, err := uploadImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		ReleaseFactory(newTstRelease),
+		writeSampleFiles(t, dir, map[string]string{"app-macos.tar.gz": "macos"}),
+		options)
+	if err != nil {
+		t.Fatalf("Failed to upload the files: %v", err)
+	}
+
+	assets := factory.client.releases[0].GetAssets()
+	if len(assets) != 1 || len(factory.client.repositoryFiles) != 0 {
+		t.Fatalf("Package manifests of the draft release were published: "+
+			"%v, %v", assets, factory.client.repositoryFiles)
+	}
+
+	// The finalizing job hasn't uploaded the binary but generates
+	// the manifests once it publishes the release
+	_, err = finalizeImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		options)
+	if err != nil {
+		t.Fatalf("Failed to finalize the release: %v", err)
+	}
+
+	release := factory.client.releases[0]
+	if release.GetDraft() || len(release.GetAssets()) != 2 {
+		t.Fatalf("Unexpected release after finalizing: %+v", release)
+	}
+
+	formula := factory.client.repositoryFiles["d1vanov/homebrew-tap//Formula/my-app.rb"]
+	if !strings.Contains(formula, "  sha256 \""+tstSha256("macos")+"\"\n") {
+		t.Fatalf("Unexpected formula committed into the tap: %s", formula)
+	}
+}
+
+func TestPackageManifestIsReplacedHoldingItsLock(t *testing.T) {
+	dir := t.TempDir()
+	factory := newSharedTstClientFactory()
+
+	defer func(delay time.Duration) {
+		concurrentUpdateRetryDelay = delay
+	}(concurrentUpdateRetryDelay)
+	concurrentUpdateRetryDelay = 10 * time.Millisecond
+
+	options := Options{
+		Homebrew: &HomebrewConfig{
+			Name:    "my-app",
+			Pattern: "*-macos.tar.gz",
+			Bin:     []string{"app"},
+		},
+	}
+
+	setupTravisCiEnvVars(generateRandomString(16), "master", "v1.2.0",
+		"d1vanov/ciuploadtool", false)
+	upload := func(files map[string]string, logger Logger) error {
+		options.Logger = logger
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, files),
+			options)
+		return err
+	}
+
+	err := upload(map[string]string{"app-win64.zip": "win64"}, &tstLogger{})
+	if err != nil {
+		t.Fatalf("Failed to upload the first file: %v", err)
+	}
+
+	// Another build job is replacing the formula at the moment
+	release := &factory.client.releases[0]
+	for _, name := range []string{
+		"my-app.rb" + lockAssetSuffix,
+		"my-app.rb" + replacementAssetSuffix,
+	} {
+		release.assets = append(release.assets,
+			TstReleaseAsset{id: lastFreeReleaseAssetId, name: name})
+		lastFreeReleaseAssetId++
+	}
+
+	uploadErr := make(chan error, 1)
+	logger := &lockWaitingLogger{waiting: make(chan struct{})}
+	go func() {
+		uploadErr <- upload(map[string]string{"app-macos.tar.gz": "macos"},
+			logger)
+	}()
+
+	select {
+	case <-logger.waiting:
+	case err = <-uploadErr:
+		t.Fatalf("The formula was replaced without waiting for the lock: %v",
+			err)
+	}
+
+	// The other build job finishes the replacement
+	for _, asset := range release.GetAssets() {
+		if isTransientAsset(asset.GetName()) {
+			factory.client.DeleteReleaseAsset(context.Background(),
+				asset.GetID())
+		}
+	}
+
+	err = <-uploadErr
+	if err != nil {
+		t.Fatalf("Failed to upload the second file: %v", err)
+	}
+
+	found := false
+	for _, asset := range factory.client.releases[0].GetAssets() {
+		if isTransientAsset(asset.GetName()) {
+			t.Fatalf("Release asset %s was left behind", asset.GetName())
+		}
+		if asset.GetName() == "my-app.rb" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("The formula was not uploaded")
+	}
+}
+
+func TestHomebrewClassName(t *testing.T) {
+	for name, className := range map[string]string{
+		"app":        "App",
+		"my-app":     "MyApp",
+		"app@2":      "AppAT2",
+		"my_app.cli": "MyAppCli",
+	} {
+		if homebrewClassName(name) != className {
+			t.Fatalf("Unexpected class name of %s: %s", name,
+				homebrewClassName(name))
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Promote creates the regular release for the version at the commit of
+// the release with the source tag and copies all the assets over to it
+func Promote(
+	ctx context.Context,
+	sourceTag string,
+	version string,
+	releaseBody string,
+	repoSlug string,
+	verbose bool) error {
+
+	_, err := promoteImpl(
+		ctx,
+		clientFactoryFunc(newGitHubClient),
+		newGitHubRelease,
+		sourceTag,
+		version,
+		releaseBody,
+		repoSlug,
+		stdoutLogger{},
+		verbose)
+	return err
+}
+
+func promoteImpl(
+	ctx context.Context,
+	clientFactory clientFactoryFunc,
+	releaseFactory ReleaseFactory,
+	sourceTag string,
+	version string,
+	releaseBody string,
+	repoSlug string,
+	logger Logger,
+	verbose bool) (Client, error) {
+
+	if len(sourceTag) == 0 || len(version) == 0 {
+		return nil, errors.New(
+			"Both the release to promote and the version are required")
+	}
+
+	info, err := collectRepoInfo(repoSlug, logger, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	client := clientFactory(info.Token, info.Owner, info.Repo)
+
+	sourceRelease, response, err := client.GetReleaseByTag(ctx, sourceTag)
+	response.CloseBody()
+	if err != nil {
+		return client, fmt.Errorf(
+			"Failed to find the release to promote %s: %v", sourceTag, err)
+	}
+
+	err = response.Check()
+	if err != nil {
+		return client, fmt.Errorf(
+			"Bad response on attempt to find the release to promote: %v", err)
+	}
+
+	commit := sourceRelease.GetTargetCommitish()
+	if len(commit) == 0 {
+		return client, fmt.Errorf(
+			"Failed to determine the commit of release %s", sourceTag)
+	}
+
+	logger.Printf("Promoting release %s at commit %s to %s\n",
+		sourceTag, commit, version)
+
+	// The release is created as draft and published once all the assets are
+	// copied, so the draft at the same commit is left by the interrupted
+	// promotion and is resumed
+	release, response, err := client.GetReleaseByTag(ctx, version)
+	response.CloseBody()
+	releaseExists := err == nil && response.Check() == nil
+	if releaseExists &&
+		(!release.GetDraft() || release.GetTargetCommitish() != commit) {
+		return client, fmt.Errorf("Release %s already exists", version)
+	}
+
+	if releaseExists {
+		logger.Printf("Resuming the promotion to draft release %s\n", version)
+	} else {
+		if len(releaseBody) == 0 {
+			releaseBody = sourceRelease.GetBody()
+		}
+
+		// The release is created with the source release's commit as
+		// the target so the new tag is created at that very commit
+		info.Tag = version
+		info.Commit = commit
+		info.ReleaseTitle = "Release build (" + version + ")"
+		info.IsPrerelease = false
+
+		// The promotion isn't the part of any CI build so the body is kept
+		// without the link to the build log
+		release = releaseFactory(releaseBody, info, verbose)
+		release.SetBody(releaseBody)
+		release.SetDraft(true)
+		release, response, err = client.CreateRelease(ctx, release)
+		response.CloseBody()
+		if err != nil {
+			return client, err
+		}
+
+		err = response.Check()
+		if err != nil {
+			return client, fmt.Errorf(
+				"Bad response on attempt to create the new release: %v", err)
+		}
+
+		logger.Printf("Created new draft release %s\n", version)
+	}
+
+	sourceAssets, err := listPromotedReleaseAssets(ctx, client, sourceRelease)
+	if err != nil {
+		return client, err
+	}
+
+	existingAssets, err := listPromotedReleaseAssets(ctx, client, release)
+	if err != nil {
+		return client, err
+	}
+
+	// The assets are downloaded and uploaded again unless the client can
+	// copy them on the server side
+	copier, canCopy := client.(ReleaseAssetCopier)
+
+	copiedAssets := make(map[string]ReleaseAsset)
+	for _, asset := range existingAssets {
+		copiedAssets[asset.GetName()] = asset
+	}
+
+	for _, sourceAsset := range sourceAssets {
+		if isGeneratedForRelease(sourceAsset.GetName(), sourceTag) {
+			logger.Printf("Skipping release asset %s generated for %s\n",
+				sourceAsset.GetName(), sourceTag)
+			continue
+		}
+
+		copiedAsset, ok := copiedAssets[sourceAsset.GetName()]
+		if ok && copiedAsset.GetSize() == sourceAsset.GetSize() {
+			logger.Printf("Release asset %s is already copied\n",
+				sourceAsset.GetName())
+			continue
+		}
+
+		// The asset was left incomplete by the interrupted promotion
+		if ok {
+			response, err = client.DeleteReleaseAsset(ctx, copiedAsset.GetID())
+			response.CloseBody()
+			if err == nil {
+				err = response.Check()
+			}
+			if err != nil {
+				return client, fmt.Errorf("Failed to delete incomplete "+
+					"release asset %s: %v", copiedAsset.GetName(), err)
+			}
+		}
+
+		logger.Printf("Copying release asset: %s\n", sourceAsset.GetName())
+		if verbose {
+			logger.Printf("Release asset: %s\n", sourceAsset.GetDescription())
+		}
+
+		if canCopy {
+			_, response, err = copier.CopyReleaseAsset(
+				ctx,
+				sourceAsset.GetID(),
+				release.GetID())
+			response.CloseBody()
+		} else {
+			response, err = copyReleaseAssetByDownload(
+				ctx,
+				client,
+				sourceAsset,
+				release.GetID())
+		}
+		if err != nil {
+			return client, err
+		}
+
+		err = response.Check()
+		if err != nil {
+			return client, fmt.Errorf(
+				"Bad response on attempt to copy release asset %s: %v",
+				sourceAsset.GetName(), err)
+		}
+	}
+
+	release.SetDraft(false)
+	_, response, err = client.UpdateRelease(ctx, release)
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		return client, fmt.Errorf("Failed to publish release %s: %v",
+			version, err)
+	}
+
+	logger.Printf("Published release %s\n", version)
+	return client, nil
+}
+
+// Tells whether the release asset was generated by the tool for the release
+// with the tag: zsync files, delta patches, the update feed, the appcast,
+// the SBOM and the provenance point at the binaries of that release or
+// describe them, so they are wrong for the promoted release. The parts of
+// split binaries are the binaries themselves and are promoted.
+func isGeneratedForRelease(name string, tag string) bool {
+	switch name {
+	case defaultUpdateFeedName, defaultAppcastName, sbomName,
+		provenanceName(tag):
+		return true
+	}
+
+	if strings.HasSuffix(name, zsyncSuffix) {
+		return true
+	}
+
+	return strings.HasSuffix(name, deltaPatchSuffix) &&
+		generatedAssetOriginalName(name) != name
+}
+
+// Returns the release assets except the transient ones
+func listPromotedReleaseAssets(
+	ctx context.Context,
+	client Client,
+	release Release) ([]ReleaseAsset, error) {
+
+	assets, response, err := client.ListReleaseAssets(ctx, release.GetID())
+	response.CloseBody()
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.Check()
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Bad response on attempt to list release assets: %v", err)
+	}
+
+	var promotedAssets []ReleaseAsset
+	for _, asset := range assets {
+		if asset.GetID() == 0 || len(asset.GetName()) == 0 ||
+			isTransientAsset(asset.GetName()) {
+			continue
+		}
+		promotedAssets = append(promotedAssets, asset)
+	}
+	return promotedAssets, nil
+}
+
+func copyReleaseAssetByDownload(
+	ctx context.Context,
+	client Client,
+	asset ReleaseAsset,
+	releaseId int64) (Response, error) {
+
+	// The downloaded content is streamed right into the upload
+	content, response, err := client.DownloadReleaseAsset(ctx, asset.GetID())
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return response, nil
+	}
+
+	_, response, err = client.UploadReleaseAsset(
+		ctx,
+		releaseId,
+		AssetUpload{
+			Name:        asset.GetName(),
+			ContentType: asset.GetContentType(),
+			Label:       asset.GetLabel(),
+			Size:        asset.GetSize(),
+			Content:     content,
+		})
+	response.CloseBody()
+	return response, err
+}
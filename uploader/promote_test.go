@@ -0,0 +1,368 @@
+package uploader
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPromotionOfContinuousRelease(t *testing.T) {
+	commit := generateRandomString(16)
+	branch := "master"
+	tag := "continuous-master"
+	version := "v2.3.0"
+	repoSlug := "d1vanov/ciuploadtool"
+
+	setupTravisCiEnvVars(commit, branch, tag, repoSlug, false)
+
+	assetContents := map[string]string{
+		"first.zip":  "First asset content",
+		"second.zip": "Second asset content",
+	}
+
+	sourceReleaseId := int64(0)
+	clientFactory := func(
+		gitHubToken string,
+		owner string,
+		repo string) Client {
+
+		tstRelease := TstRelease{
+			id:              lastFreeReleaseId,
+			name:            "Continuous build (" + tag + ")",
+			body:            "Continuous release",
+			tagName:         tag,
+			targetCommitish: commit,
+			isPrerelease:    true,
+		}
+		lastFreeReleaseId++
+		sourceReleaseId = tstRelease.id
+		// The generated assets point at the continuous release
+		generatedAssets := map[string]string{
+			"first.zip.zsync":                 "URL: continuous",
+			"first.zip.1234567-89abcde.patch": "Patch",
+			defaultUpdateFeedName:             "{}",
+			defaultAppcastName:                "<rss/>",
+			sbomName:                          "{}",
+			provenanceName(tag):               "{}",
+		}
+		for name, content := range generatedAssets {
+			tstRelease.assets = append(tstRelease.assets, TstReleaseAsset{
+				id:      lastFreeReleaseAssetId,
+				name:    name,
+				content: content,
+			})
+			lastFreeReleaseAssetId++
+		}
+		for name, content := range assetContents {
+			tstRelease.assets = append(tstRelease.assets, TstReleaseAsset{
+				id:      lastFreeReleaseAssetId,
+				name:    name,
+				content: content,
+			})
+			lastFreeReleaseAssetId++
+		}
+		tstClient := newTstClient(gitHubToken, owner, repo).(*TstClient)
+		tstClient.releases = append(tstClient.releases, tstRelease)
+		tstClient.tagNames = append(tstClient.tagNames, tag)
+		return tstClient
+	}
+
+	for _, releaseBody := range []string{"", "Stable release"} {
+		client, err := promoteImpl(
+			context.Background(),
+			clientFactoryFunc(clientFactory),
+			ReleaseFactory(newTstRelease),
+			tag,
+			version,
+			releaseBody,
+			"",
+			stdoutLogger{},
+			false)
+		if err != nil {
+			t.Fatalf("Failed to promote the continuous release: %v", err)
+		}
+
+		tstClient, ok := client.(*TstClient)
+		if !ok {
+			t.Fatalf("Failed to cast the client to TstClient")
+		}
+
+		if len(tstClient.releases) != 2 {
+			t.Fatalf("Detected wrong number of releases within client: "+
+				"want 2, have %d", len(tstClient.releases))
+		}
+
+		source := tstClient.releases[0]
+		if source.GetID() != sourceReleaseId || len(source.GetAssets()) != 8 {
+			t.Fatalf("The promoted release was unexpectedly modified")
+		}
+
+		release := tstClient.releases[1]
+		if release.GetTagName() != version {
+			t.Fatalf("Unexpected tag name of the promoted release: %s",
+				release.GetTagName())
+		}
+
+		if release.GetTargetCommitish() != commit {
+			t.Fatalf("Unexpected target commitish of the promoted release")
+		}
+
+		if release.GetPrerelease() {
+			t.Fatalf("The promoted release is unexpectedly prerelease")
+		}
+
+		expectedBody := releaseBody
+		if len(expectedBody) == 0 {
+			expectedBody = source.GetBody()
+		}
+		if release.GetBody() != expectedBody {
+			t.Fatalf("Unexpected body of the promoted release: %s",
+				release.GetBody())
+		}
+
+		assets := release.GetAssets()
+		if len(assets) != len(assetContents) {
+			t.Fatalf("Detected wrong number of promoted release assets: "+
+				"want %d, have %d", len(assetContents), len(assets))
+		}
+
+		for _, asset := range assets {
+			tstAsset := asset.(TstReleaseAsset)
+			if tstAsset.GetContent() != assetContents[tstAsset.GetName()] {
+				t.Fatalf("The contents of the copied release asset %s don't "+
+					"match the original ones", tstAsset.GetName())
+			}
+		}
+	}
+}
+
+func TestPromotionToExistingVersionFails(t *testing.T) {
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+	version := "v2.3.0"
+
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	clientFactory := func(
+		gitHubToken string,
+		owner string,
+		repo string) Client {
+
+		tstClient := newTstClient(gitHubToken, owner, repo).(*TstClient)
+		for _, tagName := range []string{tag, version} {
+			tstClient.releases = append(tstClient.releases, TstRelease{
+				id:              lastFreeReleaseId,
+				name:            tagName,
+				tagName:         tagName,
+				targetCommitish: commit,
+			})
+			lastFreeReleaseId++
+			tstClient.tagNames = append(tstClient.tagNames, tagName)
+		}
+		return tstClient
+	}
+
+	_, err := promoteImpl(
+		context.Background(),
+		clientFactoryFunc(clientFactory),
+		ReleaseFactory(newTstRelease),
+		tag,
+		version,
+		"",
+		"",
+		stdoutLogger{},
+		false)
+	if err == nil {
+		t.Fatalf("Promotion to already existing release unexpectedly succeeded")
+	}
+}
+
+func TestInterruptedPromotionIsResumed(t *testing.T) {
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+	version := "v2.4.0"
+
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	sourceAssets := []TstReleaseAsset{
+		{name: "app.zip", content: "App content",
+			contentType: "application/zip", label: "App for Linux"},
+		{name: "notes.txt", content: "Release notes",
+			contentType: "text/plain"},
+	}
+
+	factory := newSharedTstClientFactory()
+	factory.setup = func(tstClient *TstClient) {
+		tstRelease := TstRelease{
+			id:              lastFreeReleaseId,
+			name:            "Continuous build (" + tag + ")",
+			tagName:         tag,
+			targetCommitish: commit,
+			isPrerelease:    true,
+		}
+		lastFreeReleaseId++
+		for _, asset := range sourceAssets {
+			asset.id = lastFreeReleaseAssetId
+			lastFreeReleaseAssetId++
+			tstRelease.assets = append(tstRelease.assets, asset)
+		}
+		tstClient.releases = append(tstClient.releases, tstRelease)
+		tstClient.tagNames = append(tstClient.tagNames, tag)
+	}
+
+	// The assets are copied by download and upload, which the interrupted
+	// upload leaves incomplete
+	plainClientFactory := func(
+		gitHubToken string,
+		owner string,
+		repo string) Client {
+
+		return tstPlainClient{factory.create(gitHubToken, owner, repo)}
+	}
+
+	promote := func(ctx context.Context) error {
+		_, err := promoteImpl(
+			ctx,
+			clientFactoryFunc(plainClientFactory),
+			ReleaseFactory(newTstRelease),
+			tag,
+			version,
+			"",
+			"",
+			stdoutLogger{},
+			false)
+		return err
+	}
+
+	// The promotion is interrupted during the upload of the first asset
+	// leaving it incomplete within the draft release
+	ctx, cancel := context.WithCancel(context.Background())
+	factory.create("fake_token", "d1vanov", "ciuploadtool")
+	factory.client.hooks = map[string]func(){"UploadReleaseAsset": cancel}
+	err := promote(ctx)
+	if err == nil {
+		t.Fatalf("The interrupted promotion unexpectedly succeeded")
+	}
+
+	if len(factory.client.releases) != 2 ||
+		!factory.client.releases[1].GetDraft() ||
+		len(factory.client.releases[1].assets) != 1 {
+		t.Fatalf("Unexpected releases after the interrupted promotion: %+v",
+			factory.client.releases)
+	}
+
+	err = promote(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to resume the promotion: %v", err)
+	}
+
+	release := factory.client.releases[1]
+	if len(factory.client.releases) != 2 || release.GetDraft() {
+		t.Fatalf("The promoted release wasn't published: %+v", release)
+	}
+
+	if len(release.assets) != len(sourceAssets) {
+		t.Fatalf("Unexpected promoted release assets: %+v", release.assets)
+	}
+
+	for _, expected := range sourceAssets {
+		found := false
+		for _, asset := range release.assets {
+			if asset.name != expected.name {
+				continue
+			}
+			found = true
+			if asset.content != expected.content ||
+				asset.contentType != expected.contentType ||
+				asset.label != expected.label {
+				t.Fatalf("Unexpected promoted release asset: %+v, "+
+					"expected %+v", asset, expected)
+			}
+		}
+		if !found {
+			t.Fatalf("Release asset %s wasn't promoted", expected.name)
+		}
+	}
+
+	// The published release is not resumed
+	err = promote(context.Background())
+	if err == nil {
+		t.Fatalf("Promotion to already published release unexpectedly " +
+			"succeeded")
+	}
+}
+
+func TestPromotionCopiesReleaseAssetsServerSide(t *testing.T) {
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+	version := "v2.5.0"
+
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	sourceAsset := TstReleaseAsset{
+		id:          lastFreeReleaseAssetId,
+		name:        "app.zip",
+		content:     "App content",
+		contentType: "application/zip",
+		label:       "App for Linux",
+	}
+	lastFreeReleaseAssetId++
+
+	factory := newSharedTstClientFactory()
+	factory.setup = func(tstClient *TstClient) {
+		tstClient.releases = append(tstClient.releases, TstRelease{
+			id:              lastFreeReleaseId,
+			name:            "Continuous build (" + tag + ")",
+			tagName:         tag,
+			targetCommitish: commit,
+			isPrerelease:    true,
+			assets:          []TstReleaseAsset{sourceAsset},
+		})
+		lastFreeReleaseId++
+		tstClient.tagNames = append(tstClient.tagNames, tag)
+	}
+
+	factory.create("fake_token", "d1vanov", "ciuploadtool")
+	copied := false
+	factory.client.hooks = map[string]func(){
+		"CopyReleaseAsset": func() { copied = true },
+		"DownloadReleaseAsset": func() {
+			t.Errorf("The release asset was downloaded instead of copying")
+		},
+		"UploadReleaseAsset": func() {
+			t.Errorf("The release asset was uploaded instead of copying")
+		},
+	}
+
+	_, err := promoteImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		ReleaseFactory(newTstRelease),
+		tag,
+		version,
+		"",
+		"",
+		stdoutLogger{},
+		false)
+	if err != nil {
+		t.Fatalf("Failed to promote the continuous release: %v", err)
+	}
+
+	if !copied {
+		t.Fatalf("The release asset wasn't copied on the server side")
+	}
+
+	if len(factory.client.releases) != 2 ||
+		len(factory.client.releases[1].assets) != 1 {
+		t.Fatalf("Unexpected releases after the promotion: %+v",
+			factory.client.releases)
+	}
+
+	asset := factory.client.releases[1].assets[0]
+	if asset.id == sourceAsset.id || asset.name != sourceAsset.name ||
+		asset.content != sourceAsset.content ||
+		asset.contentType != sourceAsset.contentType ||
+		asset.label != sourceAsset.label {
+		t.Fatalf("Unexpected promoted release asset: %+v, expected the copy "+
+			"of %+v", asset, sourceAsset)
+	}
+}
@@ -0,0 +1,386 @@
+package uploader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default environment variable with the ed25519 private key signing
+// the provenance
+const defaultProvenanceKeyEnv = "PROVENANCE_PRIVATE_KEY"
+
+const (
+	intotoStatementType  = "https://in-toto.io/Statement/v0.1"
+	intotoPayloadType    = "application/vnd.in-toto+json"
+	slsaProvenanceType   = "https://slsa.dev/provenance/v0.2"
+	provenanceBuildType  = "https://github.com/d1vanov/ciuploadtool@v1"
+	provenanceNameSuffix = ".intoto.jsonl"
+	defaultBuilderID     = "https://github.com/d1vanov/ciuploadtool"
+)
+
+// in-toto statement with SLSA provenance predicate describing the release
+// assets uploaded by a single build job
+type intotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []intotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type intotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	Builder    slsaBuilder    `json:"builder"`
+	BuildType  string         `json:"buildType"`
+	Invocation slsaInvocation `json:"invocation"`
+	Metadata   slsaMetadata   `json:"metadata"`
+	Materials  []slsaMaterial `json:"materials"`
+}
+
+type slsaBuilder struct {
+	ID string `json:"id"`
+}
+
+type slsaInvocation struct {
+	ConfigSource slsaMaterial `json:"configSource"`
+}
+
+type slsaMetadata struct {
+	BuildInvocationID string `json:"buildInvocationId,omitempty"`
+	BuildFinishedOn   string `json:"buildFinishedOn"`
+}
+
+type slsaMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Dead Simple Signing Envelope carrying the signed statement
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+func provenanceName(tag string) string {
+	return strings.Replace(tag, "/", "-", -1) + provenanceNameSuffix
+}
+
+// Pre-authentication encoding of the payload which is actually signed
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType),
+		payloadType, len(payload), payload))
+}
+
+func signDsseEnvelope(
+	payload []byte,
+	key ed25519.PrivateKey) dsseEnvelope {
+
+	signature := ed25519.Sign(key, dssePAE(intotoPayloadType, payload))
+	return dsseEnvelope{
+		PayloadType: intotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []dsseSignature{{
+			KeyID: ed25519KeyID(key.Public().(ed25519.PublicKey)),
+			Sig:   base64.StdEncoding.EncodeToString(signature),
+		}},
+	}
+}
+
+// Returns the statement within the envelope, the signatures are only
+// checked if the key is given
+func openDsseEnvelope(
+	line string,
+	key ed25519.PublicKey) (*intotoStatement, error) {
+
+	var envelope dsseEnvelope
+	err := json.Unmarshal([]byte(line), &envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	if envelope.PayloadType != intotoPayloadType {
+		return nil, fmt.Errorf("unexpected payload type %s",
+			envelope.PayloadType)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		verified := false
+		for _, signature := range envelope.Signatures {
+			sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+			if err == nil && ed25519.Verify(
+				key, dssePAE(envelope.PayloadType, payload), sig) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return nil, errors.New("no signature made with the key")
+		}
+	}
+
+	var statement intotoStatement
+	err = json.Unmarshal(payload, &statement)
+	if err != nil {
+		return nil, err
+	}
+
+	if statement.Type != intotoStatementType ||
+		statement.PredicateType != slsaProvenanceType {
+		return nil, fmt.Errorf("unexpected statement type %s with "+
+			"predicate type %s", statement.Type, statement.PredicateType)
+	}
+	return &statement, nil
+}
+
+func (statement *intotoStatement) commit() string {
+	materials := statement.Predicate.Materials
+	if len(materials) == 0 {
+		return ""
+	}
+	return materials[0].Digest["sha1"]
+}
+
+func ciBuilderID(info *BuildInfo) string {
+	switch info.Provider {
+	case ProviderTravisCi:
+		return "https://travis-ci.org"
+	case ProviderAppVeyor:
+		return "https://ci.appveyor.com"
+	}
+	return defaultBuilderID
+}
+
+// Describes the release assets uploaded by the current build job
+func (uploader *Uploader) provenanceStatement() *intotoStatement {
+	info := uploader.info
+	client := uploader.client
+	repoURI := "git+https://github.com/" + client.GetOwner() + "/" +
+		client.GetRepo()
+
+	ref := "refs/tags/" + info.Tag
+	if len(info.Branch) != 0 {
+		ref = "refs/heads/" + info.Branch
+	}
+
+	statement := &intotoStatement{
+		Type:          intotoStatementType,
+		PredicateType: slsaProvenanceType,
+		Predicate: slsaProvenance{
+			Builder:   slsaBuilder{ID: ciBuilderID(info)},
+			BuildType: provenanceBuildType,
+			Invocation: slsaInvocation{
+				ConfigSource: slsaMaterial{
+					URI:    repoURI + "@" + ref,
+					Digest: map[string]string{"sha1": info.Commit},
+				},
+			},
+			Metadata: slsaMetadata{
+				BuildInvocationID: ciBuildLogURL(info),
+				BuildFinishedOn:   time.Now().UTC().Format(time.RFC3339),
+			},
+			Materials: []slsaMaterial{{
+				URI:    repoURI,
+				Digest: map[string]string{"sha1": info.Commit},
+			}},
+		},
+	}
+
+	for _, asset := range uploader.result.Assets {
+		digest, ok := uploader.assetDigests[asset.GetName()]
+		if ok {
+			statement.Subject = append(statement.Subject, intotoSubject{
+				Name:   asset.GetName(),
+				Digest: map[string]string{"sha256": digest},
+			})
+		}
+	}
+
+	sort.Slice(statement.Subject, func(i, j int) bool {
+		return statement.Subject[i].Name < statement.Subject[j].Name
+	})
+	return statement
+}
+
+// Appends the signed statement describing the release assets uploaded by
+// the current build job to the provenance of the release. Other build jobs
+// of the matrix append their own statements while the statements made for
+// other commits are dropped. The provenance is read and replaced holding
+// its lock so the statements appended concurrently are kept.
+func (uploader *Uploader) updateProvenance(ctx context.Context) error {
+	logger := uploader.logger
+	statement := uploader.provenanceStatement()
+	if len(statement.Subject) == 0 {
+		return nil
+	}
+
+	keyEnv := uploader.options.ProvenanceKeyEnv
+	if len(keyEnv) == 0 {
+		keyEnv = defaultProvenanceKeyEnv
+	}
+	key, err := loadEd25519PrivateKey(keyEnv)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := json.Marshal(signDsseEnvelope(payload, key))
+	if err != nil {
+		return err
+	}
+
+	line := string(envelope)
+	name := provenanceName(uploader.info.Tag)
+	return uploader.withLock(ctx, name, func() error {
+		lines, err := uploader.readProvenance(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		var content bytes.Buffer
+		for _, existingLine := range lines {
+			existing, err := openDsseEnvelope(existingLine, nil)
+			if err == nil && existing.commit() == uploader.info.Commit {
+				content.WriteString(existingLine + "\n")
+			}
+		}
+		content.WriteString(line + "\n")
+
+		logger.Printf("Uploading provenance %s of %d release assets signed "+
+			"with key %s\n", name, len(statement.Subject),
+			ed25519KeyID(key.Public().(ed25519.PublicKey)))
+		source := memoryAssetSource(name, name, content.Bytes())
+		source.contentType = "application/jsonl"
+		_, err = uploader.replaceReleaseAsset(ctx, source)
+		return err
+	})
+}
+
+// Returns the lines of the provenance within the release, if any
+func (uploader *Uploader) readProvenance(
+	ctx context.Context,
+	name string) ([]string, error) {
+
+	provenanceAsset, err := uploader.findReleaseAsset(ctx, name)
+	if err != nil || provenanceAsset == nil {
+		return nil, err
+	}
+
+	content, response, err := uploader.client.DownloadReleaseAsset(
+		ctx, provenanceAsset.GetID())
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return nil, fmt.Errorf("Bad response on attempt to download "+
+			"the provenance: %v", err)
+	}
+
+	return provenanceLines(content)
+}
+
+func provenanceLines(reader io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) != 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// VerifyProvenance checks that every statement within the provenance is
+// signed with the ed25519 public key from the given file and that each of
+// the files is among the subjects of the statements. Verification happens
+// offline, the files are matched by their SHA-256 digests.
+func VerifyProvenance(
+	provenanceFilename string,
+	publicKeyFilename string,
+	filenames []string) error {
+
+	keyText, err := ioutil.ReadFile(publicKeyFilename)
+	if err != nil {
+		return err
+	}
+
+	key, err := parseEd25519PublicKey(string(keyText))
+	if err != nil {
+		return fmt.Errorf("Invalid ed25519 public key within %s: %v",
+			publicKeyFilename, err)
+	}
+
+	content, err := ioutil.ReadFile(provenanceFilename)
+	if err != nil {
+		return err
+	}
+
+	lines, err := provenanceLines(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("No statements within provenance %s",
+			provenanceFilename)
+	}
+
+	digests := make(map[string]bool)
+	for i, line := range lines {
+		statement, err := openDsseEnvelope(line, key)
+		if err != nil {
+			return fmt.Errorf("Failed to verify statement %d of %s: %v",
+				i+1, provenanceFilename, err)
+		}
+
+		for _, subject := range statement.Subject {
+			digests[subject.Digest["sha256"]] = true
+		}
+	}
+
+	for _, filename := range filenames {
+		digest, err := fileSha256(filename)
+		if err != nil {
+			return err
+		}
+
+		if !digests[digest] {
+			return fmt.Errorf("%s with SHA-256 %s is not among the subjects "+
+				"of provenance %s", filename, digest, provenanceFilename)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package uploader
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvenanceSignedAndVerified(t *testing.T) {
+	dir := t.TempDir()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate the key: %v", err)
+	}
+
+	os.Setenv("TEST_PROVENANCE_KEY",
+		base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	defer os.Unsetenv("TEST_PROVENANCE_KEY")
+
+	factory := newSharedTstClientFactory()
+
+	// Returns the statements of the provenance within the release
+	upload := func(commit string, files map[string]string) []string {
+		setupTravisCiEnvVars(commit, "master", "", "d1vanov/ciuploadtool",
+			false)
+
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, files),
+			Options{
+				ReleaseSuffix:    "master",
+				UpdateTag:        true,
+				Provenance:       true,
+				ProvenanceKeyEnv: "TEST_PROVENANCE_KEY",
+			})
+		if err != nil {
+			t.Fatalf("Failed to upload the files: %v", err)
+		}
+
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			if asset.GetName() == "continuous-master.intoto.jsonl" {
+				content := asset.(TstReleaseAsset).GetContent()
+				lines, _ := provenanceLines(strings.NewReader(content))
+				return lines
+			}
+		}
+
+		t.Fatalf("Provenance is missing within the release")
+		return nil
+	}
+
+	// Build jobs for the same commit append their statements
+	commit := generateRandomString(16)
+	upload(commit, map[string]string{"app-linux": "linux binary"})
+	lines := upload(commit, map[string]string{"app-macos": "macos binary"})
+	if len(lines) != 2 {
+		t.Fatalf("Expected statements of two build jobs, got %d", len(lines))
+	}
+
+	statement, err := openDsseEnvelope(lines[1], publicKey)
+	if err != nil {
+		t.Fatalf("Failed to verify the statement: %v", err)
+	}
+
+	predicate := statement.Predicate
+	if len(statement.Subject) != 1 ||
+		statement.Subject[0].Name != "app-macos" ||
+		statement.Subject[0].Digest["sha256"] != tstSha256("macos binary") ||
+		predicate.Builder.ID != "https://travis-ci.org" ||
+		!strings.HasPrefix(predicate.Metadata.BuildInvocationID,
+			"https://travis-ci.org/d1vanov/ciuploadtool/builds/") ||
+		predicate.Invocation.ConfigSource.URI !=
+			"git+https://github.com/d1vanov/ciuploadtool@refs/heads/master" ||
+		statement.commit() != commit {
+		t.Fatalf("Unexpected statement: %+v", statement)
+	}
+
+	provenanceFilename := filepath.Join(dir, "provenance.intoto.jsonl")
+	err = ioutil.WriteFile(provenanceFilename,
+		[]byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the provenance: %v", err)
+	}
+
+	keyFilename := filepath.Join(dir, "key.pub")
+	err = ioutil.WriteFile(keyFilename,
+		[]byte(base64.StdEncoding.EncodeToString(publicKey)), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the public key: %v", err)
+	}
+
+	linuxBinary := filepath.Join(dir, "app-linux")
+	macosBinary := filepath.Join(dir, "app-macos")
+	err = VerifyProvenance(provenanceFilename, keyFilename,
+		[]string{linuxBinary, macosBinary})
+	if err != nil {
+		t.Fatalf("Failed to verify the provenance: %v", err)
+	}
+
+	err = ioutil.WriteFile(macosBinary, []byte("tampered binary"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to modify the binary: %v", err)
+	}
+	err = VerifyProvenance(provenanceFilename, keyFilename,
+		[]string{macosBinary})
+	if err == nil {
+		t.Fatalf("The provenance of the modified binary was verified")
+	}
+
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	err = ioutil.WriteFile(keyFilename,
+		[]byte(base64.StdEncoding.EncodeToString(otherKey)), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the public key: %v", err)
+	}
+	err = VerifyProvenance(provenanceFilename, keyFilename,
+		[]string{linuxBinary})
+	if err == nil {
+		t.Fatalf("The provenance was verified with other key")
+	}
+
+	// The statements made for the previous commit are dropped
+	lines = upload(generateRandomString(16),
+		map[string]string{"app-linux": "new linux binary"})
+	if len(lines) != 1 {
+		t.Fatalf("Expected the statement of the new commit only, got %d",
+			len(lines))
+	}
+}
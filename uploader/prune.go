@@ -0,0 +1,190 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Name of the release body section listing the release assets uploaded by
+// the build jobs of the builds of the commit the release was last updated by
+const uploadedAssetsSection = "uploaded-assets"
+
+// The build which uploaded the release asset. Builds of different CI
+// providers for the same commit always have different ids, so the build id
+// distinguishes the rebuilds of the commit only among the builds of the same
+// provider.
+type uploadedAssetBuild struct {
+	provider string
+	buildId  string
+}
+
+// Returns the first line of the section identifying the commit, the builds
+// of all CI providers for the commit share the section
+func uploadedAssetsSectionHeader(info *BuildInfo) string {
+	return "Binaries uploaded for commit `" + info.Commit + "`:"
+}
+
+// Returns the release assets listed within the section along with the builds
+// which uploaded them if the section lists the assets uploaded for the commit
+func uploadedAssetsSectionEntries(
+	body string,
+	info *BuildInfo) map[string]uploadedAssetBuild {
+
+	entries := make(map[string]uploadedAssetBuild)
+	content, ok := releaseBodySection(body, uploadedAssetsSection)
+	if !ok {
+		return entries
+	}
+
+	lines := strings.Split(content, "\n")
+	if lines[0] != uploadedAssetsSectionHeader(info) {
+		return entries
+	}
+
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, "* `") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "* `")
+		nameEnd := strings.Index(line, "`")
+		if nameEnd <= 0 {
+			continue
+		}
+
+		var build uploadedAssetBuild
+		suffix := line[nameEnd+1:]
+		if strings.HasPrefix(suffix, " (") && strings.HasSuffix(suffix, "`)") {
+			fields := strings.SplitN(strings.TrimSuffix(
+				strings.TrimPrefix(suffix, " ("), "`)"), " build `", 2)
+			if len(fields) == 2 {
+				build = uploadedAssetBuild{provider: fields[0], buildId: fields[1]}
+			}
+		}
+		entries[line[:nameEnd]] = build
+	}
+	return entries
+}
+
+// Returns the names of the release assets listed within the section as
+// uploaded by the current builds of all CI providers: the assets listed by
+// the previous build of the provider the current build runs on don't count
+func uploadedAssetsSectionNames(
+	body string,
+	info *BuildInfo) map[string]bool {
+
+	names := make(map[string]bool)
+	for name, build := range uploadedAssetsSectionEntries(body, info) {
+		if build.provider != info.Provider || build.buildId == info.BuildId {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func uploadedAssetsSectionContent(
+	info *BuildInfo,
+	entries map[string]uploadedAssetBuild) string {
+
+	sortedNames := make([]string, 0, len(entries))
+	for name := range entries {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	content := uploadedAssetsSectionHeader(info) + "\n\n"
+	for _, name := range sortedNames {
+		content += "* `" + name + "`"
+		build := entries[name]
+		if len(build.buildId) != 0 {
+			content += " (" + build.provider + " build `" + build.buildId + "`)"
+		}
+		content += "\n"
+	}
+	return strings.TrimSuffix(content, "\n")
+}
+
+// Adds the release assets uploaded by the current build job to the list of
+// assets uploaded for the current commit within the release body. The list
+// made for another commit is replaced, as are the entries of the previous
+// build of the same CI provider, while the entries of the builds of other CI
+// providers are kept.
+func (uploader *Uploader) recordUploadedAssets(ctx context.Context) error {
+	info := uploader.info
+	current := uploadedAssetBuild{provider: info.Provider, buildId: info.BuildId}
+	return uploader.updateRelease(ctx, "list uploaded binaries within "+
+		"the release body", func(release Release) error {
+		entries := uploadedAssetsSectionEntries(release.GetBody(), info)
+		for name, build := range entries {
+			if build.provider == current.provider && build != current {
+				delete(entries, name)
+			}
+		}
+		for _, asset := range uploader.result.Assets {
+			entries[asset.GetName()] = current
+		}
+
+		release.SetBody(setReleaseBodySection(
+			release.GetBody(),
+			uploadedAssetsSection,
+			uploadedAssetsSectionContent(info, entries)))
+		return nil
+	})
+}
+
+// Deletes the release assets no build job of the current builds has
+// uploaded, i.e. the binaries of the platforms dropped from the build matrix.
+// The release is only pruned once each of the expected assets is uploaded
+// and listed within the release body, which means all the build jobs have
+// reported in.
+func (uploader *Uploader) pruneReleaseAssets(ctx context.Context) error {
+	logger := uploader.logger
+	release, err := uploader.currentRelease(ctx)
+	if err != nil {
+		return err
+	}
+
+	names := uploadedAssetsSectionNames(release.GetBody(), uploader.info)
+	if len(names) == 0 {
+		logger.Printf("No binaries are listed as uploaded by the current " +
+			"build, not pruning the release\n")
+		return nil
+	}
+
+	for _, expectedAsset := range uploader.options.ExpectedAssets {
+		if !names[expectedAsset] {
+			logger.Printf("Expected release asset %s isn't uploaded yet, "+
+				"not pruning the release\n", expectedAsset)
+			return nil
+		}
+	}
+
+	assets, response, err := uploader.client.ListReleaseAssets(
+		ctx, release.GetID())
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to list release assets to prune: %v", err)
+	}
+
+	for _, asset := range assets {
+		// Locks and replacements belong to build jobs still running
+		if asset.GetID() == 0 || names[asset.GetName()] ||
+			isTransientAsset(asset.GetName()) {
+			continue
+		}
+
+		logger.Printf("Pruning release asset %s not uploaded by any job "+
+			"of the current build\n", asset.GetName())
+		err = uploader.deleteReleaseAsset(ctx, asset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package uploader
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPruneAssetsOfDroppedBuildJobs(t *testing.T) {
+	dir := t.TempDir()
+
+	factory := newSharedTstClientFactory()
+
+	checkAssets := func(expectedNames ...string) {
+		var names []string
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			names = append(names, asset.GetName())
+		}
+		sort.Strings(names)
+
+		if strings.Join(names, ",") != strings.Join(expectedNames, ",") {
+			t.Fatalf("Unexpected release assets: %v, expected %v", names,
+				expectedNames)
+		}
+	}
+
+	upload := func(
+		commit string,
+		buildId string,
+		name string,
+		expectedAssets []string) {
+
+		setupTravisCiEnvVars(commit, "master", "", "d1vanov/ciuploadtool",
+			false)
+		os.Setenv("TRAVIS_BUILD_ID", buildId)
+
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, map[string]string{name: name + commit}),
+			Options{
+				ReleaseSuffix:  "master",
+				UpdateTag:      true,
+				ExpectedAssets: expectedAssets,
+				Prune:          true,
+			})
+		if err != nil {
+			t.Fatalf("Failed to upload %s: %v", name, err)
+		}
+	}
+
+	commit := generateRandomString(16)
+	upload(commit, "1", "app-linux", nil)
+	upload(commit, "1", "app-macos", nil)
+	checkAssets("app-linux", "app-macos")
+
+	// The commit is rebuilt with the macOS job dropped from the build matrix
+	// and the Windows one added, nothing is pruned until the Windows job
+	// reports in
+	expectedAssets := []string{"app-linux", "app-windows"}
+	upload(commit, "2", "app-linux", expectedAssets)
+	checkAssets("app-linux", "app-macos")
+
+	upload(commit, "2", "app-windows", expectedAssets)
+	checkAssets("app-linux", "app-windows")
+
+	names := uploadedAssetsSectionNames(factory.client.releases[0].GetBody(),
+		&BuildInfo{Commit: commit, Provider: ProviderTravisCi, BuildId: "2"})
+	if len(names) != 2 || !names["app-linux"] || !names["app-windows"] {
+		t.Fatalf("Unexpected binaries listed within the release body: %v",
+			names)
+	}
+}
+
+func TestFinalizePrunesRelease(t *testing.T) {
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	for _, releaseCommit := range []string{commit, generateRandomString(16)} {
+		factory := newSharedTstClientFactory()
+		factory.setup = func(tstClient *TstClient) {
+			tstClient.releases = append(tstClient.releases, TstRelease{
+				id:              lastFreeReleaseId,
+				name:            "Continuous build (" + tag + ")",
+				tagName:         tag,
+				targetCommitish: releaseCommit,
+				body: setReleaseBodySection("", uploadedAssetsSection,
+					uploadedAssetsSectionContent(
+						&BuildInfo{Commit: releaseCommit},
+						map[string]uploadedAssetBuild{
+							"app-linux": {
+								provider: ProviderTravisCi,
+								buildId:  os.Getenv("TRAVIS_BUILD_ID"),
+							},
+						})),
+				isPrerelease: true,
+			})
+			for _, name := range []string{"app-linux", "app-macos"} {
+				tstClient.releases[0].assets = append(
+					tstClient.releases[0].assets,
+					TstReleaseAsset{id: lastFreeReleaseAssetId, name: name})
+				lastFreeReleaseAssetId++
+			}
+			lastFreeReleaseId++
+		}
+
+		client, err := finalizeImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			Options{ReleaseSuffix: "master", Prune: true})
+		if err != nil {
+			t.Fatalf("Failed to finalize the release: %v", err)
+		}
+
+		assets := client.(*TstClient).releases[0].GetAssets()
+		if releaseCommit == commit &&
+			(len(assets) != 1 || assets[0].GetName() != "app-linux") {
+			t.Fatalf("The release wasn't pruned by finalize: %v", assets)
+		}
+		if releaseCommit != commit && len(assets) != 2 {
+			t.Fatalf("The release of another commit was pruned by finalize")
+		}
+	}
+}
+
+func TestPruneKeepsAssetsOfOtherCiProvider(t *testing.T) {
+	dir := t.TempDir()
+	factory := newSharedTstClientFactory()
+	commit := generateRandomString(16)
+
+	upload := func(name string) {
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, map[string]string{name: name + commit}),
+			Options{ReleaseSuffix: "master", UpdateTag: true, Prune: true})
+		if err != nil {
+			t.Fatalf("Failed to upload %s: %v", name, err)
+		}
+	}
+
+	// The job of the previous Travis CI build of the commit is dropped from
+	// the build matrix
+	setupTravisCiEnvVars(commit, "master", "", "d1vanov/ciuploadtool", false)
+	upload("app-macos")
+
+	setupTravisCiEnvVars(commit, "master", "", "d1vanov/ciuploadtool", false)
+	travisBuildId := os.Getenv("TRAVIS_BUILD_ID")
+	upload("app-linux")
+
+	setupAppVeyorCiEnvVars(commit, "master", "", "d1vanov/ciuploadtool",
+		false)
+	upload("app-windows")
+
+	// The last Travis CI job finalizes the release
+	setupTravisCiEnvVars(commit, "master", "", "d1vanov/ciuploadtool", false)
+	os.Setenv("TRAVIS_BUILD_ID", travisBuildId)
+	_, err := finalizeImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		Options{
+			ReleaseSuffix:  "master",
+			Prune:          true,
+			ExpectedAssets: []string{"app-linux", "app-windows"},
+		})
+	if err != nil {
+		t.Fatalf("Failed to finalize the release: %v", err)
+	}
+
+	var names []string
+	for _, asset := range factory.client.releases[0].GetAssets() {
+		names = append(names, asset.GetName())
+	}
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "app-linux,app-windows" {
+		t.Fatalf("Unexpected release assets: %v", names)
+	}
+}
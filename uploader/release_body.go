@@ -0,0 +1,84 @@
+package uploader
+
+import (
+	"strings"
+)
+
+// Sections of release bodies managed by the tool are delimited by HTML
+// comments which GitHub doesn't render, so that the sections can be found
+// and updated without touching the rest of the body
+
+func releaseBodySectionMarkers(section string) (string, string) {
+	return "<!-- ciuploadtool:" + section + " -->",
+		"<!-- /ciuploadtool:" + section + " -->"
+}
+
+// Returns the content of the section and whether the body has it
+func releaseBodySection(body string, section string) (string, bool) {
+	begin, end := releaseBodySectionMarkers(section)
+	beginIndex := strings.Index(body, begin)
+	if beginIndex < 0 {
+		return "", false
+	}
+
+	content := body[beginIndex+len(begin):]
+	endIndex := strings.Index(content, end)
+	if endIndex < 0 {
+		return "", false
+	}
+
+	return strings.Trim(content[:endIndex], "\n"), true
+}
+
+// Replaces the content of the section or appends the section to the body if
+// it doesn't have it yet, the empty content removes the section
+func setReleaseBodySection(body string, section string, content string) string {
+	begin, end := releaseBodySectionMarkers(section)
+
+	prefix := body
+	suffix := ""
+	beginIndex := strings.Index(body, begin)
+	if beginIndex >= 0 {
+		endIndex := strings.Index(body[beginIndex:], end)
+		if endIndex >= 0 {
+			prefix = body[:beginIndex]
+			suffix = body[beginIndex+endIndex+len(end):]
+			suffix = strings.TrimPrefix(suffix, "\n")
+		}
+	}
+
+	if len(content) == 0 {
+		return prefix + suffix
+	}
+
+	if len(prefix) != 0 && !strings.HasSuffix(prefix, "\n") {
+		prefix += "\n"
+	}
+
+	return prefix + begin + "\n" + content + "\n" + end + "\n" + suffix
+}
+
+// Removes all the sections managed by the tool from the body, leaving only
+// the text written by people
+func stripReleaseBodySections(body string) string {
+	const beginPrefix = "<!-- ciuploadtool:"
+	for {
+		beginIndex := strings.Index(body, beginPrefix)
+		if beginIndex < 0 {
+			return body
+		}
+
+		rest := body[beginIndex+len(beginPrefix):]
+		nameEnd := strings.Index(rest, " -->")
+		if nameEnd < 0 {
+			return body
+		}
+
+		stripped := setReleaseBodySection(body, rest[:nameEnd], "")
+		if stripped == body {
+			// The section isn't closed, the rest of the body is kept
+			return body
+		}
+		body = stripped
+	}
+}
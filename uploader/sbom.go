@@ -0,0 +1,437 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the SPDX software bill of materials release asset
+const sbomName = "sbom.spdx.json"
+
+const sbomDocumentID = "SPDXRef-DOCUMENT"
+
+// Subset of SPDX 2.3 JSON document describing release assets. Go binaries
+// are described as packages containing the modules they were built from,
+// other release assets as files with their checksums.
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages,omitempty"`
+	Files             []spdxFile         `json:"files,omitempty"`
+	Relationships     []spdxRelationship `json:"relationships,omitempty"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	PackageFileName       string            `json:"packageFileName,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	Comment               string            `json:"comment,omitempty"`
+}
+
+type spdxFile struct {
+	SPDXID    string         `json:"SPDXID"`
+	FileName  string         `json:"fileName"`
+	Checksums []spdxChecksum `json:"checksums"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SpdxElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+// Release asset described within the SBOM, either the package of Go binary
+// along with its modules or the file
+type sbomArtifact struct {
+	pkg     *spdxPackage
+	file    *spdxFile
+	modules []spdxPackage
+}
+
+func (artifact *sbomArtifact) name() string {
+	if artifact.pkg != nil {
+		return artifact.pkg.PackageFileName
+	}
+	return strings.TrimPrefix(artifact.file.FileName, "./")
+}
+
+func (artifact *sbomArtifact) sha256() string {
+	var checksums []spdxChecksum
+	if artifact.pkg != nil {
+		checksums = artifact.pkg.Checksums
+	} else {
+		checksums = artifact.file.Checksums
+	}
+
+	for _, checksum := range checksums {
+		if checksum.Algorithm == "SHA256" {
+			return checksum.ChecksumValue
+		}
+	}
+	return ""
+}
+
+func goModulePurl(path string, version string) string {
+	if len(version) == 0 || version == "(devel)" {
+		return "pkg:golang/" + path
+	}
+	return "pkg:golang/" + path + "@" + version
+}
+
+func goModuleSbomPackage(module *debug.Module) spdxPackage {
+	if module.Replace != nil {
+		module = module.Replace
+	}
+
+	return spdxPackage{
+		Name:             module.Path,
+		VersionInfo:      module.Version,
+		DownloadLocation: "NOASSERTION",
+		ExternalRefs: []spdxExternalRef{{
+			ReferenceCategory: "PACKAGE-MANAGER",
+			ReferenceType:     "purl",
+			ReferenceLocator:  goModulePurl(module.Path, module.Version),
+		}},
+	}
+}
+
+func goBinarySbomArtifact(
+	name string,
+	url string,
+	checksums []spdxChecksum,
+	info *buildinfo.BuildInfo) *sbomArtifact {
+
+	pkg := &spdxPackage{
+		Name:                  name,
+		PackageFileName:       name,
+		DownloadLocation:      url,
+		Checksums:             checksums,
+		PrimaryPackagePurpose: "APPLICATION",
+		Comment:               "Built with " + info.GoVersion,
+	}
+
+	if len(info.Main.Path) != 0 {
+		if info.Main.Version != "(devel)" {
+			pkg.VersionInfo = info.Main.Version
+		}
+		pkg.ExternalRefs = []spdxExternalRef{{
+			ReferenceCategory: "PACKAGE-MANAGER",
+			ReferenceType:     "purl",
+			ReferenceLocator:  goModulePurl(info.Main.Path, info.Main.Version),
+		}}
+	}
+
+	artifact := &sbomArtifact{pkg: pkg}
+	for _, dep := range info.Deps {
+		artifact.modules = append(artifact.modules, goModuleSbomPackage(dep))
+	}
+	return artifact
+}
+
+// Go binaries are recognized by the build info embedded into them. Parts of
+// split files are only hashed as reading them into memory might take too much
+// of it.
+func (uploader *Uploader) sourceSbomArtifact(
+	source *assetSource) (*sbomArtifact, error) {
+
+	content, err := source.open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	var reader io.Reader = content
+	readerAt, ok := content.(io.ReaderAt)
+	if !ok && len(source.splitFrom) == 0 {
+		data, err := ioutil.ReadAll(content)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+		readerAt = bytes.NewReader(data)
+	}
+
+	sha1Hash := sha1.New()
+	sha256Hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(sha1Hash, sha256Hash), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := []spdxChecksum{
+		{"SHA1", hex.EncodeToString(sha1Hash.Sum(nil))},
+		{"SHA256", hex.EncodeToString(sha256Hash.Sum(nil))},
+	}
+
+	if readerAt != nil {
+		info, err := buildinfo.Read(readerAt)
+		if err == nil {
+			url := releaseAssetDownloadURL(uploader.client.GetOwner(),
+				uploader.client.GetRepo(), uploader.info.Tag, source.name)
+			return goBinarySbomArtifact(source.name, url, checksums, info), nil
+		}
+	}
+
+	return &sbomArtifact{
+		file: &spdxFile{FileName: "./" + source.name, Checksums: checksums},
+	}, nil
+}
+
+// Extracts the release assets described by the document along with
+// the modules of Go binaries
+func sbomArtifacts(document *spdxDocument) map[string]*sbomArtifact {
+	packages := make(map[string]spdxPackage)
+	for _, pkg := range document.Packages {
+		packages[pkg.SPDXID] = pkg
+	}
+
+	files := make(map[string]spdxFile)
+	for _, file := range document.Files {
+		files[file.SPDXID] = file
+	}
+
+	byID := make(map[string]*sbomArtifact)
+	for _, relationship := range document.Relationships {
+		if relationship.SpdxElementID != sbomDocumentID ||
+			relationship.RelationshipType != "DESCRIBES" {
+			continue
+		}
+
+		id := relationship.RelatedSpdxElement
+		if pkg, ok := packages[id]; ok && len(pkg.PackageFileName) != 0 {
+			byID[id] = &sbomArtifact{pkg: &pkg}
+		} else if file, ok := files[id]; ok {
+			byID[id] = &sbomArtifact{file: &file}
+		}
+	}
+
+	for _, relationship := range document.Relationships {
+		artifact, ok := byID[relationship.SpdxElementID]
+		module, isPackage := packages[relationship.RelatedSpdxElement]
+		if ok && isPackage && relationship.RelationshipType == "CONTAINS" {
+			artifact.modules = append(artifact.modules, module)
+		}
+	}
+
+	artifacts := make(map[string]*sbomArtifact)
+	for _, artifact := range byID {
+		artifacts[artifact.name()] = artifact
+	}
+	return artifacts
+}
+
+// Assigns identifiers to the artifacts sorted by names, modules shared by
+// several Go binaries are listed once
+func (uploader *Uploader) sbomDocument(
+	artifacts map[string]*sbomArtifact) *spdxDocument {
+
+	info := uploader.info
+	client := uploader.client
+	created := time.Now().UTC()
+	document := &spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      sbomDocumentID,
+		Name:        client.GetRepo() + " " + info.Tag,
+		DocumentNamespace: fmt.Sprintf("%s-%s-%d",
+			releaseAssetDownloadURL(client.GetOwner(), client.GetRepo(),
+				info.Tag, sbomName),
+			shortCommit(info.Commit), created.Unix()),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.Format(time.RFC3339),
+			Creators: []string{"Tool: ciuploadtool"},
+		},
+	}
+
+	names := make([]string, 0, len(artifacts))
+	for name := range artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	moduleIDs := make(map[string]string)
+	for _, name := range names {
+		artifact := artifacts[name]
+		var id string
+		if artifact.pkg != nil {
+			pkg := *artifact.pkg
+			pkg.SPDXID = fmt.Sprintf("SPDXRef-Package-%d",
+				len(document.Packages)+1)
+			id = pkg.SPDXID
+			document.Packages = append(document.Packages, pkg)
+		} else {
+			file := *artifact.file
+			file.SPDXID = fmt.Sprintf("SPDXRef-File-%d", len(document.Files)+1)
+			id = file.SPDXID
+			document.Files = append(document.Files, file)
+		}
+
+		document.Relationships = append(document.Relationships,
+			spdxRelationship{sbomDocumentID, "DESCRIBES", id})
+
+		for _, module := range artifact.modules {
+			key := module.Name + "@" + module.VersionInfo
+			moduleID, ok := moduleIDs[key]
+			if !ok {
+				module.SPDXID = fmt.Sprintf("SPDXRef-Module-%d",
+					len(moduleIDs)+1)
+				moduleID = module.SPDXID
+				moduleIDs[key] = moduleID
+				document.Packages = append(document.Packages, module)
+			}
+
+			document.Relationships = append(document.Relationships,
+				spdxRelationship{id, "CONTAINS", moduleID})
+		}
+	}
+
+	return document
+}
+
+// Merges the release assets uploaded by the current build job into the SBOM
+// within the release. Other build jobs of the matrix contribute their own
+// assets, so the SBOM is read and replaced holding its lock. The assets no
+// longer present within the release are dropped.
+func (uploader *Uploader) updateSbom(
+	ctx context.Context,
+	sources []*assetSource) error {
+
+	logger := uploader.logger
+	artifacts := make(map[string]*sbomArtifact)
+	for _, source := range sources {
+		artifact, err := uploader.sourceSbomArtifact(source)
+		if err != nil {
+			return fmt.Errorf("Failed to describe %s within SBOM: %v",
+				source.name, err)
+		}
+		artifacts[source.name] = artifact
+	}
+
+	if len(artifacts) == 0 {
+		return nil
+	}
+
+	return uploader.withLock(ctx, sbomName, func() error {
+		existing, assetNames, err := uploader.readSbom(ctx)
+		if err != nil {
+			return err
+		}
+
+		merged := make(map[string]*sbomArtifact)
+		for name, artifact := range existing {
+			if assetNames[name] {
+				merged[name] = artifact
+			}
+		}
+		for name, artifact := range artifacts {
+			merged[name] = artifact
+		}
+
+		content, err := json.MarshalIndent(
+			uploader.sbomDocument(merged), "", "  ")
+		if err != nil {
+			return err
+		}
+
+		logger.Printf("Uploading SBOM %s describing %d release assets\n",
+			sbomName, len(merged))
+		source := memoryAssetSource(sbomName, sbomName, append(content, '\n'))
+		source.contentType = "application/spdx+json"
+		_, err = uploader.replaceReleaseAsset(ctx, source)
+		return err
+	})
+}
+
+// Returns the artifacts described by the SBOM within the release, if any,
+// along with the names of all release assets
+func (uploader *Uploader) readSbom(ctx context.Context) (
+	map[string]*sbomArtifact, map[string]bool, error) {
+
+	client := uploader.client
+	assets, response, err := client.ListReleaseAssets(
+		ctx, uploader.release.GetID())
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to list release assets "+
+			"to find SBOM: %v", err)
+	}
+
+	var sbomAsset ReleaseAsset
+	assetNames := make(map[string]bool)
+	for _, asset := range assets {
+		if asset.GetID() == 0 {
+			continue
+		}
+		assetNames[asset.GetName()] = true
+		if asset.GetName() == sbomName {
+			sbomAsset = asset
+		}
+	}
+	if sbomAsset == nil {
+		return nil, assetNames, nil
+	}
+
+	content, response, err := client.DownloadReleaseAsset(
+		ctx, sbomAsset.GetID())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Bad response on attempt to "+
+			"download SBOM: %v", err)
+	}
+
+	var document spdxDocument
+	err = json.NewDecoder(content).Decode(&document)
+	if err != nil {
+		uploader.logger.Printf("Replacing malformed SBOM %s: %v\n",
+			sbomName, err)
+		return nil, assetNames, nil
+	}
+
+	return sbomArtifacts(&document), assetNames, nil
+}
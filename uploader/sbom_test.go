@@ -0,0 +1,141 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"testing"
+)
+
+func TestGoBinarySbomArtifact(t *testing.T) {
+	info := &debug.BuildInfo{
+		GoVersion: "go1.20.5",
+		Main:      debug.Module{Path: "example.com/app", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "example.com/lib", Version: "v1.2.3"},
+			{
+				Path:    "example.com/forked",
+				Version: "v0.1.0",
+				Replace: &debug.Module{
+					Path:    "github.com/someone/forked",
+					Version: "v0.1.1",
+				},
+			},
+		},
+	}
+
+	artifact := goBinarySbomArtifact("app", "https://example.com/app", nil, info)
+	if artifact.pkg.VersionInfo != "" ||
+		artifact.pkg.ExternalRefs[0].ReferenceLocator != "pkg:golang/example.com/app" ||
+		artifact.pkg.Comment != "Built with go1.20.5" {
+		t.Fatalf("Unexpected package of Go binary: %+v", artifact.pkg)
+	}
+
+	expectedPurls := []string{
+		"pkg:golang/example.com/lib@v1.2.3",
+		"pkg:golang/github.com/someone/forked@v0.1.1",
+	}
+	if len(artifact.modules) != len(expectedPurls) {
+		t.Fatalf("Unexpected modules of Go binary: %+v", artifact.modules)
+	}
+	for i, module := range artifact.modules {
+		purl := module.ExternalRefs[0].ReferenceLocator
+		if purl != expectedPurls[i] {
+			t.Fatalf("Unexpected purl of module %s: %s", module.Name, purl)
+		}
+	}
+}
+
+func TestSbomMergedAcrossBuildJobs(t *testing.T) {
+	dir := t.TempDir()
+
+	// The test binary itself is the Go binary with the build info
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to find the test binary: %v", err)
+	}
+
+	factory := newSharedTstClientFactory()
+
+	setupTravisCiEnvVars(generateRandomString(16), "master", "v1.2.0",
+		"d1vanov/ciuploadtool", false)
+
+	upload := func(filenames []string) *spdxDocument {
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			filenames,
+			Options{SBOM: true})
+		if err != nil {
+			t.Fatalf("Failed to upload the files: %v", err)
+		}
+
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			if asset.GetName() != sbomName {
+				continue
+			}
+
+			var document spdxDocument
+			content := asset.(TstReleaseAsset).GetContent()
+			err = json.Unmarshal([]byte(content), &document)
+			if err != nil {
+				t.Fatalf("Failed to parse SBOM: %v", err)
+			}
+			return &document
+		}
+
+		t.Fatalf("SBOM is missing within the release")
+		return nil
+	}
+
+	upload(append(writeSampleFiles(t, dir, map[string]string{
+		"readme.txt": "first job",
+	}), executable))
+
+	// Assets deleted from the release are dropped from SBOM
+	for _, asset := range factory.client.releases[0].GetAssets() {
+		if asset.GetName() == "readme.txt" {
+			factory.client.DeleteReleaseAsset(context.Background(), asset.GetID())
+		}
+	}
+
+	document := upload(writeSampleFiles(t, dir, map[string]string{
+		"app.exe": "second job",
+	}))
+
+	artifacts := sbomArtifacts(document)
+	if len(artifacts) != 2 {
+		t.Fatalf("Unexpected artifacts within SBOM: %+v", artifacts)
+	}
+
+	file := artifacts["app.exe"].file
+	if file == nil || file.FileName != "./app.exe" ||
+		artifacts["app.exe"].sha256() != tstSha256("second job") {
+		t.Fatalf("Unexpected SBOM file: %+v", file)
+	}
+
+	binary := artifacts[filepath.Base(executable)]
+	if binary == nil || binary.pkg == nil {
+		t.Fatalf("Test binary isn't described as Go binary: %+v", binary)
+	}
+
+	found := false
+	for _, module := range binary.modules {
+		if module.Name == "github.com/google/go-github" {
+			found = module.VersionInfo == "v17.0.0+incompatible"
+		}
+	}
+	if !found {
+		t.Fatalf("Module of the test binary is missing: %+v", binary.modules)
+	}
+
+	for _, relationship := range document.Relationships {
+		if relationship.SpdxElementID == sbomDocumentID &&
+			relationship.RelationshipType != "DESCRIBES" {
+			t.Fatalf("Unexpected relationship: %+v", relationship)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Scoop manifest, the fields are ordered the way Scoop's own manifests are
+type scoopManifest struct {
+	Version      string                       `json:"version"`
+	Description  string                       `json:"description,omitempty"`
+	Homepage     string                       `json:"homepage,omitempty"`
+	License      string                       `json:"license,omitempty"`
+	URL          string                       `json:"url,omitempty"`
+	Hash         string                       `json:"hash,omitempty"`
+	Architecture map[string]scoopArchitecture `json:"architecture,omitempty"`
+	ExtractDir   string                       `json:"extract_dir,omitempty"`
+	Bin          []string                     `json:"bin,omitempty"`
+}
+
+type scoopArchitecture struct {
+	URL  string `json:"url"`
+	Hash string `json:"hash"`
+}
+
+func validateScoopConfig(config *ScoopConfig) error {
+	if strings.ContainsAny(config.Name, "/\\") {
+		return fmt.Errorf("Invalid Scoop app name %s", config.Name)
+	}
+
+	if (len(config.Pattern) == 0) == (len(config.Architectures) == 0) {
+		return errors.New("Either the pattern or the architectures of " +
+			"the Scoop manifest must be set")
+	}
+
+	patterns := scoopPatterns(config)
+	for architecture, pattern := range patterns {
+		if len(config.Architectures) != 0 && architecture != "64bit" &&
+			architecture != "32bit" && architecture != "arm64" {
+			return fmt.Errorf("Unsupported Scoop architecture %s",
+				architecture)
+		}
+
+		err := validateBracedGlob(pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid pattern of Scoop manifest: %v", err)
+		}
+	}
+
+	return validateRepositoryFileConfig(config.Bucket)
+}
+
+// The single pattern is keyed by the empty architecture
+func scoopPatterns(config *ScoopConfig) map[string]string {
+	if len(config.Pattern) != 0 {
+		return map[string]string{"": config.Pattern}
+	}
+	return config.Architectures
+}
+
+func (uploader *Uploader) updateScoopManifest(ctx context.Context) error {
+	config := uploader.options.Scoop
+	name := config.Name
+	if len(name) == 0 {
+		name = uploader.client.GetRepo()
+	}
+	manifestName := name + ".json"
+
+	assets, err := uploader.packageAssets(
+		ctx, manifestName, scoopPatterns(config))
+	if err != nil || assets == nil {
+		return err
+	}
+
+	manifest := scoopManifest{
+		Version:     strings.TrimPrefix(uploader.info.Tag, "v"),
+		Description: config.Description,
+		Homepage:    config.Homepage,
+		License:     config.License,
+		ExtractDir:  config.ExtractDir,
+		Bin:         config.Bin,
+	}
+
+	for architecture, asset := range assets {
+		if len(architecture) == 0 {
+			manifest.URL = asset.url
+			manifest.Hash = asset.sha256
+			continue
+		}
+
+		if manifest.Architecture == nil {
+			manifest.Architecture = make(map[string]scoopArchitecture)
+		}
+		manifest.Architecture[architecture] = scoopArchitecture{
+			URL:  asset.url,
+			Hash: asset.sha256,
+		}
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return uploader.publishPackageManifest(ctx, manifestName,
+		"application/json", append(content, '\n'), config.Bucket,
+		"bucket/"+manifestName)
+}
@@ -0,0 +1,112 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Reads the ed25519 private key from the environment variable. The key is
+// either base64 encoded 32 byte seed as exported by Sparkle's generate_keys
+// tool, base64 encoded 64 byte private key or PEM encoded PKCS #8 key.
+func loadEd25519PrivateKey(envVar string) (ed25519.PrivateKey, error) {
+	value := strings.TrimSpace(os.Getenv(envVar))
+	if len(value) == 0 {
+		return nil, fmt.Errorf("Environment variable %s with ed25519 "+
+			"private key is not set", envVar)
+	}
+
+	key, err := parseEd25519PrivateKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ed25519 private key within "+
+			"environment variable %s: %v", envVar, err)
+	}
+	return key, nil
+}
+
+func parseEd25519PrivateKey(text string) (ed25519.PrivateKey, error) {
+	if strings.HasPrefix(text, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(text))
+		if block == nil {
+			return nil, errors.New("malformed PEM block")
+		}
+
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		ed25519Key, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an ed25519 key")
+		}
+		return ed25519Key, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(data) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	case ed25519.PrivateKeySize:
+		// The public half of the key must correspond to the seed
+		key := ed25519.NewKeyFromSeed(data[:ed25519.SeedSize])
+		if !bytes.Equal(key, data) {
+			return nil, errors.New("the public key doesn't match the seed")
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unexpected key length %d", len(data))
+}
+
+// Parses the ed25519 public key, either base64 encoded 32 byte key or PEM
+// encoded PKIX key as printed by "openssl pkey -pubout"
+func parseEd25519PublicKey(text string) (ed25519.PublicKey, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(text))
+		if block == nil {
+			return nil, errors.New("malformed PEM block")
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		ed25519Key, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("not an ed25519 key")
+		}
+		return ed25519Key, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("unexpected key length %d", len(data))
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// Identifies the key the signature was made with as the hex encoded SHA-256
+// of the public key
+func ed25519KeyID(key ed25519.PublicKey) string {
+	digest := sha256.Sum256(key)
+	return hex.EncodeToString(digest[:])
+}
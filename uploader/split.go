@@ -0,0 +1,285 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GitHub rejects release assets of 2 GiB and larger
+const maxReleaseAssetSize = 2 << 30
+
+// Suffix of the manifest listing the checksums of the parts the file was
+// split into and of the whole file
+const splitManifestSuffix = ".parts.sha256"
+
+// Name of the release body section with the instructions for reassembling
+// the split files
+const splitPartsSection = "split-parts"
+
+// ParseSize parses the size with optional K, M or G binary suffix,
+// i.e. "1900M"
+func ParseSize(size string) (int64, error) {
+	text := strings.TrimSuffix(strings.TrimSuffix(
+		strings.ToUpper(strings.TrimSpace(size)), "B"), "I")
+
+	multiplier := int64(1)
+	if len(text) != 0 {
+		switch text[len(text)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			text = text[:len(text)-1]
+		}
+	}
+
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("Invalid size %s", size)
+	}
+	return value * multiplier, nil
+}
+
+func splitPartName(name string, index int, count int) string {
+	width := len(strconv.Itoa(count))
+	if width < 3 {
+		width = 3
+	}
+	return fmt.Sprintf("%s.part%0*d", name, width, index)
+}
+
+func isSplitArtifactOf(assetName string, name string) bool {
+	if assetName == name+splitManifestSuffix {
+		return true
+	}
+
+	index := strings.TrimPrefix(assetName, name+".part")
+	if len(index) == len(assetName) || len(index) == 0 {
+		return false
+	}
+
+	for _, digit := range index {
+		if digit < '0' || digit > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Replaces the sources larger than the part size with their parts and
+// the manifests of the parts
+func splitAssetSources(
+	sources []*assetSource,
+	partSize int64) ([]*assetSource, error) {
+
+	var splitSources []*assetSource
+	for _, source := range sources {
+		if partSize <= 0 || source.size <= partSize {
+			splitSources = append(splitSources, source)
+			continue
+		}
+
+		parts, err := splitAssetSource(source, partSize)
+		if err != nil {
+			return nil, err
+		}
+		splitSources = append(splitSources, parts...)
+	}
+
+	for _, source := range splitSources {
+		if source.size >= maxReleaseAssetSize {
+			return nil, fmt.Errorf("%s is too large for GitHub release asset, "+
+				"split it into parts with smaller split size", source.filename)
+		}
+	}
+
+	return splitSources, nil
+}
+
+// Computes the checksums of the parts and of the whole content in one pass
+// and returns the sources of the parts followed by the source of
+// the manifest
+func splitAssetSource(
+	source *assetSource,
+	partSize int64) ([]*assetSource, error) {
+
+	content, err := source.open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	count := int((source.size + partSize - 1) / partSize)
+	wholeHash := sha256.New()
+	var manifest bytes.Buffer
+	var parts []*assetSource
+	for i := 0; i < count; i++ {
+		offset := int64(i) * partSize
+		size := source.size - offset
+		if size > partSize {
+			size = partSize
+		}
+
+		partHash := sha256.New()
+		_, err = io.CopyN(io.MultiWriter(wholeHash, partHash), content, size)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %v", source.filename, err)
+		}
+
+		name := splitPartName(source.name, i+1, count)
+		fmt.Fprintf(&manifest, "%s  %s\n",
+			hex.EncodeToString(partHash.Sum(nil)), name)
+		parts = append(parts, partAssetSource(source, name, i+1, offset, size))
+	}
+
+	fmt.Fprintf(&manifest, "%s  %s\n",
+		hex.EncodeToString(wholeHash.Sum(nil)), source.name)
+
+	manifestSource := memoryAssetSource(
+		source.filename, source.name+splitManifestSuffix, manifest.Bytes())
+	manifestSource.contentType = "text/plain"
+	manifestSource.splitFrom = source.name
+	manifestSource.namePattern = source.namePattern
+
+	return append(parts, manifestSource), nil
+}
+
+type partReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func partAssetSource(
+	source *assetSource,
+	name string,
+	index int,
+	offset int64,
+	size int64) *assetSource {
+
+	return &assetSource{
+		filename:    source.filename,
+		name:        name,
+		contentType: "application/octet-stream",
+		size:        size,
+		splitFrom:   source.name,
+		partIndex:   index,
+		namePattern: source.namePattern,
+		open: func() (io.ReadCloser, error) {
+			content, err := source.open()
+			if err != nil {
+				return nil, err
+			}
+
+			seeker, ok := content.(io.Seeker)
+			if ok {
+				_, err = seeker.Seek(offset, io.SeekStart)
+			} else {
+				_, err = io.CopyN(ioutil.Discard, content, offset)
+			}
+			if err != nil {
+				content.Close()
+				return nil, err
+			}
+
+			return partReadCloser{io.LimitReader(content, size), content}, nil
+		},
+	}
+}
+
+// Lists the split files within the release body section along with
+// the instructions for reassembling them, empty if no files are split
+func splitPartsSectionContent(names map[string]bool) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	content := "The following binaries are split into parts to stay under " +
+		"GitHub's release asset size limit:\n\n"
+	for _, name := range sortedNames {
+		content += "* `" + name + "`\n"
+	}
+	content += "\nDownload all the parts of the binary along with its " +
+		"`.parts.sha256` manifest and reassemble it with\n\n" +
+		"    cat <name>.part* > <name> && sha256sum -c <name>.parts.sha256\n\n" +
+		"or on Windows with `copy /b <name>.part001+<name>.part002+... <name>`."
+	return content
+}
+
+func splitPartsSectionNames(content string) []string {
+	var names []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "* `") && strings.HasSuffix(line, "`") {
+			names = append(names, strings.TrimSuffix(
+				strings.TrimPrefix(line, "* `"), "`"))
+		}
+	}
+	return names
+}
+
+// Lists the files split by the current build job within the release body
+// along with the files other build jobs have split. The listed files whose
+// parts are gone from the release, i.e. the files uploaded whole since then,
+// are dropped from the list.
+func (uploader *Uploader) updateSplitPartsSection(
+	ctx context.Context,
+	names []string) error {
+
+	_, ok := releaseBodySection(uploader.release.GetBody(), splitPartsSection)
+	if !ok && len(names) == 0 {
+		return nil
+	}
+
+	return uploader.updateRelease(ctx, "list split binaries within "+
+		"the release body", func(release Release) error {
+		assets, response, err := uploader.client.ListReleaseAssets(
+			ctx, release.GetID())
+		response.CloseBody()
+		if err == nil {
+			err = response.Check()
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to list release assets to find "+
+				"split binaries: %v", err)
+		}
+
+		splitNames := make(map[string]bool)
+		existingContent, _ := releaseBodySection(
+			release.GetBody(), splitPartsSection)
+		for _, name := range splitPartsSectionNames(existingContent) {
+			for _, asset := range assets {
+				if asset.GetID() != 0 &&
+					isSplitArtifactOf(asset.GetName(), name) {
+					splitNames[name] = true
+					break
+				}
+			}
+		}
+		for _, name := range names {
+			splitNames[name] = true
+		}
+
+		release.SetBody(setReleaseBodySection(
+			release.GetBody(),
+			splitPartsSection,
+			splitPartsSectionContent(splitNames)))
+		return nil
+	})
+}
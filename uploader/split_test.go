@@ -0,0 +1,199 @@
+package uploader
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		size     string
+		expected int64
+	}{
+		{"1024", 1024},
+		{"64K", 64 << 10},
+		{"1900M", 1900 << 20},
+		{"1900MiB", 1900 << 20},
+		{"1g", 1 << 30},
+	}
+
+	for _, testCase := range testCases {
+		size, err := ParseSize(testCase.size)
+		if err != nil || size != testCase.expected {
+			t.Fatalf("Unexpected result of parsing size %s: %d, %v",
+				testCase.size, size, err)
+		}
+	}
+
+	for _, size := range []string{"", "M", "-5M", "1.5G", "10T"} {
+		_, err := ParseSize(size)
+		if err == nil {
+			t.Fatalf("Invalid size %s was unexpectedly accepted", size)
+		}
+	}
+}
+
+func TestUploadOfSplitFileReplacesStaleParts(t *testing.T) {
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "vm.img")
+
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+
+	// All the uploads go to the same release
+	factory := newSharedTstClientFactory()
+
+	testCases := []struct {
+		content        string
+		splitSize      int64
+		expectedAssets map[string]string
+	}{
+		{"0123456789", 4, map[string]string{
+			"vm.img.part001": "0123",
+			"vm.img.part002": "4567",
+			"vm.img.part003": "89",
+			"vm.img.parts.sha256": tstSha256("0123") + "  vm.img.part001\n" +
+				tstSha256("4567") + "  vm.img.part002\n" +
+				tstSha256("89") + "  vm.img.part003\n" +
+				tstSha256("0123456789") + "  vm.img\n",
+		}},
+		{"abcdef", 4, map[string]string{
+			"vm.img.part001": "abcd",
+			"vm.img.part002": "ef",
+			"vm.img.parts.sha256": tstSha256("abcd") + "  vm.img.part001\n" +
+				tstSha256("ef") + "  vm.img.part002\n" +
+				tstSha256("abcdef") + "  vm.img\n",
+		}},
+		{"xyz", 0, map[string]string{
+			"vm.img": "xyz",
+		}},
+	}
+
+	for _, testCase := range testCases {
+		err := ioutil.WriteFile(filename, []byte(testCase.content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write the sample file: %v", err)
+		}
+
+		_, err = uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{filename},
+			Options{
+				ReleaseSuffix: "master",
+				SplitSize:     testCase.splitSize,
+				Verify:        true,
+			})
+		if err != nil {
+			t.Fatalf("Failed to upload the file: %v", err)
+		}
+
+		if len(factory.client.releases) != 1 {
+			t.Fatalf("Detected wrong number of releases within client: "+
+				"want 1, have %d", len(factory.client.releases))
+		}
+
+		var names []string
+		for _, asset := range factory.client.releases[0].GetAssets() {
+			tstAsset := asset.(TstReleaseAsset)
+			names = append(names, tstAsset.GetName())
+
+			expectedContent, ok := testCase.expectedAssets[tstAsset.GetName()]
+			if !ok {
+				t.Fatalf("Unexpected release asset %s", tstAsset.GetName())
+			}
+
+			if tstAsset.GetContent() != expectedContent {
+				t.Fatalf("Unexpected content of release asset %s: %s",
+					tstAsset.GetName(), tstAsset.GetContent())
+			}
+		}
+		sort.Strings(names)
+
+		if len(names) != len(testCase.expectedAssets) {
+			t.Fatalf("Unexpected release assets: %v", names)
+		}
+
+		// The binary uploaded whole is no longer listed as split
+		body := factory.client.releases[0].GetBody()
+		section, ok := releaseBodySection(body, splitPartsSection)
+		if testCase.splitSize != 0 &&
+			(!ok || !strings.Contains(section, "* `vm.img`")) {
+			t.Fatalf("The split binary is not listed within the release "+
+				"body: %s", body)
+		}
+		if testCase.splitSize == 0 && ok {
+			t.Fatalf("The binary uploaded whole is listed as split within "+
+				"the release body: %s", body)
+		}
+	}
+}
+
+func TestSplitBinariesOfOtherBuildJobsStayListed(t *testing.T) {
+	dir := t.TempDir()
+
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+
+	factory := newSharedTstClientFactory()
+	upload := func(name string, content string, splitSize int64) {
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, map[string]string{name: content}),
+			Options{ReleaseSuffix: "master", SplitSize: splitSize})
+		if err != nil {
+			t.Fatalf("Failed to upload %s: %v", name, err)
+		}
+	}
+
+	upload("linux.img", "0123456789", 4)
+	upload("windows.img", "0123456789", 4)
+
+	// The re-run of the first job no longer splits its binary
+	upload("linux.img", "012", 4)
+
+	body := factory.client.releases[0].GetBody()
+	section, _ := releaseBodySection(body, splitPartsSection)
+	names := splitPartsSectionNames(section)
+	if len(names) != 1 || names[0] != "windows.img" {
+		t.Fatalf("Unexpected split binaries listed within the release "+
+			"body: %v", names)
+	}
+}
+
+func TestReleaseBodySections(t *testing.T) {
+	body := "Continuous build\n"
+
+	body = setReleaseBodySection(body, "first", "First content")
+	body = setReleaseBodySection(body, "second", "Second content")
+	body = setReleaseBodySection(body, "first", "Updated content")
+
+	content, ok := releaseBodySection(body, "first")
+	if !ok || content != "Updated content" {
+		t.Fatalf("Unexpected content of the section: %s", content)
+	}
+
+	if !strings.HasPrefix(body, "Continuous build\n") ||
+		strings.Count(body, "Updated content") != 1 {
+		t.Fatalf("Unexpected body: %s", body)
+	}
+
+	body = setReleaseBodySection(body, "first", "")
+	_, ok = releaseBodySection(body, "first")
+	if ok {
+		t.Fatalf("The removed section is still within the body: %s", body)
+	}
+
+	content, ok = releaseBodySection(body, "second")
+	if !ok || content != "Second content" {
+		t.Fatalf("The other section was damaged: %s", body)
+	}
+}
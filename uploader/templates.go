@@ -0,0 +1,25 @@
+package uploader
+
+import (
+	"bytes"
+	"text/template"
+)
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("").Option("missingkey=error").Parse(text)
+}
+
+func executeTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"testing"
 )
 
 var lastFreeReleaseAssetId int64
@@ -25,6 +28,19 @@ type TstClient struct {
 	repo     string
 	releases []TstRelease
 	tagNames []string
+
+	// Number of next uploads which would store truncated asset contents
+	corruptUploads int
+
+	// Files committed into other repositories by owner/repo/branch/path
+	repositoryFiles map[string]string
+
+	// Callbacks invoked once before the next call of the named client
+	// method, used to interleave several build jobs sharing the client
+	hooks map[string]func()
+
+	// Serializes the calls of build jobs running concurrently
+	mutex sync.Mutex
 }
 
 type TstResponse struct {
@@ -45,31 +61,84 @@ type TstRelease struct {
 }
 
 type TstReleaseAsset struct {
-	id      int64
-	name    string
-	content string
+	id          int64
+	name        string
+	content     string
+	contentType string
+	label       string
 }
 
 func newTstClient(gitHubToken string, owner string, repo string) Client {
 	return &TstClient{token: gitHubToken, owner: owner, repo: repo}
 }
 
-func newTstRelease(releaseBody string, info *buildEventInfo, verbose bool) Release {
+func newTstRelease(releaseBody string, info *BuildInfo, verbose bool) Release {
 	release := TstRelease{
 		id:              lastFreeReleaseId,
-		name:            info.releaseTitle,
+		name:            info.ReleaseTitle,
 		body:            releaseBody,
-		tagName:         info.tag,
-		targetCommitish: info.commit,
+		tagName:         info.Tag,
+		targetCommitish: info.Commit,
 		isDraft:         false,
-		isPrerelease:    info.isPrerelease,
+		isPrerelease:    info.IsPrerelease,
 	}
 	lastFreeReleaseId++
-	return updateBuildLogWithinReleaseBody(&release, info, verbose)
+	return updateBuildLogWithinReleaseBody(&release, info)
+}
+
+// Factory of the single client shared by several build jobs of the test
+// uploading to the same release
+type sharedTstClientFactory struct {
+	// The client, nil until the first build job creates it
+	client *TstClient
+
+	// Optional callback populating the client once it is created
+	setup func(client *TstClient)
+}
+
+func newSharedTstClientFactory() *sharedTstClientFactory {
+	return &sharedTstClientFactory{}
+}
+
+func (factory *sharedTstClientFactory) create(gitHubToken string, owner string, repo string) Client {
+	if factory.client == nil {
+		factory.client = newTstClient(gitHubToken, owner, repo).(*TstClient)
+		if factory.setup != nil {
+			factory.setup(factory.client)
+		}
+	}
+	return factory.client
+}
+
+func writeSampleFiles(
+	t *testing.T,
+	dir string,
+	files map[string]string) []string {
+
+	var filenames []string
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		err := ioutil.WriteFile(filename, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write the sample file: %v", err)
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames
 }
 
-func (client TstClient) GetContext() context.Context {
-	return context.Background()
+func (client *TstClient) runHook(method string) {
+	client.mutex.Lock()
+	hook, ok := client.hooks[method]
+	if ok {
+		delete(client.hooks, method)
+	}
+	client.mutex.Unlock()
+
+	// The hook runs unlocked so it can let other build jobs call the client
+	if ok {
+		hook()
+	}
 }
 
 func (client *TstClient) GetOwner() string {
@@ -80,22 +149,29 @@ func (client *TstClient) GetRepo() string {
 	return client.repo
 }
 
-func (client *TstClient) GetReleaseByTag(tagName string) (Release, Response, error) {
+func (client *TstClient) GetReleaseByTag(ctx context.Context, tagName string) (Release, Response, error) {
+	client.runHook("GetReleaseByTag")
 	if len(client.releases) == 0 {
 		return nil, TstResponse{statusCode: 404, status: "Not found"}, errors.New("No releases within the test client")
 	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if len(client.token) == 0 {
 		return nil, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
 	}
 	for _, release := range client.releases {
 		if release.tagName == tagName {
+			release.assets = append([]TstReleaseAsset(nil), release.assets...)
 			return &release, TstResponse{statusCode: 200}, nil
 		}
 	}
 	return nil, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release matching tag name was not found")
 }
 
-func (client *TstClient) CreateRelease(release Release) (Release, Response, error) {
+func (client *TstClient) CreateRelease(ctx context.Context, release Release) (Release, Response, error) {
+	client.runHook("CreateRelease")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if len(client.token) == 0 {
 		return nil, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
 	}
@@ -108,18 +184,31 @@ func (client *TstClient) CreateRelease(release Release) (Release, Response, erro
 		return nil, TstResponse{statusCode: 400, status: "Missing release name"}, errors.New("The release to be created has no name")
 	}
 
+	for _, existingRelease := range client.releases {
+		if existingRelease.tagName == tagName {
+			return nil, TstResponse{statusCode: 422, status: "Validation failed"}, errors.New("Release with the given tag name already exists")
+		}
+	}
+
 	tstRelease := release.(*TstRelease)
 	tstRelease.id = lastFreeReleaseId
 	lastFreeReleaseId++
 	client.releases = append(client.releases, *tstRelease)
 
-	client.DeleteTag(tagName)
+	for i, clientTagName := range client.tagNames {
+		if clientTagName == tagName {
+			client.tagNames = append(client.tagNames[:i], client.tagNames[i+1:]...)
+			break
+		}
+	}
 	client.tagNames = append(client.tagNames, tagName)
 
 	return tstRelease, TstResponse{statusCode: 200, status: "Created"}, nil
 }
 
-func (client *TstClient) UpdateRelease(release Release) (Release, Response, error) {
+func (client *TstClient) UpdateRelease(ctx context.Context, release Release) (Release, Response, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if len(client.token) == 0 {
 		return nil, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
 	}
@@ -127,18 +216,24 @@ func (client *TstClient) UpdateRelease(release Release) (Release, Response, erro
 		if client.releases[i].GetID() != release.GetID() {
 			continue
 		}
+		// Release assets are not affected by release updates
+		assets := client.releases[i].assets
 		client.releases[i] = *(release.(*TstRelease))
+		client.releases[i].assets = assets
 		return release, TstResponse{statusCode: 200, status: "Updated"}, nil
 	}
 	return nil, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release matching by ID was not found")
 }
 
-func (client *TstClient) DeleteRelease(releaseId int64) (Response, error) {
+func (client *TstClient) DeleteRelease(ctx context.Context, releaseId int64) (Response, error) {
+	client.runHook("DeleteRelease")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if len(client.token) == 0 {
 		return TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
 	}
 	if len(client.releases) == 0 {
-		return TstResponse{statusCode: 204, status: "No content"}, errors.New("No releases within client")
+		return TstResponse{statusCode: 404, status: "Not found"}, errors.New("No releases within client")
 	}
 	for i, release := range client.releases {
 		if release.GetID() == releaseId {
@@ -146,10 +241,13 @@ func (client *TstClient) DeleteRelease(releaseId int64) (Response, error) {
 			return TstResponse{statusCode: 200, status: "Deleted"}, nil
 		}
 	}
-	return TstResponse{statusCode: 204, status: "No content"}, errors.New("No such release found")
+	return TstResponse{statusCode: 404, status: "Not found"}, errors.New("No such release found")
 }
 
-func (client *TstClient) DeleteTag(tagName string) (Response, error) {
+func (client *TstClient) DeleteTag(ctx context.Context, tagName string) (Response, error) {
+	client.runHook("DeleteTag")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if len(client.token) == 0 {
 		return TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
 	}
@@ -165,7 +263,30 @@ func (client *TstClient) DeleteTag(tagName string) (Response, error) {
 	return TstResponse{statusCode: 404, status: "Not found"}, errors.New("Found no tag to delete")
 }
 
-func (client *TstClient) ListReleaseAssets(releaseId int64) ([]ReleaseAsset, Response, error) {
+func (client *TstClient) UpdateTagRef(ctx context.Context, tagName string, commit string) (Response, error) {
+	client.runHook("UpdateTagRef")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.token) == 0 {
+		return TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
+	}
+	for _, clientTagName := range client.tagNames {
+		if clientTagName != tagName {
+			continue
+		}
+		for i := range client.releases {
+			if client.releases[i].tagName == tagName {
+				client.releases[i].targetCommitish = commit
+			}
+		}
+		return TstResponse{statusCode: 200, status: "Updated"}, nil
+	}
+	return TstResponse{statusCode: 422, status: "Reference does not exist"}, errors.New("Found no tag to update")
+}
+
+func (client *TstClient) ListReleaseAssets(ctx context.Context, releaseId int64) ([]ReleaseAsset, Response, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if len(client.token) == 0 {
 		return nil, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
 	}
@@ -180,7 +301,9 @@ func (client *TstClient) ListReleaseAssets(releaseId int64) ([]ReleaseAsset, Res
 	return nil, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release with given id was not found")
 }
 
-func (client *TstClient) DeleteReleaseAsset(assetId int64) (Response, error) {
+func (client *TstClient) DeleteReleaseAsset(ctx context.Context, assetId int64) (Response, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if len(client.token) == 0 {
 		return TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
 	}
@@ -198,7 +321,11 @@ func (client *TstClient) DeleteReleaseAsset(assetId int64) (Response, error) {
 	return TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release containing the asset with given id was not found")
 }
 
-func (client *TstClient) UploadReleaseAsset(releaseId int64, assetName string, assetFile *os.File) (ReleaseAsset, Response, error) {
+func (client *TstClient) UploadReleaseAsset(ctx context.Context, releaseId int64, upload AssetUpload) (ReleaseAsset, Response, error) {
+	assetName := upload.Name
+	client.runHook("UploadReleaseAsset")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if len(client.token) == 0 {
 		return TstReleaseAsset{}, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
 	}
@@ -209,16 +336,33 @@ func (client *TstClient) UploadReleaseAsset(releaseId int64, assetName string, a
 		if release.GetID() == releaseId {
 			for _, asset := range release.GetAssets() {
 				if asset.GetName() == assetName {
-					return TstReleaseAsset{}, TstResponse{statusCode: 400, status: "Release asset already exists"},
+					return TstReleaseAsset{}, TstResponse{statusCode: 422, status: "Validation failed"},
 						errors.New("Release asset with the given name already exists")
 				}
 			}
-			assetFileContent, err := ioutil.ReadAll(assetFile)
+			assetFileContent, err := ioutil.ReadAll(upload.Content)
 			if err != nil {
 				return TstReleaseAsset{}, TstResponse{statusCode: 400, status: "Failed to read the asset file's contents"},
 					fmt.Errorf("Failed to read the asset file's contents: %v", err)
 			}
-			asset := TstReleaseAsset{id: lastFreeReleaseAssetId, name: assetName, content: string(assetFileContent)}
+			if int64(len(assetFileContent)) != upload.Size {
+				return TstReleaseAsset{}, TstResponse{statusCode: 400, status: "Content length mismatch"},
+					fmt.Errorf("Content length mismatch: expected %d, got %d", upload.Size, len(assetFileContent))
+			}
+			if client.corruptUploads > 0 {
+				client.corruptUploads--
+				assetFileContent = assetFileContent[:len(assetFileContent)/2]
+			}
+			if ctx.Err() != nil {
+				// Interrupted upload leaves the partially uploaded asset behind
+				asset := TstReleaseAsset{id: lastFreeReleaseAssetId, name: assetName, content: string(assetFileContent[:len(assetFileContent)/2])}
+				lastFreeReleaseAssetId++
+				release.assets = append(release.assets, asset)
+				client.releases[i] = release
+				return TstReleaseAsset{}, TstResponse{}, ctx.Err()
+			}
+			asset := TstReleaseAsset{id: lastFreeReleaseAssetId, name: assetName, content: string(assetFileContent),
+				contentType: assetContentType(upload), label: upload.Label}
 			lastFreeReleaseAssetId++
 			release.assets = append(release.assets, asset)
 			client.releases[i] = release
@@ -228,6 +372,123 @@ func (client *TstClient) UploadReleaseAsset(releaseId int64, assetName string, a
 	return TstReleaseAsset{}, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release with given id was not found")
 }
 
+func (client *TstClient) DownloadReleaseAsset(ctx context.Context, assetId int64) (io.ReadCloser, Response, error) {
+	client.runHook("DownloadReleaseAsset")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.token) == 0 {
+		return nil, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
+	}
+	for _, release := range client.releases {
+		for _, asset := range release.assets {
+			if asset.GetID() == assetId {
+				return ioutil.NopCloser(strings.NewReader(asset.content)), TstResponse{statusCode: 200, status: "OK"}, nil
+			}
+		}
+	}
+	return nil, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release asset with given id was not found")
+}
+
+func (client *TstClient) UpdateReleaseAssetLabel(ctx context.Context, assetId int64, label string) (ReleaseAsset, Response, error) {
+	client.runHook("UpdateReleaseAssetLabel")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.token) == 0 {
+		return TstReleaseAsset{}, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
+	}
+	for i := range client.releases {
+		for j, asset := range client.releases[i].assets {
+			if asset.GetID() == assetId {
+				client.releases[i].assets[j].label = label
+				return client.releases[i].assets[j], TstResponse{statusCode: 200, status: "OK"}, nil
+			}
+		}
+	}
+	return TstReleaseAsset{}, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release asset with given id was not found")
+}
+
+func (client *TstClient) RenameReleaseAsset(ctx context.Context, assetId int64, name string) (ReleaseAsset, Response, error) {
+	client.runHook("RenameReleaseAsset")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.token) == 0 {
+		return TstReleaseAsset{}, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
+	}
+	for i := range client.releases {
+		for j, asset := range client.releases[i].assets {
+			if asset.GetID() != assetId {
+				continue
+			}
+			for _, other := range client.releases[i].assets {
+				if other.GetName() == name {
+					return TstReleaseAsset{}, TstResponse{statusCode: 422, status: "Validation failed"},
+						errors.New("Release asset with the given name already exists")
+				}
+			}
+			client.releases[i].assets[j].name = name
+			return client.releases[i].assets[j], TstResponse{statusCode: 200, status: "OK"}, nil
+		}
+	}
+	return TstReleaseAsset{}, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release asset with given id was not found")
+}
+
+// Hides the optional interfaces of the wrapped client, e.g. to exercise
+// copying of release assets by download and upload
+type tstPlainClient struct {
+	Client
+}
+
+func (client *TstClient) CopyReleaseAsset(ctx context.Context, assetId int64, releaseId int64) (ReleaseAsset, Response, error) {
+	client.runHook("CopyReleaseAsset")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.token) == 0 {
+		return TstReleaseAsset{}, TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
+	}
+	var source *TstReleaseAsset
+	for i := range client.releases {
+		for j, asset := range client.releases[i].assets {
+			if asset.GetID() == assetId {
+				source = &client.releases[i].assets[j]
+			}
+		}
+	}
+	if source == nil {
+		return TstReleaseAsset{}, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release asset with given id was not found")
+	}
+	for i, release := range client.releases {
+		if release.GetID() != releaseId {
+			continue
+		}
+		for _, asset := range release.assets {
+			if asset.GetName() == source.GetName() {
+				return TstReleaseAsset{}, TstResponse{statusCode: 422, status: "Validation failed"},
+					errors.New("Release asset with the given name already exists")
+			}
+		}
+		asset := *source
+		asset.id = lastFreeReleaseAssetId
+		lastFreeReleaseAssetId++
+		client.releases[i].assets = append(client.releases[i].assets, asset)
+		return asset, TstResponse{statusCode: 200, status: "Copied"}, nil
+	}
+	return TstReleaseAsset{}, TstResponse{statusCode: 404, status: "Not found"}, errors.New("Release with given id was not found")
+}
+
+func (client *TstClient) UpdateRepositoryFile(ctx context.Context, file RepositoryFile) (Response, error) {
+	client.runHook("UpdateRepositoryFile")
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.token) == 0 {
+		return TstResponse{statusCode: 401, status: "Bad credentials"}, errors.New("No GitHub token")
+	}
+	if client.repositoryFiles == nil {
+		client.repositoryFiles = make(map[string]string)
+	}
+	client.repositoryFiles[file.Owner+"/"+file.Repo+"/"+file.Branch+"/"+file.Path] = string(file.Content)
+	return TstResponse{statusCode: 200, status: "OK"}, nil
+}
+
 func (response TstResponse) Check() error {
 	if response.GetStatusCode() < 200 || response.GetStatusCode() > 299 {
 		return fmt.Errorf("Bad status code %d: %s\n", response.GetStatusCode(), response.GetStatus())
@@ -274,10 +535,18 @@ func (release *TstRelease) GetTargetCommitish() string {
 	return release.targetCommitish
 }
 
+func (release *TstRelease) SetTargetCommitish(commitish string) {
+	release.targetCommitish = commitish
+}
+
 func (release *TstRelease) GetDraft() bool {
 	return release.isDraft
 }
 
+func (release *TstRelease) SetDraft(draft bool) {
+	release.isDraft = draft
+}
+
 func (release *TstRelease) GetPrerelease() bool {
 	return release.isPrerelease
 }
@@ -298,6 +567,18 @@ func (releaseAsset TstReleaseAsset) GetName() string {
 	return releaseAsset.name
 }
 
+func (releaseAsset TstReleaseAsset) GetSize() int64 {
+	return int64(len(releaseAsset.content))
+}
+
+func (releaseAsset TstReleaseAsset) GetLabel() string {
+	return releaseAsset.label
+}
+
+func (releaseAsset TstReleaseAsset) GetContentType() string {
+	return releaseAsset.contentType
+}
+
 func (releaseAsset TstReleaseAsset) GetContent() string {
 	return releaseAsset.content
 }
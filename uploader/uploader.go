@@ -2,223 +2,1353 @@ package uploader
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 )
 
 type clientFactoryFunc func(
 	gitHubToken string, owner string, repo string) Client
 
-type releaseFactoryFunc func(
+// ReleaseFactory creates the release to be passed to Client.CreateRelease
+type ReleaseFactory func(
 	releaseBody string,
-	info *buildEventInfo,
+	info *BuildInfo,
 	verbose bool) Release
 
-func Upload(
+// Number of attempts to upload the release asset when the uploaded asset
+// fails the verification
+const maxUploadVerificationAttempts = 3
+
+// Number of attempts to look up the release created by another build job
+// concurrently with the current one and the delay between the attempts
+const maxConcurrentReleaseLookupAttempts = 3
+
+var concurrentReleaseLookupDelay = 2 * time.Second
+
+// Time given to the cleanup of partially uploaded release asset after
+// the upload was cancelled or timed out
+var partialReleaseAssetCleanupTimeout = 30 * time.Second
+
+// Uploader uploads binaries to the GitHub release corresponding to the build
+type Uploader struct {
+	options        Options
+	logger         Logger
+	clientFactory  clientFactoryFunc
+	releaseFactory ReleaseFactory
+	stdin          io.Reader
+	globFilter     *globFilter
+	tmpDir         string
+
+	info                  *BuildInfo
+	client                Client
+	release               Release
+	existingReleaseAssets []ReleaseAsset
+	assetDigests          map[string]string
+	deltaSources          []*assetSource
+	previousAssets        map[string]previousAsset
+	prepared              bool
+	result                Result
+
+	// The draft release was published by this run, the assets deferred
+	// until then are generated from all the release assets
+	published bool
+}
+
+// New validates the options and returns the Uploader. Unless the options
+// contain the build info, it's collected from the CI environment later on,
+// when the release is prepared.
+func New(options Options) (*Uploader, error) {
+	if options.Client != nil && options.ReleaseFactory == nil {
+		_, isGitHubClient := options.Client.(GitHubClient)
+		if !isGitHubClient {
+			return nil, errors.New(
+				"Release factory is required for the custom client")
+		}
+	}
+
+	for _, text := range []string{
+		options.ReleaseTitleTemplate,
+		options.ReleaseBodyTemplate,
+		options.NameTemplate} {
+
+		_, err := parseTemplate(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filter, err := newGlobFilter(options.Include, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.Archive) != 0 {
+		if !isValidArchiveFormat(options.Archive) {
+			return nil, fmt.Errorf("Unsupported archive format %s",
+				options.Archive)
+		}
+
+		if options.Recursive {
+			return nil, errors.New(
+				"The dirs can't be both archived and uploaded recursively")
+		}
+	}
+
+	if len(options.JobNamespace) != 0 {
+		if !isValidJobNamespace(options.JobNamespace) {
+			return nil, fmt.Errorf("Unsupported job namespace %s",
+				options.JobNamespace)
+		}
+
+		if len(options.NameTemplate) != 0 {
+			return nil, errors.New("The job namespace can't be combined " +
+				"with the name template, use {{.JobKey}} within the name " +
+				"template instead")
+		}
+	}
+
+	if strings.ContainsAny(options.JobKey, "/\\`") {
+		return nil, fmt.Errorf("Invalid job key %s", options.JobKey)
+	}
+
+	if strings.ContainsAny(options.AssetNameSeparator, "/\\") {
+		return nil, fmt.Errorf("Invalid release asset name separator %s",
+			options.AssetNameSeparator)
+	}
+
+	if options.UpdateFeed != nil {
+		err = validateUpdateFeedConfig(options.UpdateFeed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Appcast != nil {
+		err = validateAppcastConfig(options.Appcast)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Scoop != nil {
+		err = validateScoopConfig(options.Scoop)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Homebrew != nil {
+		err = validateHomebrewConfig(options.Homebrew)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = validateAssetConfigs(options.Assets)
+	if err != nil {
+		return nil, err
+	}
+
+	uploader := Uploader{
+		options:        options,
+		logger:         options.Logger,
+		clientFactory:  clientFactoryFunc(newGitHubClient),
+		releaseFactory: options.ReleaseFactory,
+		stdin:          os.Stdin,
+		globFilter:     filter,
+		assetDigests:   make(map[string]string),
+		previousAssets: make(map[string]previousAsset),
+	}
+
+	if uploader.logger == nil {
+		uploader.logger = stdoutLogger{}
+	}
+
+	if uploader.releaseFactory == nil {
+		uploader.releaseFactory = newGitHubRelease
+	}
+
+	return &uploader, nil
+}
+
+// Upload uploads the files to the GitHub release corresponding to the build
+// using the client made from the GitHub token of the build
+func Upload(ctx context.Context, filenames []string, options Options) error {
+	_, err := uploadImpl(
+		ctx,
+		clientFactoryFunc(newGitHubClient),
+		newGitHubRelease,
+		filenames,
+		options)
+	return err
+}
+
+// Finalize publishes the draft GitHub release corresponding to the build
+// using the client made from the GitHub token of the build
+func Finalize(ctx context.Context, options Options) error {
+	_, err := finalizeImpl(ctx, clientFactoryFunc(newGitHubClient), options)
+	return err
+}
+
+func uploadImpl(
+	ctx context.Context,
+	clientFactory clientFactoryFunc,
+	releaseFactory ReleaseFactory,
 	filenames []string,
-	releaseSuffix string,
-	releaseBody string,
-	verbose bool) error {
+	options Options) (Client, error) {
+
+	uploader, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+
+	uploader.clientFactory = clientFactory
+	if options.ReleaseFactory == nil {
+		uploader.releaseFactory = releaseFactory
+	}
+
+	err = uploader.Upload(ctx, filenames)
+	return uploader.client, err
+}
+
+func finalizeImpl(
+	ctx context.Context,
+	clientFactory clientFactoryFunc,
+	options Options) (Client, error) {
+
+	uploader, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+
+	uploader.clientFactory = clientFactory
+
+	err = uploader.Finalize(ctx)
+	return uploader.client, err
+}
+
+// Result returns the outcome of the preparation and the upload
+func (uploader *Uploader) Result() Result {
+	return uploader.result
+}
+
+// Prepare ensures the release corresponding to the build exists and targets
+// the current commit. It is called by Upload if it wasn't called before.
+func (uploader *Uploader) Prepare(ctx context.Context) error {
+	if uploader.prepared {
+		return nil
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	ready, err := uploader.setup()
+	if err != nil || !ready {
+		return err
+	}
+
+	info := uploader.info
+	client := uploader.client
+	logger := uploader.logger
+	verbose := uploader.options.Verbose
+
+	// Check whether the release corresponding to the tag already exists
+	releaseExists := false
+
+	release, response, err := client.GetReleaseByTag(ctx, info.Tag)
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+		if err != nil {
+			return err
+		}
+		releaseExists = true
+	}
+
+	if releaseExists {
+		targetCommitish := release.GetTargetCommitish()
+		if verbose {
+			logger.Printf(
+				"Found existing release: target commitish = %s\n",
+				targetCommitish)
+			if release.GetDraft() {
+				logger.Printf("The existing release is a draft\n")
+			}
+		}
+
+		if len(targetCommitish) != 0 && info.Commit != targetCommitish {
+			logger.Printf(
+				"Found existing release but its commit SHA doesn't "+
+					"match the current one: %s vs %s\n", info.Commit, targetCommitish)
+
+			if uploader.options.DeltaPatches && info.isContinuous() {
+				uploader.downloadPreviousAssets(ctx, release)
+			}
+
+			// Only the tags of continuous releases are owned by the tool so
+			// the tags of regular releases are never moved
+			if uploader.options.UpdateTag &&
+				(info.IsPrerelease || release.GetDraft()) {
+				err = uploader.moveReleaseToCommit(ctx, release)
+				if err != nil {
+					return err
+				}
+			} else {
+				release, err = uploader.deleteMismatchingRelease(ctx, release)
+				if err != nil {
+					return err
+				}
+				releaseExists = release != nil
+			}
+		}
+	}
+
+	if !releaseExists {
+		release, releaseExists, err = uploader.createRelease(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if releaseExists {
+		uploader.existingReleaseAssets, response, err = client.ListReleaseAssets(
+			ctx,
+			release.GetID())
+		response.CloseBody()
+		if err != nil {
+			return err
+		}
+
+		err = response.Check()
+		if err != nil {
+			return fmt.Errorf(
+				"Bad response on attempt to list release assets: %v", err)
+		}
+
+		// The release might have been moved to the current commit above
+		targetCommitish := release.GetTargetCommitish()
+		uploader.release = release
+		err = uploader.updateRelease(ctx, "update the release log",
+			func(release Release) error {
+				release.SetTargetCommitish(targetCommitish)
+				updateBuildLogWithinReleaseBody(release, info)
+				if verbose {
+					logger.Printf("Updated release log: %s\n",
+						release.GetBody())
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		release = uploader.release
+	} else {
+		logger.Printf("Created new release\n")
+		uploader.result.Created = true
+	}
+
+	uploader.release = release
+	uploader.result.Release = release
+	uploader.prepared = true
+
+	if uploader.options.Hooks.ReleasePrepared != nil {
+		return uploader.options.Hooks.ReleasePrepared(release)
+	}
+
+	return nil
+}
+
+// Upload uploads the files to the release, replacing the release assets
+// with the same names
+func (uploader *Uploader) Upload(ctx context.Context, filenames []string) error {
+	defer uploader.removeTmpDir()
+
+	sources, err := uploader.collectAssetSources(filenames)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		if len(source.splitFrom) == 0 {
+			uploader.deltaSources = append(uploader.deltaSources, source)
+		}
+	}
+
+	err = uploader.Prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	if uploader.result.Skipped {
+		return nil
+	}
+
+	if uploader.options.DeltaPatches {
+		sources = uploader.addDeltaPatchSources(sources)
+	}
+
+	if uploader.options.Zsync {
+		sources, err = uploader.addZsyncSources(sources)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger := uploader.logger
+	release := uploader.release
+
+	if len(uploader.options.JobNamespace) != 0 {
+		err = uploader.checkJobAssetOwners(sources)
+		if err != nil {
+			return err
+		}
+	}
+
+	var splitNames []string
+	for _, source := range sources {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		_, err = uploader.uploadAsset(ctx, source)
+		if err != nil {
+			return err
+		}
+
+		if source.partIndex == 1 {
+			splitNames = append(splitNames, source.splitFrom)
+		}
+	}
+
+	if len(uploader.options.JobNamespace) != 0 {
+		err = uploader.recordJobAssets(ctx, sources)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = uploader.updateSplitPartsSection(ctx, splitNames)
+	if err != nil {
+		return err
+	}
+
+	if uploader.options.UpdateFeed != nil {
+		err = uploader.updateFeed(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if release.GetDraft() {
+		if uploader.hasDeferredAssets() {
+			logger.Printf("Deferring the appcast and package manifests " +
+				"until the draft release is published\n")
+		}
+	} else {
+		err = uploader.updateDeferredAssets(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if uploader.options.SBOM {
+		err = uploader.updateSbom(ctx, sources)
+		if err != nil {
+			return err
+		}
+	}
+
+	if uploader.options.Provenance {
+		err = uploader.updateProvenance(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if uploader.options.Prune {
+		err = uploader.recordUploadedAssets(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(uploader.options.ExpectedAssets) != 0 {
+			err = uploader.pruneReleaseAssets(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if release.GetDraft() && len(uploader.options.ExpectedAssets) != 0 {
+		complete, err := uploader.hasExpectedAssets(ctx)
+		if err != nil {
+			return err
+		}
+
+		if complete {
+			logger.Printf("All expected release assets are uploaded, " +
+				"publishing the release\n")
+			err = uploader.publishRelease(ctx)
+			if err != nil {
+				return err
+			}
+
+			uploader.published = true
+			err = uploader.updateDeferredAssets(ctx)
+			if err != nil {
+				return err
+			}
+		} else {
+			logger.Printf("Not all expected release assets are uploaded yet, " +
+				"leaving the release as a draft\n")
+		}
+	}
+
+	return nil
+}
+
+// UploadAsset uploads the release asset from the reader, replacing
+// the release asset with the same name. The asset can only be re-uploaded
+// after failed verification if the reader is also an io.Seeker.
+func (uploader *Uploader) UploadAsset(
+	ctx context.Context,
+	upload AssetUpload) (ReleaseAsset, error) {
+
+	err := uploader.Prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if uploader.result.Skipped {
+		return nil, nil
+	}
+
+	return uploader.uploadAsset(ctx, readerAssetSource(upload))
+}
+
+// Finalize publishes the draft release corresponding to the build
+func (uploader *Uploader) Finalize(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	ready, err := uploader.setup()
+	if err != nil || !ready {
+		return err
+	}
+
+	info := uploader.info
+	logger := uploader.logger
+
+	release, response, err := uploader.client.GetReleaseByTag(ctx, info.Tag)
+	response.CloseBody()
+	if err != nil {
+		return fmt.Errorf("Failed to find the release to finalize: %v", err)
+	}
+
+	err = response.Check()
+	if err != nil {
+		return fmt.Errorf(
+			"Bad response on attempt to find the release to finalize: %v", err)
+	}
+
+	uploader.release = release
+	uploader.result.Release = release
+
+	targetCommitish := release.GetTargetCommitish()
+	otherCommit := len(targetCommitish) != 0 && info.Commit != targetCommitish
+	if uploader.options.Prune {
+		if otherCommit {
+			logger.Printf("The release corresponds to another commit, " +
+				"not pruning it\n")
+		} else {
+			err = uploader.pruneReleaseAssets(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !release.GetDraft() {
+		logger.Printf("The release is already published, nothing to finalize\n")
+		return nil
+	}
+
+	if otherCommit {
+		return fmt.Errorf(
+			"The draft release corresponds to another commit: %s vs %s",
+			targetCommitish, info.Commit)
+	}
+
+	logger.Printf("Publishing the draft release\n")
+	err = uploader.publishRelease(ctx)
+	if err != nil {
+		return err
+	}
+
+	uploader.published = true
+	return uploader.updateDeferredAssets(ctx)
+}
+
+// Collects the build info and creates the client, returns false if
+// the build event doesn't require doing anything
+func (uploader *Uploader) setup() (bool, error) {
+	if uploader.client != nil {
+		return true, nil
+	}
+
+	if uploader.result.Skipped {
+		return false, nil
+	}
+
+	var info BuildInfo
+	if uploader.options.BuildInfo != nil {
+		info = *uploader.options.BuildInfo
+	} else {
+		// Collect the information about the current build event
+		collectedInfo, err := collectBuildEventInfo(
+			uploader.options.ReleaseSuffix,
+			uploader.logger,
+			uploader.options.Verbose)
+		if err != nil {
+			return false, err
+		}
+
+		if collectedInfo == nil {
+			uploader.logger.Printf("No build event info, won't do anything\n")
+			uploader.result.Skipped = true
+			return false, nil
+		}
+
+		info = *collectedInfo
+	}
+
+	if len(uploader.options.JobKey) != 0 {
+		info.JobKey = uploader.options.JobKey
+	}
+
+	if len(uploader.options.JobNamespace) != 0 && len(info.JobKey) == 0 {
+		return false, errors.New("No job key found within CI env vars, " +
+			"it has to be specified explicitly")
+	}
+
+	if len(uploader.options.ReleaseTitleTemplate) != 0 {
+		title, err := executeTemplate(
+			uploader.options.ReleaseTitleTemplate,
+			&info)
+		if err != nil {
+			return false, err
+		}
+		info.ReleaseTitle = title
+	}
+
+	uploader.info = &info
+
+	uploader.client = uploader.options.Client
+	if uploader.client == nil {
+		uploader.client = uploader.clientFactory(info.Token, info.Owner, info.Repo)
+	}
+
+	return true, nil
+}
+
+func (uploader *Uploader) releaseBody() (string, error) {
+	if len(uploader.options.ReleaseBodyTemplate) == 0 {
+		return uploader.options.ReleaseBody, nil
+	}
+	return executeTemplate(uploader.options.ReleaseBodyTemplate, uploader.info)
+}
+
+// Deletes the release which commit doesn't match the current one along with
+// its tag. Returns the release recreated for the current commit by another
+// build job running concurrently with the current one, if there's any.
+func (uploader *Uploader) deleteMismatchingRelease(
+	ctx context.Context,
+	release Release) (Release, error) {
+
+	client := uploader.client
+	info := uploader.info
+	logger := uploader.logger
+
+	logger.Printf(
+		"Deleting the existing release to recreate it with "+
+			"the current commit SHA %s\n", info.Commit)
+
+	response, err := client.DeleteRelease(ctx, release.GetID())
+	response.CloseBody()
+	if err != nil {
+		if response.GetStatusCode() != http.StatusNotFound {
+			return nil, err
+		}
+		logger.Printf("The existing release was already deleted by another " +
+			"build job\n")
+	}
+
+	// Another build job for the current commit might have already recreated
+	// the release, its tag must not be deleted then
+	concurrentRelease := uploader.findReleaseForCommit(ctx)
+	if concurrentRelease != nil {
+		logger.Printf("Found the release recreated by another build job " +
+			"for the current commit\n")
+		return concurrentRelease, nil
+	}
+
+	// Draft releases have no tags until they are published
+	if info.IsPrerelease && !release.GetDraft() {
+		// The release might have been recreated since it was looked up above,
+		// so it's looked up once more right before its tag is deleted. Any
+		// failure of the lookup means there's no release since the release
+		// has just been deleted.
+		currentRelease, response, err := client.GetReleaseByTag(ctx, info.Tag)
+		response.CloseBody()
+		if err == nil {
+			err = response.Check()
+		}
+		if err == nil && (currentRelease.GetID() != release.GetID() ||
+			currentRelease.GetTargetCommitish() !=
+				release.GetTargetCommitish()) {
+			logger.Printf("The release was recreated by another build job, " +
+				"not deleting its tag\n")
+			if currentRelease.GetTargetCommitish() == info.Commit {
+				return currentRelease, nil
+			}
+			return nil, nil
+		}
+
+		logger.Printf("Since the existing release was pre-release one, " +
+			"need to also remove the tag corresponding to it\n")
+		response, err = client.DeleteTag(ctx, info.Tag)
+		response.CloseBody()
+		if err != nil {
+			return nil, err
+		}
 
-	_, err := uploadImpl(
-		clientFactoryFunc(newGitHubClient),
-		newGitHubRelease,
-		filenames,
-		releaseSuffix,
-		releaseBody,
-		verbose)
-	return err
+		// The tag might have been already deleted by another build job
+		statusCode := response.GetStatusCode()
+		if statusCode != http.StatusNotFound &&
+			statusCode != http.StatusUnprocessableEntity {
+			err = response.Check()
+			if err != nil {
+				return nil, fmt.Errorf(
+					"Bad response on attempt to delete the tag: %v", err)
+			}
+		}
+	}
+
+	return nil, nil
 }
 
-func uploadImpl(
-	clientFactory clientFactoryFunc,
-	releaseFactory releaseFactoryFunc,
-	filenames []string,
-	releaseSuffix string,
-	releaseBody string,
-	verbose bool) (Client, error) {
+// Creates the new release for the current commit. If another build job has
+// created the release for the same tag concurrently with the current one,
+// returns that release and true as the second value.
+func (uploader *Uploader) createRelease(ctx context.Context) (Release, bool, error) {
+	logger := uploader.logger
 
-	// Collect the information about the current build event
-	info, err := collectBuildEventInfo(releaseSuffix, verbose)
+	releaseBody, err := uploader.releaseBody()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	if info == nil {
-		fmt.Println("No build event info, won't do anything")
-		return nil, nil
+	logger.Printf("Creating new release\n")
+	newRelease := uploader.releaseFactory(
+		releaseBody,
+		uploader.info,
+		uploader.options.Verbose)
+	if uploader.options.Draft {
+		logger.Printf("The release is created as a draft\n")
+		newRelease.SetDraft(true)
 	}
 
-	client := clientFactory(info.token, info.owner, info.repo)
-
-	// Check whether the release corresponding to the tag already exists
-	releaseExists := false
-
-	release, response, err := client.GetReleaseByTag(info.tag)
+	release, response, err := uploader.client.CreateRelease(ctx, newRelease)
 	response.CloseBody()
 	if err == nil {
 		err = response.Check()
-		if err != nil {
-			return client, err
+		if err == nil {
+			return release, false, nil
 		}
-		releaseExists = true
+		err = fmt.Errorf(
+			"Bad response on attempt to create the new release: %v", err)
 	}
 
-	if releaseExists {
-		targetCommitish := release.GetTargetCommitish()
-		if verbose {
-			fmt.Println(
-				"Found existing release: target commitish = " + targetCommitish)
+	// GitHub refuses to create the release if the release for the same tag
+	// already exists
+	if response.GetStatusCode() != http.StatusUnprocessableEntity {
+		return nil, false, err
+	}
+
+	for attempt := 0; attempt < maxConcurrentReleaseLookupAttempts; attempt++ {
+		if attempt != 0 {
+			select {
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			case <-time.After(concurrentReleaseLookupDelay):
+			}
 		}
 
-		if len(targetCommitish) != 0 && info.commit != targetCommitish {
-			fmt.Printf(
-				"Found existing release but its commit SHA doesn't "+
-					"match the current one: %s vs %s\n", info.commit, targetCommitish)
-			fmt.Printf(
-				"Deleting the existing release to recreate it with "+
-					"the current commit SHA %s\n", info.commit)
+		concurrentRelease := uploader.findReleaseForCommit(ctx)
+		if concurrentRelease != nil {
+			logger.Printf("The release was created concurrently by another " +
+				"build job for the current commit, using it\n")
+			return concurrentRelease, true, nil
+		}
+	}
+
+	return nil, false, err
+}
+
+func (uploader *Uploader) findReleaseForCommit(ctx context.Context) Release {
+	release, response, err := uploader.client.GetReleaseByTag(ctx, uploader.info.Tag)
+	response.CloseBody()
+	if err != nil || response.Check() != nil {
+		return nil
+	}
+
+	if release.GetTargetCommitish() != uploader.info.Commit {
+		return nil
+	}
+
+	return release
+}
+
+// Moves the release to the current commit deleting the release assets built
+// from the previous one. It's done holding the lock of the release body:
+// another build job of the current commit might have moved the release and
+// uploaded its binaries since the release was looked up, they must not be
+// deleted then.
+func (uploader *Uploader) moveReleaseToCommit(ctx context.Context, release Release) error {
+	client := uploader.client
+	info := uploader.info
+	logger := uploader.logger
+
+	uploader.release = release
+	return uploader.withLock(ctx, releaseBodyLockName, func() error {
+		currentRelease, err := uploader.currentRelease(ctx)
+		if err != nil {
+			return err
+		}
+
+		release.SetTargetCommitish(info.Commit)
+		if currentRelease.GetTargetCommitish() == info.Commit {
+			logger.Printf("The release was already moved to the current " +
+				"commit by another build job\n")
+			return nil
+		}
+
+		// Draft releases have no tags until they are published so there's
+		// nothing to move for them, their target commit is updated instead
+		if !release.GetDraft() {
+			logger.Printf("Moving tag %s to the current commit SHA %s\n",
+				info.Tag, info.Commit)
+			response, err := client.UpdateTagRef(ctx, info.Tag, info.Commit)
+			response.CloseBody()
+			if err != nil {
+				return err
+			}
 
-			response, err = client.DeleteRelease(release.GetID())
+			err = response.Check()
+			if err != nil {
+				return fmt.Errorf(
+					"Bad response on attempt to move the tag: %v", err)
+			}
+		} else {
+			currentRelease.SetTargetCommitish(info.Commit)
+			_, response, err := client.UpdateRelease(ctx, currentRelease)
 			response.CloseBody()
+			if err == nil {
+				err = response.Check()
+			}
 			if err != nil {
-				return client, err
+				return fmt.Errorf("Failed to move the draft release to "+
+					"the current commit: %v", err)
 			}
+		}
 
-			releaseExists = false
+		assets, response, err := client.ListReleaseAssets(ctx, release.GetID())
+		response.CloseBody()
+		if err != nil {
+			return err
+		}
 
-			if info.isPrerelease {
-				fmt.Println("Since the existing release was pre-release one, " +
-					"need to also remove the tag corresponding to it")
-				response, err = client.DeleteTag(info.tag)
-				response.CloseBody()
-				if err != nil {
-					return client, err
-				}
+		err = response.Check()
+		if err != nil {
+			return fmt.Errorf(
+				"Bad response on attempt to list release assets: %v", err)
+		}
+
+		for _, asset := range assets {
+			// Locks and replacements belong to build jobs still running
+			if asset.GetID() == 0 || isTransientAsset(asset.GetName()) {
+				continue
+			}
+
+			logger.Printf("Deleting release asset %s built from the previous "+
+				"commit\n", asset.GetName())
+			if uploader.options.Verbose {
+				logger.Printf("Release asset: %s\n", asset.GetDescription())
+			}
+
+			err = uploader.deleteReleaseAsset(ctx, asset)
+			if err != nil {
+				return err
 			}
 		}
+
+		return nil
+	})
+}
+
+// Uploads the release asset replacing the duplicate one and runs the hooks
+func (uploader *Uploader) uploadAsset(
+	ctx context.Context,
+	source *assetSource) (ReleaseAsset, error) {
+
+	hooks := uploader.options.Hooks
+	filename := source.filename
+
+	if hooks.BeforeUpload != nil {
+		err := hooks.BeforeUpload(filename)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	var existingReleaseAssets []ReleaseAsset
+	err := uploader.deleteDuplicateReleaseAssets(ctx, source)
+	if err != nil {
+		return nil, err
+	}
 
-	if !releaseExists {
-		fmt.Println("Creating new release")
-		release, response, err = client.CreateRelease(
-			releaseFactory(releaseBody, info, verbose))
+	if filename == source.name {
+		uploader.logger.Printf("Trying to upload file: %s\n", filename)
 	} else {
-		existingReleaseAssets, response, err = client.ListReleaseAssets(
-			release.GetID())
+		uploader.logger.Printf("Trying to upload file: %s as %s\n",
+			filename, source.name)
 	}
 
-	response.CloseBody()
+	asset, err := uploader.uploadReleaseAsset(
+		ctx, uploader.release.GetID(), source)
 	if err != nil {
-		return client, err
+		return nil, err
 	}
 
-	err = response.Check()
+	uploader.existingReleaseAssets = append(
+		uploader.existingReleaseAssets, asset)
+	uploader.result.Assets = append(uploader.result.Assets, asset)
+
+	if hooks.AfterUpload != nil {
+		err = hooks.AfterUpload(filename, asset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return asset, nil
+}
+
+// Uploads the release asset generated from the uploaded ones, replacing
+// the one uploaded by this or another build job earlier. If the client can
+// rename release assets, the content is uploaded under the temporary name
+// first so the asset is missing only between the deletion of the replaced
+// one and the rename rather than for the whole upload.
+func (uploader *Uploader) replaceReleaseAsset(
+	ctx context.Context,
+	source *assetSource) (ReleaseAsset, error) {
+
+	releaseId := uploader.release.GetID()
+	assets, response, err := uploader.client.ListReleaseAssets(ctx, releaseId)
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
 	if err != nil {
-		if !releaseExists {
-			return client, fmt.Errorf(
-				"Bad response on attempt to create the new release: %v", err)
+		return nil, fmt.Errorf("Failed to list release assets to replace "+
+			"%s: %v", source.name, err)
+	}
+
+	name := source.name
+	replacementName := name + replacementAssetSuffix
+	var replaced []ReleaseAsset
+	for _, asset := range assets {
+		if asset.GetID() == 0 {
+			continue
+		}
+
+		// The replacement left behind by the killed build job is stale
+		if asset.GetName() == replacementName {
+			err = uploader.deleteReleaseAsset(ctx, asset)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if asset.GetName() == name {
+			replaced = append(replaced, asset)
 		}
-		return client, fmt.Errorf(
-			"Bad response on attempt to list release assets: %v", err)
 	}
 
-	if releaseExists {
-		release = updateBuildLogWithinReleaseBody(release, info, verbose)
-		release, response, err = client.UpdateRelease(release)
-		response.CloseBody()
+	renamer, canRename := uploader.client.(ReleaseAssetRenamer)
+	if len(replaced) == 0 || !canRename {
+		for _, asset := range replaced {
+			err = uploader.deleteReleaseAsset(ctx, asset)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		asset, err := uploader.uploadReleaseAsset(ctx, releaseId, source)
 		if err != nil {
-			return client, err
+			return nil, err
 		}
-	} else {
-		fmt.Println("Created new release")
+
+		uploader.result.Assets = append(uploader.result.Assets, asset)
+		return asset, nil
+	}
+
+	source.name = replacementName
+	asset, err := uploader.uploadReleaseAsset(ctx, releaseId, source)
+	source.name = name
+	if err != nil {
+		return nil, err
+	}
+
+	digest, ok := uploader.assetDigests[replacementName]
+	if ok {
+		delete(uploader.assetDigests, replacementName)
+		uploader.assetDigests[name] = digest
 	}
 
-	for _, filename := range commandLineFiles(filenames) {
-		file, err := os.Open(filename)
+	for _, asset := range replaced {
+		err = uploader.deleteReleaseAsset(ctx, asset)
 		if err != nil {
-			return client, err
+			return nil, err
+		}
+	}
+
+	asset, response, err = renamer.RenameReleaseAsset(ctx, asset.GetID(), name)
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to rename %s to %s: %v",
+			replacementName, name, err)
+	}
+
+	uploader.result.Assets = append(uploader.result.Assets, asset)
+	return asset, nil
+}
+
+func (uploader *Uploader) deleteDuplicateReleaseAssets(
+	ctx context.Context,
+	source *assetSource) error {
+
+	remainingAssets := make(
+		[]ReleaseAsset, 0, len(uploader.existingReleaseAssets))
+
+	for _, existingReleaseAsset := range uploader.existingReleaseAssets {
+		if uploader.options.Verbose {
+			uploader.logger.Printf("Examing release asset: %s\n",
+				existingReleaseAsset.GetDescription())
+		}
+		if existingReleaseAsset.GetID() == 0 ||
+			len(existingReleaseAsset.GetName()) == 0 ||
+			!source.replaces(existingReleaseAsset.GetName()) {
+			remainingAssets = append(remainingAssets, existingReleaseAsset)
+			continue
 		}
-		defer file.Close()
 
-		stat, err := file.Stat()
+		if existingReleaseAsset.GetName() == source.name {
+			uploader.logger.Printf("Found duplicate release asset %s, "+
+				"deleting it\n", existingReleaseAsset.GetName())
+		} else {
+			uploader.logger.Printf("Found stale release asset %s from "+
+				"the previous upload of %s, deleting it\n",
+				existingReleaseAsset.GetName(), source.originalName())
+		}
+		err := uploader.deleteReleaseAsset(ctx, existingReleaseAsset)
 		if err != nil {
-			return client, err
+			return err
 		}
+	}
 
-		mode := stat.Mode()
-		if !mode.IsRegular() {
-			fmt.Printf("Skipping dir %s\n", filename)
-			continue
+	uploader.existingReleaseAssets = remainingAssets
+	return nil
+}
+
+func (uploader *Uploader) deleteReleaseAsset(ctx context.Context, asset ReleaseAsset) error {
+	response, err := uploader.client.DeleteReleaseAsset(ctx, asset.GetID())
+	response.CloseBody()
+	if err != nil {
+		return err
+	}
+
+	err = response.Check()
+	if err != nil {
+		return fmt.Errorf(
+			"Bad response on attempt to delete the stale release asset: %v",
+			err)
+	}
+
+	return nil
+}
+
+func (uploader *Uploader) hasExpectedAssets(ctx context.Context) (bool, error) {
+	// Other build jobs might have uploaded their assets in the meantime so
+	// need to get the up to date list of release assets
+	assets, response, err := uploader.client.ListReleaseAssets(
+		ctx,
+		uploader.release.GetID())
+	response.CloseBody()
+	if err != nil {
+		return false, err
+	}
+
+	err = response.Check()
+	if err != nil {
+		return false, fmt.Errorf(
+			"Bad response on attempt to list release assets: %v", err)
+	}
+
+	for _, expectedAsset := range uploader.options.ExpectedAssets {
+		found := false
+		for _, asset := range assets {
+			if asset.GetName() == expectedAsset {
+				found = true
+				break
+			}
 		}
 
-		if existingReleaseAssets != nil {
-			for i, existingReleaseAsset := range existingReleaseAssets {
-				if verbose {
-					fmt.Println("Examing release asset: " +
-						existingReleaseAsset.GetDescription())
-				}
-				if existingReleaseAsset.GetID() == 0 {
-					continue
-				}
-				if len(existingReleaseAsset.GetName()) == 0 {
-					continue
-				}
-				if existingReleaseAsset.GetName() == filepath.Base(filename) {
-					fmt.Printf("Found duplicate release asset %s, deleting it\n",
-						existingReleaseAsset.GetName())
-					response, err = client.DeleteReleaseAsset(
-						existingReleaseAsset.GetID())
-					response.CloseBody()
-					if err != nil {
-						return client, err
-					}
-
-					err = response.Check()
-					if err != nil {
-						return client, fmt.Errorf(
-							"Bad response on attempt to delete the stale "+
-								"release asset: %v", err)
-					}
-
-					existingReleaseAssets = append(
-						existingReleaseAssets[:i],
-						existingReleaseAssets[i+1:]...)
-				}
+		if !found {
+			if uploader.options.Verbose {
+				uploader.logger.Printf("Expected release asset is missing: %s\n",
+					expectedAsset)
 			}
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// The appcast and package manifests link to the binaries by the URLs which
+// only resolve once the release is published, and the manifests committed
+// into Scoop buckets and Homebrew taps become public at once
+func (uploader *Uploader) hasDeferredAssets() bool {
+	options := uploader.options
+	return options.Appcast != nil || options.Scoop != nil ||
+		options.Homebrew != nil
+}
+
+func (uploader *Uploader) updateDeferredAssets(ctx context.Context) error {
+	if uploader.options.Appcast != nil {
+		err := uploader.updateAppcast(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return uploader.updatePackageManifests(ctx)
+}
+
+func (uploader *Uploader) publishRelease(ctx context.Context) error {
+	return uploader.updateRelease(ctx, "publish the release",
+		func(release Release) error {
+			release.SetDraft(false)
+			return nil
+		})
+}
+
+func (uploader *Uploader) uploadReleaseAsset(
+	ctx context.Context,
+	releaseId int64,
+	source *assetSource) (ReleaseAsset, error) {
+
+	client := uploader.client
+	logger := uploader.logger
+	verify := uploader.options.Verify
+	assetName := source.name
+
+	label, contentType, err := assetLabelAndContentType(
+		uploader.options.Assets, uploader.info, assetName)
+	if err != nil {
+		return nil, err
+	}
+	if len(label) != 0 {
+		source.label = label
+	}
+	if len(contentType) != 0 {
+		source.contentType = contentType
+	}
+
+	for attempt := 1; ; attempt++ {
+		content, err := source.open()
+		if err != nil {
+			return nil, err
 		}
 
-		fmt.Printf("Trying to upload file: %s\n", filename)
+		// The digest is computed along the way to avoid reading the content
+		// once more for the verification and the update feed
+		hash := sha256.New()
+		uploadContent := io.TeeReader(content, hash)
+
+		uploadCtx, cancel := ctx, context.CancelFunc(func() {})
+		if uploader.options.UploadTimeout > 0 {
+			uploadCtx, cancel = context.WithTimeout(
+				ctx, uploader.options.UploadTimeout)
+		}
 
 		asset, response, err := client.UploadReleaseAsset(
-			release.GetID(),
-			filepath.Base(filename),
-			file)
+			uploadCtx,
+			releaseId,
+			source.assetUpload(uploadContent))
+		content.Close()
+		uploadErr := uploadCtx.Err()
+		cancel()
 		response.CloseBody()
 		if err != nil {
-			return client, err
+			if uploadErr != nil {
+				logger.Printf("Upload of release asset %s was interrupted: %v\n",
+					assetName, uploadErr)
+				uploader.deletePartialReleaseAsset(releaseId, assetName)
+				return nil, fmt.Errorf(
+					"Upload of release asset %s was interrupted: %v",
+					assetName, uploadErr)
+			}
+			return nil, err
 		}
 
 		err = response.Check()
 		if err != nil {
-			return client, fmt.Errorf(
+			return nil, fmt.Errorf(
 				"Bad response on attempt to upload release asset: %v", err)
 		}
 
-		existingReleaseAssets = append(existingReleaseAssets, asset)
+		digest := hex.EncodeToString(hash.Sum(nil))
+		if !verify {
+			uploader.assetDigests[assetName] = digest
+			return asset, nil
+		}
+
+		err = uploader.verifyReleaseAsset(ctx, asset, source.size, digest)
+		if err == nil {
+			logger.Printf("Verified uploaded release asset %s\n", assetName)
+			uploader.assetDigests[assetName] = digest
+			return asset, nil
+		}
+
+		logger.Printf("Verification of uploaded release asset %s failed: %v\n",
+			assetName, err)
+		if attempt >= maxUploadVerificationAttempts {
+			return nil, fmt.Errorf(
+				"Failed to upload release asset %s intact after %d attempts",
+				assetName, attempt)
+		}
+
+		logger.Printf("Deleting the broken release asset %s to upload it "+
+			"again\n", assetName)
+		err = uploader.deleteReleaseAsset(ctx, asset)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Deletes the release asset left behind by the interrupted upload. The
+// context of the upload is already done at this point so the cleanup is
+// given its own short deadline.
+func (uploader *Uploader) deletePartialReleaseAsset(
+	releaseId int64,
+	assetName string) {
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), partialReleaseAssetCleanupTimeout)
+	defer cancel()
+
+	assets, response, err := uploader.client.ListReleaseAssets(ctx, releaseId)
+	response.CloseBody()
+	if err == nil {
+		err = response.Check()
+	}
+	if err != nil {
+		uploader.logger.Printf("Failed to list release assets to clean up "+
+			"partially uploaded %s: %v\n", assetName, err)
+		return
+	}
+
+	for _, asset := range assets {
+		if asset.GetID() == 0 || asset.GetName() != assetName {
+			continue
+		}
+
+		uploader.logger.Printf("Deleting partially uploaded release asset %s\n",
+			assetName)
+		err = uploader.deleteReleaseAsset(ctx, asset)
+		if err != nil {
+			uploader.logger.Printf("Failed to delete partially uploaded "+
+				"release asset %s: %v\n", assetName, err)
+		}
+	}
+}
+
+func (uploader *Uploader) verifyReleaseAsset(
+	ctx context.Context,
+	asset ReleaseAsset,
+	size int64,
+	digest string) error {
+
+	// The size reported by GitHub is enough to detect truncated uploads
+	// without downloading anything
+	if asset.GetSize() != size {
+		return fmt.Errorf("size mismatch: expected %d, got %d",
+			size, asset.GetSize())
+	}
+
+	content, response, err := uploader.client.DownloadReleaseAsset(ctx, asset.GetID())
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	err = response.Check()
+	if err != nil {
+		return err
+	}
+
+	uploadedDigest, err := readerSha256(content)
+	if err != nil {
+		return err
+	}
+
+	if uploadedDigest != digest {
+		return fmt.Errorf("SHA-256 mismatch: expected %s, got %s",
+			digest, uploadedDigest)
 	}
 
-	return client, nil
+	return nil
 }
 
+// Adds the link to the log of the current CI build to the release body or
+// replaces the link to the log of the previous build. The body is left as is
+// if the build isn't known, i.e. when the build info is given explicitly by
+// the library user or when the release is made outside of CI builds.
 func updateBuildLogWithinReleaseBody(
 	release Release,
-	info *buildEventInfo,
-	verbose bool) Release {
+	info *BuildInfo) Release {
+
+	if len(info.BuildId) == 0 {
+		return release
+	}
 
 	existingBody := release.GetBody()
 	scanner := bufio.NewScanner(strings.NewReader(existingBody))
@@ -226,17 +1356,17 @@ func updateBuildLogWithinReleaseBody(
 	foundCiLine := false
 	for scanner.Scan() {
 		line := scanner.Text()
-		if info.isTravisCi && strings.HasPrefix(
+		if info.isTravisCi() && strings.HasPrefix(
 			line,
-			"Travis CI build log: https://travis-ci.org/"+info.owner+"/"+
-				info.repo+"/builds/") {
+			"Travis CI build log: https://travis-ci.org/"+info.Owner+"/"+
+				info.Repo+"/builds/") {
 
 			foundCiLine = true
 			line = ciBuildLogString(info)
-		} else if !info.isTravisCi && strings.HasPrefix(
+		} else if !info.isTravisCi() && strings.HasPrefix(
 			line,
 			"AppVeyor CI build log: https://ci.appveyor.com/project/"+
-				info.owner+"/"+info.repo+"/build") {
+				info.Owner+"/"+info.Repo+"/build") {
 
 			foundCiLine = true
 			line = ciBuildLogString(info)
@@ -248,22 +1378,43 @@ func updateBuildLogWithinReleaseBody(
 		newBody = newBody + ciBuildLogString(info) + "\n"
 	}
 
-	if verbose {
-		fmt.Println("Updated release log: " + newBody)
-	}
-
 	release.SetBody(newBody)
 	return release
 }
 
-func ciBuildLogString(info *buildEventInfo) string {
-	if len(info.buildId) == 0 {
+// Removes the links to the CI build logs from the release body
+func stripCiBuildLogs(body string) string {
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	newBody := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Travis CI build log: ") ||
+			strings.HasPrefix(line, "AppVeyor CI build log: ") {
+			continue
+		}
+		newBody = newBody + line + "\n"
+	}
+	return newBody
+}
+
+func ciBuildLogString(info *BuildInfo) string {
+	if len(info.BuildId) == 0 {
+		return ""
+	}
+	if info.isTravisCi() {
+		return "Travis CI build log: " + ciBuildLogURL(info)
+	}
+	return "AppVeyor CI build log: " + ciBuildLogURL(info)
+}
+
+func ciBuildLogURL(info *BuildInfo) string {
+	if len(info.BuildId) == 0 {
 		return ""
 	}
-	if info.isTravisCi {
-		return "Travis CI build log: https://travis-ci.org/" + info.owner +
-			"/" + info.repo + "/builds/" + info.buildId + "/"
+	if info.isTravisCi() {
+		return "https://travis-ci.org/" + info.Owner + "/" + info.Repo +
+			"/builds/" + info.BuildId + "/"
 	}
-	return "AppVeyor CI build log: https://ci.appveyor.com/project/" +
-		info.owner + "/" + info.repo + "/build/" + info.buildId
+	return "https://ci.appveyor.com/project/" + info.Owner + "/" +
+		info.Repo + "/build/" + info.BuildId
 }
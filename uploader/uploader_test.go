@@ -2,11 +2,13 @@ package uploader
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -54,12 +56,13 @@ func TestNewReleaseWithSingleUploadedBinary(t *testing.T) {
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(newTstClient),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload the single binary: %v", err)
 		}
@@ -164,12 +167,13 @@ func TestNewReleaseWithSeveralUploadedBinaries(t *testing.T) {
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(newTstClient),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			filenames,
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload one of binaries: %v", err)
 		}
@@ -263,7 +267,7 @@ func TestInitiallyEmptyExistingReleaseWithSingleUploadedBinary(t *testing.T) {
 			owner string,
 			repo string) Client {
 
-			info, err := collectBuildEventInfo(releaseSuffix, false)
+			info, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
 			if err != nil {
 				panic(err)
 			}
@@ -274,12 +278,13 @@ func TestInitiallyEmptyExistingReleaseWithSingleUploadedBinary(t *testing.T) {
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(clientFactory),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload one of binaries: %v", err)
 		}
@@ -388,7 +393,7 @@ func TestInitiallyEmptyExistingReleaseWithSeveralUploadedBinaries(t *testing.T)
 			owner string,
 			repo string) Client {
 
-			info, err := collectBuildEventInfo(releaseSuffix, false)
+			info, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
 			if err != nil {
 				panic(err)
 			}
@@ -399,12 +404,13 @@ func TestInitiallyEmptyExistingReleaseWithSeveralUploadedBinaries(t *testing.T)
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(clientFactory),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			filenames,
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload one of binaries: %v", err)
 		}
@@ -498,7 +504,7 @@ func TestExistingReleaseWithSingleUploadedBinary(t *testing.T) {
 			owner string,
 			repo string) Client {
 
-			info, err := collectBuildEventInfo(releaseSuffix, false)
+			info, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
 			if err != nil {
 				panic(err)
 			}
@@ -515,12 +521,13 @@ func TestExistingReleaseWithSingleUploadedBinary(t *testing.T) {
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(clientFactory),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload the single binary: %v", err)
 		}
@@ -631,7 +638,7 @@ func TestExistingReleaseWithSeveralUploadedBinariesAllBeingReplacements(
 			owner string,
 			repo string) Client {
 
-			info, err := collectBuildEventInfo(releaseSuffix, false)
+			info, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
 			if err != nil {
 				panic(err)
 			}
@@ -663,12 +670,13 @@ func TestExistingReleaseWithSeveralUploadedBinariesAllBeingReplacements(
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(clientFactory),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			filenames,
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload one of binaries: %v", err)
 		}
@@ -801,7 +809,7 @@ func TestExistingReleaseWithSeveralUploadedBinariesNotAllBeingReplacements(
 			owner string,
 			repo string) Client {
 
-			info, err := collectBuildEventInfo(releaseSuffix, false)
+			info, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
 			if err != nil {
 				panic(err)
 			}
@@ -847,12 +855,13 @@ func TestExistingReleaseWithSeveralUploadedBinariesNotAllBeingReplacements(
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(clientFactory),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			filenames,
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload one of binaries: %v", err)
 		}
@@ -958,7 +967,7 @@ func TestDeletionOfPreviousReleaseOnTargetCommitMismatch(t *testing.T) {
 			owner string,
 			repo string) Client {
 
-			info, err := collectBuildEventInfo(releaseSuffix, false)
+			info, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
 			if err != nil {
 				panic(err)
 			}
@@ -973,12 +982,13 @@ func TestDeletionOfPreviousReleaseOnTargetCommitMismatch(t *testing.T) {
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(clientFactory),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload the single binary: %v", err)
 		}
@@ -1006,6 +1016,157 @@ func TestDeletionOfPreviousReleaseOnTargetCommitMismatch(t *testing.T) {
 	}
 }
 
+func TestMovingOfContinuousTagOnTargetCommitMismatch(t *testing.T) {
+	binaryContent := "Binary content"
+	file, err := setupSampleAssetFile("singleUploadedBinary.txt", binaryContent)
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the single "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	oldCommit := generateRandomString(16)
+	oldId := int64(0)
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+	releaseSuffix := "master"
+
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	clientFactory := func(
+		gitHubToken string,
+		owner string,
+		repo string) Client {
+
+		info, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
+		if err != nil {
+			panic(err)
+		}
+		tstRelease := newTstRelease("", info, false).(*TstRelease)
+		tstRelease.targetCommitish = oldCommit
+		tstRelease.assets = append(tstRelease.assets, TstReleaseAsset{
+			id:      lastFreeReleaseAssetId,
+			name:    "stale.txt",
+			content: "Stale content",
+		})
+		lastFreeReleaseAssetId++
+		oldId = tstRelease.GetID()
+		tstClient := newTstClient(gitHubToken, owner, repo).(*TstClient)
+		tstClient.releases = append(tstClient.releases, *tstRelease)
+		tstClient.tagNames = append(tstClient.tagNames, tag)
+		return tstClient
+	}
+
+	client, err := uploadImpl(
+		context.Background(),
+		clientFactoryFunc(clientFactory),
+		ReleaseFactory(newTstRelease),
+		[]string{file.Name()},
+		Options{
+			ReleaseSuffix: releaseSuffix,
+			UpdateTag:     true})
+	if err != nil {
+		t.Fatalf("Failed to upload the single binary: %v", err)
+	}
+
+	tstClient := client.(*TstClient)
+	if len(tstClient.releases) != 1 {
+		t.Fatalf("Detected wrong number of releases within client: want 1, "+
+			"have %d", len(tstClient.releases))
+	}
+
+	release := tstClient.releases[0]
+	if release.GetID() != oldId {
+		t.Fatalf("The existing release was recreated instead of being reused")
+	}
+
+	if release.GetTargetCommitish() != commit {
+		t.Fatalf("The tag was not moved to the current commit")
+	}
+
+	assets := release.GetAssets()
+	if len(assets) != 1 {
+		t.Fatalf("Detected wrong number of release assets: want 1, have %d",
+			len(assets))
+	}
+
+	if assets[0].GetName() != filepath.Base(file.Name()) {
+		t.Fatalf("The release asset from the previous commit was not deleted")
+	}
+}
+
+func TestMovingOfContinuousTagKeepsBinariesOfConcurrentBuildJob(t *testing.T) {
+	dir := t.TempDir()
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+	releaseSuffix := "master"
+
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	factory := newSharedTstClientFactory()
+	upload := func(name string) error {
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			writeSampleFiles(t, dir, map[string]string{name: name}),
+			Options{ReleaseSuffix: releaseSuffix, UpdateTag: true})
+		return err
+	}
+
+	factory.setup = func(tstClient *TstClient) {
+		tstClient.releases = append(tstClient.releases, TstRelease{
+			id:              lastFreeReleaseId,
+			name:            "Continuous build (" + tag + ")",
+			tagName:         tag,
+			targetCommitish: generateRandomString(16),
+			isPrerelease:    true,
+			assets: []TstReleaseAsset{
+				{id: lastFreeReleaseAssetId, name: "stale.txt"},
+			},
+		})
+		lastFreeReleaseId++
+		lastFreeReleaseAssetId++
+		tstClient.tagNames = append(tstClient.tagNames, tag)
+
+		// The first build job has found the release for the previous commit
+		// and is about to move it when the second one runs entirely
+		runSecondBuildJob := func() {
+			delete(tstClient.hooks, "UploadReleaseAsset")
+			delete(tstClient.hooks, "UpdateTagRef")
+			err := upload("second.txt")
+			if err != nil {
+				t.Fatalf("Failed to upload the second binary: %v", err)
+			}
+		}
+		tstClient.hooks = map[string]func(){
+			"UploadReleaseAsset": runSecondBuildJob,
+			"UpdateTagRef":       runSecondBuildJob,
+		}
+	}
+
+	err := upload("first.txt")
+	if err != nil {
+		t.Fatalf("Failed to upload the first binary: %v", err)
+	}
+
+	if len(factory.client.hooks) != 0 {
+		t.Fatalf("The second build job was not interleaved")
+	}
+
+	var names []string
+	for _, asset := range factory.client.releases[0].GetAssets() {
+		names = append(names, asset.GetName())
+	}
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "first.txt,second.txt" {
+		t.Fatalf("Unexpected release assets: %v", names)
+	}
+}
+
 func TestNewReleaseBuildCreation(t *testing.T) {
 	binaryContent := "Binary content"
 	file, err := setupSampleAssetFile("singleUploadedBinary.txt", binaryContent)
@@ -1055,7 +1216,7 @@ func TestNewReleaseBuildCreation(t *testing.T) {
 			owner string,
 			repo string) Client {
 
-			info, err := collectBuildEventInfo(releaseSuffix, false)
+			info, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
 			if err != nil {
 				panic(err)
 			}
@@ -1120,12 +1281,13 @@ func TestNewReleaseBuildCreation(t *testing.T) {
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(clientFactory),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload the single binary: %v", err)
 		}
@@ -1253,7 +1415,7 @@ func TestReleaseAfterBothTravisAndAppVeyorBuildJobs(t *testing.T) {
 			owner string,
 			repo string) Client {
 
-			_, err := collectBuildEventInfo(releaseSuffix, false)
+			_, err := collectBuildEventInfo(releaseSuffix, stdoutLogger{}, false)
 			if err != nil {
 				panic(err)
 			}
@@ -1271,12 +1433,13 @@ func TestReleaseAfterBothTravisAndAppVeyorBuildJobs(t *testing.T) {
 		}
 
 		_, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(clientFactory),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload one of binaries: %v", err)
 		}
@@ -1353,6 +1516,39 @@ func TestReleaseAfterBothTravisAndAppVeyorBuildJobs(t *testing.T) {
 	}
 }
 
+func TestBuildLogWithinReleaseBody(t *testing.T) {
+	info := &BuildInfo{
+		Provider: ProviderTravisCi,
+		Owner:    "d1vanov",
+		Repo:     "ciuploadtool",
+		BuildId:  "123",
+	}
+
+	release := updateBuildLogWithinReleaseBody(
+		&TstRelease{body: "Continuous build\n"}, info)
+	expectedBody := "Continuous build\nTravis CI build log: " +
+		"https://travis-ci.org/d1vanov/ciuploadtool/builds/123/\n"
+	if release.GetBody() != expectedBody {
+		t.Fatalf("Unexpected release body: %q", release.GetBody())
+	}
+
+	// The link to the log of the previous build is replaced
+	info.BuildId = "124"
+	release = updateBuildLogWithinReleaseBody(release, info)
+	expectedBody = strings.Replace(expectedBody, "123", "124", 1)
+	if release.GetBody() != expectedBody {
+		t.Fatalf("Unexpected release body: %q", release.GetBody())
+	}
+
+	// Without the build there is no log to link to
+	info.BuildId = ""
+	release = updateBuildLogWithinReleaseBody(
+		&TstRelease{body: "Release notes"}, info)
+	if release.GetBody() != "Release notes" {
+		t.Fatalf("Unexpected release body: %q", release.GetBody())
+	}
+}
+
 func TestNewNonContinuousReleaseWithSingleUploadedBinaryWithoutSpecifiedSuffix(
 	t *testing.T) {
 
@@ -1391,12 +1587,13 @@ func TestNewNonContinuousReleaseWithSingleUploadedBinaryWithoutSpecifiedSuffix(
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(newTstClient),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload the single binary: %v", err)
 		}
@@ -1467,12 +1664,13 @@ func TestNewContinuousReleaseWithSingleUploadedBinaryWithoutSpecifiedSuffixWitho
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(newTstClient),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload the single binary: %v", err)
 		}
@@ -1538,12 +1736,13 @@ func TestNewContinuousReleaseWithSingleUploadedBinaryWithoutSpecifiedSuffixWithT
 		}
 
 		client, err := uploadImpl(
+			context.Background(),
 			clientFactoryFunc(newTstClient),
-			releaseFactoryFunc(newTstRelease),
+			ReleaseFactory(newTstRelease),
 			[]string{file.Name()},
-			releaseSuffix,
-			releaseBody,
-			false)
+			Options{
+				ReleaseSuffix: releaseSuffix,
+				ReleaseBody:   releaseBody})
 		if err != nil {
 			t.Fatalf("Failed to upload the single binary: %v", err)
 		}
@@ -1573,6 +1772,810 @@ func TestNewContinuousReleaseWithSingleUploadedBinaryWithoutSpecifiedSuffixWithT
 	}
 }
 
+func TestUploadVerificationReuploadsBrokenReleaseAsset(t *testing.T) {
+	binaryContent := "Binary content"
+	file, err := setupSampleAssetFile("singleUploadedBinary.txt", binaryContent)
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the single "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	commit := generateRandomString(16)
+	setupTravisCiEnvVars(
+		commit, "master", "continuous-master", "d1vanov/ciuploadtool", false)
+
+	for _, corruptUploads := range []int{1, maxUploadVerificationAttempts} {
+		clientFactory := func(
+			gitHubToken string,
+			owner string,
+			repo string) Client {
+
+			tstClient := newTstClient(gitHubToken, owner, repo).(*TstClient)
+			tstClient.corruptUploads = corruptUploads
+			return tstClient
+		}
+
+		client, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(clientFactory),
+			ReleaseFactory(newTstRelease),
+			[]string{file.Name()},
+			Options{
+				ReleaseSuffix: "master",
+				Verify:        true})
+
+		if corruptUploads >= maxUploadVerificationAttempts {
+			if err == nil {
+				t.Fatalf("Upload unexpectedly succeeded while all attempts " +
+					"produced broken release assets")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to upload the single binary: %v", err)
+		}
+
+		tstClient := client.(*TstClient)
+		if len(tstClient.releases) != 1 {
+			t.Fatalf("Detected wrong number of releases within client: "+
+				"want 1, have %d", len(tstClient.releases))
+		}
+
+		assets := tstClient.releases[0].GetAssets()
+		if len(assets) != 1 {
+			t.Fatalf("Detected wrong number of release assets: want 1, have %d",
+				len(assets))
+		}
+
+		if assets[0].(TstReleaseAsset).GetContent() != binaryContent {
+			t.Fatalf("The contents of uploaded release asset don't match " +
+				"the original resource file's contents")
+		}
+	}
+}
+
+func TestCancelledUploadLeavesNoPartialReleaseAsset(t *testing.T) {
+	file, err := setupSampleAssetFile("singleUploadedBinary.txt", "Binary content")
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the single "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Simulate SIGINT arriving while the binary is being uploaded
+	clientFactory := func(
+		gitHubToken string,
+		owner string,
+		repo string) Client {
+
+		tstClient := newTstClient(gitHubToken, owner, repo).(*TstClient)
+		tstClient.hooks = map[string]func(){"UploadReleaseAsset": cancel}
+		return tstClient
+	}
+
+	client, err := uploadImpl(
+		ctx,
+		clientFactoryFunc(clientFactory),
+		ReleaseFactory(newTstRelease),
+		[]string{file.Name()},
+		Options{ReleaseSuffix: "master"})
+	if err == nil {
+		t.Fatalf("Cancelled upload unexpectedly succeeded")
+	}
+
+	tstClient := client.(*TstClient)
+	if len(tstClient.releases) != 1 {
+		t.Fatalf("Detected wrong number of releases within client: "+
+			"want 1, have %d", len(tstClient.releases))
+	}
+
+	assets := tstClient.releases[0].GetAssets()
+	if len(assets) != 0 {
+		t.Fatalf("The partially uploaded release asset was not deleted")
+	}
+}
+
+func TestDraftReleasePublishedOnceExpectedAssetsAreUploaded(t *testing.T) {
+	firstFile, err := setupSampleAssetFile(
+		"firstUploadedBinary.txt",
+		"First file content")
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the first "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(firstFile.Name())
+	defer firstFile.Close()
+
+	secondFile, err := setupSampleAssetFile(
+		"secondUploadedBinary.txt",
+		"Second file content")
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the second "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(secondFile.Name())
+	defer secondFile.Close()
+
+	commit := generateRandomString(16)
+	repoSlug := "d1vanov/ciuploadtool"
+
+	// Both build jobs work with the same releases
+	factory := newSharedTstClientFactory()
+
+	expectedAssets := []string{
+		filepath.Base(firstFile.Name()),
+		filepath.Base(secondFile.Name()),
+	}
+
+	for i, file := range []*os.File{firstFile, secondFile} {
+		if i == 0 {
+			setupTravisCiEnvVars(
+				commit, "master", "continuous-master", repoSlug, false)
+		} else {
+			setupAppVeyorCiEnvVars(
+				commit, "master", "continuous-master", repoSlug, false)
+		}
+
+		_, err = uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{file.Name()},
+			Options{
+				ReleaseSuffix:  "master",
+				Draft:          true,
+				ExpectedAssets: expectedAssets})
+		if err != nil {
+			t.Fatalf("Failed to upload the binary: %v", err)
+		}
+
+		if len(factory.client.releases) != 1 {
+			t.Fatalf("Detected wrong number of releases within client: "+
+				"want 1, have %d", len(factory.client.releases))
+		}
+
+		release := factory.client.releases[0]
+		if len(release.GetAssets()) != i+1 {
+			t.Fatalf("Detected wrong number of release assets: want %d, "+
+				"have %d", i+1, len(release.GetAssets()))
+		}
+
+		if i == 0 && !release.GetDraft() {
+			t.Fatalf("The release was published before all expected assets " +
+				"were uploaded")
+		}
+
+		if i == 1 && release.GetDraft() {
+			t.Fatalf("The release was not published after all expected " +
+				"assets were uploaded")
+		}
+	}
+}
+
+func TestFinalizeOfDraftRelease(t *testing.T) {
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	for _, draftCommit := range []string{commit, generateRandomString(16)} {
+		clientFactory := func(
+			gitHubToken string,
+			owner string,
+			repo string) Client {
+
+			tstClient := newTstClient(gitHubToken, owner, repo).(*TstClient)
+			tstClient.releases = append(tstClient.releases, TstRelease{
+				id:              lastFreeReleaseId,
+				name:            "Continuous build (" + tag + ")",
+				tagName:         tag,
+				targetCommitish: draftCommit,
+				isDraft:         true,
+				isPrerelease:    true,
+			})
+			lastFreeReleaseId++
+			return tstClient
+		}
+
+		client, err := finalizeImpl(
+			context.Background(),
+			clientFactoryFunc(clientFactory),
+			Options{ReleaseSuffix: "master"})
+
+		if draftCommit != commit {
+			if err == nil {
+				t.Fatalf("Finalize unexpectedly published the draft release " +
+					"corresponding to another commit")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to finalize the draft release: %v", err)
+		}
+
+		if client.(*TstClient).releases[0].GetDraft() {
+			t.Fatalf("The draft release was not published by finalize")
+		}
+	}
+}
+
+func TestConcurrentReleaseCreationByTwoBuildJobs(t *testing.T) {
+	firstFile, err := setupSampleAssetFile(
+		"firstUploadedBinary.txt",
+		"First file content")
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the first "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(firstFile.Name())
+	defer firstFile.Close()
+
+	secondFile, err := setupSampleAssetFile(
+		"secondUploadedBinary.txt",
+		"Second file content")
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the second "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(secondFile.Name())
+	defer secondFile.Close()
+
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+	releaseSuffix := "master"
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	for _, interleavedMethod := range []string{"CreateRelease", "DeleteRelease"} {
+		factory := newSharedTstClientFactory()
+		factory.setup = func(tstClient *TstClient) {
+			if interleavedMethod == "DeleteRelease" {
+				// Both build jobs would find the release for the previous
+				// commit and would try to recreate it
+				tstClient.releases = append(tstClient.releases, TstRelease{
+					id:              lastFreeReleaseId,
+					name:            "Continuous build (" + tag + ")",
+					tagName:         tag,
+					targetCommitish: generateRandomString(16),
+					isPrerelease:    true,
+				})
+				lastFreeReleaseId++
+				tstClient.tagNames = append(tstClient.tagNames, tag)
+			}
+
+			// The second build job runs entirely at the moment when the first
+			// one is about to call the method
+			tstClient.hooks = map[string]func(){
+				interleavedMethod: func() {
+					_, err := uploadImpl(
+						context.Background(),
+						clientFactoryFunc(factory.create),
+						ReleaseFactory(newTstRelease),
+						[]string{secondFile.Name()},
+						Options{ReleaseSuffix: releaseSuffix})
+					if err != nil {
+						t.Fatalf("Failed to upload the second binary: %v", err)
+					}
+				},
+			}
+		}
+
+		_, err = uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{firstFile.Name()},
+			Options{ReleaseSuffix: releaseSuffix})
+		if err != nil {
+			t.Fatalf("Failed to upload the first binary interleaved at %s: %v",
+				interleavedMethod, err)
+		}
+
+		if len(factory.client.hooks) != 0 {
+			t.Fatalf("The second build job was not interleaved at %s",
+				interleavedMethod)
+		}
+
+		if len(factory.client.releases) != 1 {
+			t.Fatalf("Detected wrong number of releases within client: "+
+				"want 1, have %d", len(factory.client.releases))
+		}
+
+		release := factory.client.releases[0]
+		if release.GetTargetCommitish() != commit {
+			t.Fatalf("Unexpected target commitish for release")
+		}
+
+		if len(release.GetAssets()) != 2 {
+			t.Fatalf("Detected wrong number of release assets: want 2, have %d",
+				len(release.GetAssets()))
+		}
+
+		if len(factory.client.tagNames) != 1 || factory.client.tagNames[0] != tag {
+			t.Fatalf("The tag of the release was lost: %v", factory.client.tagNames)
+		}
+	}
+}
+
+func TestTagOfReleaseRecreatedByAnotherBuildJobIsKept(t *testing.T) {
+	firstFile, err := setupSampleAssetFile(
+		"firstUploadedBinary.txt",
+		"First file content")
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the first "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(firstFile.Name())
+	defer firstFile.Close()
+
+	secondFile, err := setupSampleAssetFile(
+		"secondUploadedBinary.txt",
+		"Second file content")
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the second "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(secondFile.Name())
+	defer secondFile.Close()
+
+	commit := generateRandomString(16)
+	tag := "continuous-master"
+	releaseSuffix := "master"
+	setupTravisCiEnvVars(commit, "master", tag, "d1vanov/ciuploadtool", false)
+
+	factory := newSharedTstClientFactory()
+	factory.setup = func(tstClient *TstClient) {
+		tstClient.releases = append(tstClient.releases, TstRelease{
+			id:              lastFreeReleaseId,
+			name:            "Continuous build (" + tag + ")",
+			tagName:         tag,
+			targetCommitish: generateRandomString(16),
+			isPrerelease:    true,
+		})
+		lastFreeReleaseId++
+		tstClient.tagNames = append(tstClient.tagNames, tag)
+
+		// After deleting the release for the previous commit the first build
+		// job looks for the release recreated by other build jobs and finds
+		// none; the second build job runs entirely right after that, before
+		// the first one deletes the tag
+		runSecondBuildJob := func() {
+			_, err := uploadImpl(
+				context.Background(),
+				clientFactoryFunc(factory.create),
+				ReleaseFactory(newTstRelease),
+				[]string{secondFile.Name()},
+				Options{ReleaseSuffix: releaseSuffix})
+			if err != nil {
+				t.Fatalf("Failed to upload the second binary: %v", err)
+			}
+		}
+		tstClient.hooks = map[string]func(){
+			"DeleteRelease": func() {
+				tstClient.hooks["GetReleaseByTag"] = func() {
+					tstClient.hooks["GetReleaseByTag"] = runSecondBuildJob
+				}
+			},
+		}
+	}
+
+	_, err = uploadImpl(
+		context.Background(),
+		clientFactoryFunc(factory.create),
+		ReleaseFactory(newTstRelease),
+		[]string{firstFile.Name()},
+		Options{ReleaseSuffix: releaseSuffix})
+	if err != nil {
+		t.Fatalf("Failed to upload the first binary: %v", err)
+	}
+
+	if len(factory.client.hooks) != 0 {
+		t.Fatalf("The second build job was not interleaved")
+	}
+
+	if len(factory.client.releases) != 1 {
+		t.Fatalf("Detected wrong number of releases within client: "+
+			"want 1, have %d", len(factory.client.releases))
+	}
+
+	release := factory.client.releases[0]
+	if release.GetTargetCommitish() != commit {
+		t.Fatalf("Unexpected target commitish for release")
+	}
+
+	if len(release.GetAssets()) != 2 {
+		t.Fatalf("Detected wrong number of release assets: want 2, have %d",
+			len(release.GetAssets()))
+	}
+
+	if len(factory.client.tagNames) != 1 || factory.client.tagNames[0] != tag {
+		t.Fatalf("The tag of the release was lost: %v", factory.client.tagNames)
+	}
+}
+
+func TestUploaderWithExplicitOptions(t *testing.T) {
+	binaryContent := "Binary content"
+	file, err := setupSampleAssetFile("singleUploadedBinary.txt", binaryContent)
+	if err != nil {
+		t.Fatalf("Failed to create the temporary file representing the single "+
+			"uploaded binary: %v", err)
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// No CI environment is required when the build info is given explicitly
+	os.Unsetenv("TRAVIS")
+	os.Unsetenv("APPVEYOR")
+
+	info := BuildInfo{
+		Token:        "fake_token",
+		Tag:          "v1.0.0",
+		Commit:       generateRandomString(16),
+		Owner:        "d1vanov",
+		Repo:         "ciuploadtool",
+		IsPrerelease: false,
+	}
+
+	tstClient := newTstClient(info.Token, info.Owner, info.Repo).(*TstClient)
+	logger := tstLogger{}
+
+	var preparedRelease Release
+	var uploadedFilenames []string
+
+	uploader, err := New(Options{
+		Client:               tstClient,
+		ReleaseFactory:       newTstRelease,
+		BuildInfo:            &info,
+		ReleaseTitleTemplate: "Release {{.Tag}}",
+		ReleaseBodyTemplate:  "Built from {{.Commit}}",
+		Hooks: Hooks{
+			ReleasePrepared: func(release Release) error {
+				preparedRelease = release
+				return nil
+			},
+			AfterUpload: func(filename string, asset ReleaseAsset) error {
+				uploadedFilenames = append(uploadedFilenames, filename)
+				return nil
+			},
+		},
+		Logger: &logger,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the uploader: %v", err)
+	}
+
+	err = uploader.Upload(context.Background(), []string{file.Name()})
+	if err != nil {
+		t.Fatalf("Failed to upload the single binary: %v", err)
+	}
+
+	result := uploader.Result()
+	if result.Skipped || !result.Created || result.Release == nil {
+		t.Fatalf("Unexpected upload result: %+v", result)
+	}
+
+	if preparedRelease == nil || preparedRelease.GetID() != result.Release.GetID() {
+		t.Fatalf("The release prepared hook was not called for the release")
+	}
+
+	if len(uploadedFilenames) != 1 || uploadedFilenames[0] != file.Name() {
+		t.Fatalf("Unexpected files reported to the upload hook: %v",
+			uploadedFilenames)
+	}
+
+	if len(result.Assets) != 1 ||
+		result.Assets[0].GetName() != filepath.Base(file.Name()) {
+		t.Fatalf("Unexpected release assets within the result: %v",
+			result.Assets)
+	}
+
+	if len(tstClient.releases) != 1 {
+		t.Fatalf("Detected wrong number of releases within client: want 1, "+
+			"have %d", len(tstClient.releases))
+	}
+
+	release := tstClient.releases[0]
+	if release.GetName() != "Release v1.0.0" {
+		t.Fatalf("Unexpected release title: %s", release.GetName())
+	}
+
+	if release.GetBody() != "Built from "+info.Commit {
+		t.Fatalf("Unexpected release body: %s", release.GetBody())
+	}
+
+	if len(logger.messages) == 0 {
+		t.Fatalf("Nothing was logged through the custom logger")
+	}
+}
+
+func TestUploadOfGeneratedContentAndStdin(t *testing.T) {
+	os.Unsetenv("TRAVIS")
+	os.Unsetenv("APPVEYOR")
+
+	info := BuildInfo{
+		Token:        "fake_token",
+		Tag:          "v1.0.0",
+		Commit:       generateRandomString(16),
+		Owner:        "d1vanov",
+		Repo:         "ciuploadtool",
+		ReleaseTitle: "Release build (v1.0.0)",
+	}
+
+	tstClient := newTstClient(info.Token, info.Owner, info.Repo).(*TstClient)
+	tstClient.corruptUploads = 1
+
+	uploader, err := New(Options{
+		Client:         tstClient,
+		ReleaseFactory: newTstRelease,
+		BuildInfo:      &info,
+		StdinName:      "stdin.json",
+		Verify:         true,
+		Logger:         &tstLogger{},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the uploader: %v", err)
+	}
+
+	// The corrupted first upload has to be re-uploaded from the same reader
+	manifestContent := "Checksum manifest content"
+	_, err = uploader.UploadAsset(context.Background(), AssetUpload{
+		Name:        "SHA256SUMS",
+		ContentType: "text/plain",
+		Size:        int64(len(manifestContent)),
+		Content:     strings.NewReader(manifestContent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to upload the generated content: %v", err)
+	}
+
+	stdinContent := `{"content": "read from stdin"}`
+	uploader.stdin = strings.NewReader(stdinContent)
+	err = uploader.Upload(context.Background(), []string{"-"})
+	if err != nil {
+		t.Fatalf("Failed to upload the content of stdin: %v", err)
+	}
+
+	assets := tstClient.releases[0].GetAssets()
+	if len(assets) != 2 {
+		t.Fatalf("Detected wrong number of release assets: want 2, have %d",
+			len(assets))
+	}
+
+	expectedAssets := map[string]TstReleaseAsset{
+		"SHA256SUMS": {
+			content:     manifestContent,
+			contentType: "text/plain",
+		},
+		"stdin.json": {
+			content:     stdinContent,
+			contentType: "application/json",
+		},
+	}
+
+	for _, asset := range assets {
+		tstAsset := asset.(TstReleaseAsset)
+		expectedAsset, ok := expectedAssets[tstAsset.GetName()]
+		if !ok {
+			t.Fatalf("Unexpected release asset: %s", tstAsset.GetName())
+		}
+
+		if tstAsset.GetContent() != expectedAsset.content ||
+			tstAsset.contentType != expectedAsset.contentType {
+			t.Fatalf("Unexpected content of release asset %s: %s (%s)",
+				tstAsset.GetName(), tstAsset.GetContent(), tstAsset.contentType)
+		}
+	}
+}
+
+func TestRecursiveUploadOfDirectory(t *testing.T) {
+	dir, err := setupSampleAssetDir(map[string]string{
+		"linux/app.AppImage":      "Linux binary",
+		"linux/debug/app.debug":   "Linux debug symbols",
+		"windows/app.zip":         "Windows binary",
+		"windows/tmp/scratch.zip": "Temporary file",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the sample dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		separator      string
+		expectedAssets map[string]string
+	}{
+		{"", map[string]string{
+			"app.AppImage": "Linux binary",
+			"app.zip":      "Windows binary",
+		}},
+		{"_", map[string]string{
+			"linux_app.AppImage": "Linux binary",
+			"windows_app.zip":    "Windows binary",
+		}},
+	}
+
+	for _, testCase := range testCases {
+		setupTravisCiEnvVars(generateRandomString(16), "master",
+			"continuous-master", "d1vanov/ciuploadtool", false)
+
+		client, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(newTstClient),
+			ReleaseFactory(newTstRelease),
+			[]string{dir},
+			Options{
+				ReleaseSuffix:      "master",
+				Recursive:          true,
+				Include:            []string{"*.AppImage", "*.zip"},
+				Exclude:            []string{"**/tmp/**"},
+				AssetNameSeparator: testCase.separator,
+			})
+		if err != nil {
+			t.Fatalf("Failed to upload the dir: %v", err)
+		}
+
+		assets := client.(*TstClient).releases[0].GetAssets()
+		if len(assets) != len(testCase.expectedAssets) {
+			t.Fatalf("Detected wrong number of release assets: want %d, "+
+				"have %d", len(testCase.expectedAssets), len(assets))
+		}
+
+		for _, asset := range assets {
+			tstAsset := asset.(TstReleaseAsset)
+			expectedContent, ok := testCase.expectedAssets[tstAsset.GetName()]
+			if !ok {
+				t.Fatalf("Unexpected release asset: %s", tstAsset.GetName())
+			}
+
+			if tstAsset.GetContent() != expectedContent {
+				t.Fatalf("The contents of release asset %s don't match "+
+					"the original file's contents", tstAsset.GetName())
+			}
+		}
+	}
+}
+
+func TestAssetNameCollisionIsDetectedBeforeUpload(t *testing.T) {
+	dir, err := setupSampleAssetDir(map[string]string{
+		"linux/app.zip":   "Linux binary",
+		"windows/app.zip": "Windows binary",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the sample dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+
+	clientCreated := false
+	clientFactory := func(
+		gitHubToken string,
+		owner string,
+		repo string) Client {
+
+		clientCreated = true
+		return newTstClient(gitHubToken, owner, repo)
+	}
+
+	_, err = uploadImpl(
+		context.Background(),
+		clientFactoryFunc(clientFactory),
+		ReleaseFactory(newTstRelease),
+		[]string{dir},
+		Options{ReleaseSuffix: "master", Recursive: true})
+	if err == nil {
+		t.Fatalf("Upload of files with colliding asset names unexpectedly " +
+			"succeeded")
+	}
+
+	if clientCreated {
+		t.Fatalf("The release was touched despite the asset name collision")
+	}
+}
+
+func TestGeneratedAssetNameCollisionIsDetectedBeforeUpload(t *testing.T) {
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+
+	testCases := []struct {
+		name     string
+		options  Options
+		reserved bool
+	}{
+		{"app.zip.zsync", Options{Zsync: true}, true},
+		{"app.zip.part1", Options{SplitSize: 1 << 20}, true},
+		{"app.zip.parts.sha256", Options{SplitSize: 1 << 20}, true},
+		{"app.zip.1234567-89abcde.patch", Options{DeltaPatches: true}, true},
+		{"app.zip" + lockAssetSuffix, Options{}, true},
+		{"app.zip" + replacementAssetSuffix, Options{}, true},
+		{"latest.json", Options{UpdateFeed: &UpdateFeedConfig{
+			Platforms: map[string]string{"linux": "*.AppImage"}}}, true},
+		{"appcast.xml", Options{Appcast: &AppcastConfig{}}, true},
+		{sbomName, Options{SBOM: true}, true},
+		{"ciuploadtool.json", Options{Scoop: &ScoopConfig{
+			Pattern: "*.zip"}}, true},
+		{"myapp.rb", Options{Homebrew: &HomebrewConfig{
+			Name: "myapp", Pattern: "*.dmg", Bin: []string{"myapp"}}}, true},
+		{"MyApp-x86_64.AppImage.zsync", Options{}, false},
+		{"app.zip.part1", Options{}, false},
+		{"app.zip.1234567-89abcde.patch", Options{}, false},
+		{"latest.json", Options{}, false},
+		{"appcast.xml", Options{}, false},
+	}
+
+	for _, testCase := range testCases {
+		dir := t.TempDir()
+		writeSampleFiles(t, dir, map[string]string{testCase.name: "Content"})
+
+		factory := newSharedTstClientFactory()
+		options := testCase.options
+		options.ReleaseSuffix = "master"
+		_, err := uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{filepath.Join(dir, testCase.name)},
+			options)
+
+		if !testCase.reserved {
+			if err != nil {
+				t.Fatalf("Failed to upload %s: %v", testCase.name, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), "reserved") {
+			t.Fatalf("Upload of %s colliding with the generated asset "+
+				"didn't fail as expected: %v", testCase.name, err)
+		}
+
+		if factory.client != nil && len(factory.client.releases) != 0 {
+			t.Fatalf("The release was touched despite the collision of %s "+
+				"with the generated asset", testCase.name)
+		}
+	}
+}
+
+func setupSampleAssetDir(files map[string]string) (string, error) {
+	dir, err := ioutil.TempDir("", "ciuploadtool")
+	if err != nil {
+		return "", err
+	}
+
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filename, []byte(content), 0644)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
 func setupSampleAssetFile(filename, content string) (*os.File, error) {
 	file, err := ioutil.TempFile("", "singleUploadedBinary.txt")
 	if err != nil {
@@ -1640,3 +2643,11 @@ func generateRandomString(numChars int) string {
 	}
 	return string(b)
 }
+
+type tstLogger struct {
+	messages []string
+}
+
+func (logger *tstLogger) Printf(format string, args ...interface{}) {
+	logger.messages = append(logger.messages, fmt.Sprintf(format, args...))
+}
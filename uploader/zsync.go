@@ -0,0 +1,205 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Suffix of the zsync metadata uploaded next to AppImages
+const zsyncSuffix = ".zsync"
+
+// Version of zsync whose file format is produced
+const zsyncVersion = "0.6.2"
+
+func isZsyncCandidate(source *assetSource) bool {
+	return strings.HasSuffix(source.name, ".AppImage") &&
+		len(source.splitFrom) == 0
+}
+
+// Block size picked the way zsyncmake does
+func zsyncBlockSize(length int64) int {
+	if length < 100000000 {
+		return 2048
+	}
+	return 4096
+}
+
+// Number of consecutive matching blocks zsync requires and the lengths of
+// the rolling and the strong checksums of blocks stored within the file,
+// computed with the heuristics of zsyncmake
+func zsyncHashLengths(length int64, blockSize int) (int, int, int) {
+	if length < 1 {
+		length = 1
+	}
+
+	seqMatches := 1
+	if length > int64(blockSize) {
+		seqMatches = 2
+	}
+
+	logLength := math.Log(float64(length))
+	rsumLength := int(math.Ceil(((logLength+math.Log(float64(blockSize)))/
+		math.Ln2 - 8.6) / float64(seqMatches) / 8))
+	if rsumLength > 4 {
+		rsumLength = 4
+	}
+	if rsumLength < 2 {
+		rsumLength = 2
+	}
+
+	blocks := float64(1 + length/int64(blockSize))
+	checksumLength := int(math.Ceil((20 + (logLength+math.Log(blocks))/
+		math.Ln2) / float64(seqMatches) / 8))
+	minChecksumLength := int((7.9 + (20 + math.Log(blocks)/math.Ln2)) / 8)
+	if checksumLength < minChecksumLength {
+		checksumLength = minChecksumLength
+	}
+	if checksumLength > 16 {
+		checksumLength = 16
+	}
+
+	return seqMatches, rsumLength, checksumLength
+}
+
+// Rolling checksum of the block as computed by zsync
+func zsyncRsum(block []byte) (uint16, uint16) {
+	var a, b uint16
+	for i, char := range block {
+		a += uint16(char)
+		b += uint16(len(block)-i) * uint16(char)
+	}
+	return a, b
+}
+
+// Produces the zsync file for the content of the given length to be
+// downloaded from the URL
+func zsyncFile(
+	name string,
+	url string,
+	content io.Reader,
+	length int64) ([]byte, error) {
+
+	blockSize := zsyncBlockSize(length)
+	seqMatches, rsumLength, checksumLength := zsyncHashLengths(
+		length, blockSize)
+
+	// The last block is padded with zeros
+	var sums bytes.Buffer
+	wholeHash := sha1.New()
+	block := make([]byte, blockSize)
+	var read int64
+	for {
+		n, err := io.ReadFull(content, block)
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		wholeHash.Write(block[:n])
+		read += int64(n)
+		for i := n; i < blockSize; i++ {
+			block[i] = 0
+		}
+
+		// The trailing bytes of the rolling checksum are stored since its
+		// second half is more useful for hashing
+		var rsum [4]byte
+		a, b := zsyncRsum(block)
+		binary.BigEndian.PutUint16(rsum[:], a)
+		binary.BigEndian.PutUint16(rsum[2:], b)
+		sums.Write(rsum[4-rsumLength:])
+
+		checksum := md4Sum(block)
+		sums.Write(checksum[:checksumLength])
+
+		if n < blockSize {
+			break
+		}
+	}
+
+	if read != length {
+		return nil, fmt.Errorf("Size of %s changed while generating zsync "+
+			"file: expected %d, got %d", name, length, read)
+	}
+
+	var file bytes.Buffer
+	fmt.Fprintf(&file, "zsync: %s\n", zsyncVersion)
+	fmt.Fprintf(&file, "Filename: %s\n", name)
+	fmt.Fprintf(&file, "Blocksize: %d\n", blockSize)
+	fmt.Fprintf(&file, "Length: %d\n", length)
+	fmt.Fprintf(&file, "Hash-Lengths: %d,%d,%d\n", seqMatches, rsumLength,
+		checksumLength)
+	fmt.Fprintf(&file, "URL: %s\n", url)
+	fmt.Fprintf(&file, "SHA-1: %s\n\n", hex.EncodeToString(wholeHash.Sum(nil)))
+	file.Write(sums.Bytes())
+	return file.Bytes(), nil
+}
+
+// The zsync files point at the stable latest/download path of the repo
+// since AppImageUpdate fetches them from there. GitHub doesn't resolve
+// the path into prereleases so the files of continuous releases point at
+// the release's own tag.
+func (uploader *Uploader) zsyncURL(name string) string {
+	owner := uploader.client.GetOwner()
+	repo := uploader.client.GetRepo()
+	if uploader.info.IsPrerelease {
+		return releaseAssetDownloadURL(owner, repo, uploader.info.Tag, name)
+	}
+
+	return fmt.Sprintf("https://github.com/%s/%s/releases/latest/download/%s",
+		owner, repo, name)
+}
+
+// Adds the zsync file after each AppImage among the sources. The AppImage
+// owns its zsync file so the stale one is deleted along with the duplicate
+// AppImage.
+func (uploader *Uploader) addZsyncSources(
+	sources []*assetSource) ([]*assetSource, error) {
+
+	names := make(map[string]bool)
+	for _, source := range sources {
+		names[source.name] = true
+	}
+
+	var allSources []*assetSource
+	for _, source := range sources {
+		allSources = append(allSources, source)
+		if !isZsyncCandidate(source) {
+			continue
+		}
+
+		zsyncName := source.name + zsyncSuffix
+		if names[zsyncName] {
+			return nil, fmt.Errorf("Release asset %s is both uploaded and "+
+				"generated from %s", zsyncName, source.filename)
+		}
+
+		uploader.logger.Printf("Generating zsync file for %s\n", source.name)
+		content, err := source.open()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := zsyncFile(source.name, uploader.zsyncURL(source.name),
+			content, source.size)
+		content.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		zsyncSource := memoryAssetSource(source.filename, zsyncName, data)
+		zsyncSource.contentType = "application/x-zsync"
+		source.companions = append(source.companions, zsyncName)
+		allSources = append(allSources, zsyncSource)
+	}
+
+	return allSources, nil
+}
@@ -0,0 +1,133 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMd4(t *testing.T) {
+	// Test suite of RFC 1320
+	for message, expected := range map[string]string{
+		"":                           "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"a":                          "bde52cb31de33e46245e05fbdbd6fb24",
+		"abc":                        "a448017aaf21d8525fc10ae87aa6729d",
+		"message digest":             "d9130a8164549fe818874806e1c7014b",
+		"abcdefghijklmnopqrstuvwxyz": "d79e1c308aa5bbcdeea8ed63df412da9",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	} {
+		sum := md4Sum([]byte(message))
+		if hex.EncodeToString(sum[:]) != expected {
+			t.Fatalf("Unexpected MD4 of %q: %x", message, sum)
+		}
+	}
+}
+
+func TestZsyncFile(t *testing.T) {
+	a, b := zsyncRsum([]byte{1, 2, 3})
+	if a != 6 || b != 10 {
+		t.Fatalf("Unexpected rolling checksum: %d, %d", a, b)
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), 500)
+	url := "https://github.com/d1vanov/ciuploadtool/releases/latest/" +
+		"download/app.AppImage"
+	file, err := zsyncFile("app.AppImage", url, bytes.NewReader(content),
+		int64(len(content)))
+	if err != nil {
+		t.Fatalf("Failed to generate zsync file: %v", err)
+	}
+
+	separator := bytes.Index(file, []byte("\n\n"))
+	if separator < 0 {
+		t.Fatalf("The zsync file has no header: %q", file)
+	}
+
+	seqMatches, rsumLength, checksumLength := zsyncHashLengths(
+		int64(len(content)), 2048)
+	sha1Sum := sha1.Sum(content)
+	header := string(file[:separator+1])
+	for _, line := range []string{
+		"zsync: 0.6.2\n",
+		"Filename: app.AppImage\n",
+		"Blocksize: 2048\n",
+		"Length: 5000\n",
+		"URL: " + url + "\n",
+		"SHA-1: " + hex.EncodeToString(sha1Sum[:]) + "\n",
+	} {
+		if !strings.Contains(header, line) {
+			t.Fatalf("Line %q is missing within zsync header: %s", line,
+				header)
+		}
+	}
+
+	if seqMatches != 2 || rsumLength < 2 || checksumLength < 3 {
+		t.Fatalf("Unexpected hash lengths: %d, %d, %d", seqMatches,
+			rsumLength, checksumLength)
+	}
+
+	// The last of three blocks is padded with zeros
+	sums := file[separator+2:]
+	if len(sums) != 3*(rsumLength+checksumLength) {
+		t.Fatalf("Unexpected size of block checksums: %d", len(sums))
+	}
+
+	lastBlock := make([]byte, 2048)
+	copy(lastBlock, content[4096:])
+	checksum := md4Sum(lastBlock)
+	if !bytes.HasSuffix(sums, checksum[:checksumLength]) {
+		t.Fatalf("Unexpected checksum of the last block")
+	}
+}
+
+func TestZsyncFileIsReplacedAlongWithAppImage(t *testing.T) {
+	dir := t.TempDir()
+
+	factory := newSharedTstClientFactory()
+
+	setupTravisCiEnvVars(generateRandomString(16), "master",
+		"continuous-master", "d1vanov/ciuploadtool", false)
+
+	filename := filepath.Join(dir, "app.AppImage")
+	for _, content := range []string{"first build", "second build"} {
+		err := ioutil.WriteFile(filename, []byte(content), 0755)
+		if err != nil {
+			t.Fatalf("Failed to write the sample file: %v", err)
+		}
+
+		_, err = uploadImpl(
+			context.Background(),
+			clientFactoryFunc(factory.create),
+			ReleaseFactory(newTstRelease),
+			[]string{filename},
+			Options{ReleaseSuffix: "master", Zsync: true})
+		if err != nil {
+			t.Fatalf("Failed to upload the file: %v", err)
+		}
+
+		assets := factory.client.releases[0].GetAssets()
+		if len(assets) != 2 || assets[1].GetName() != "app.AppImage.zsync" {
+			t.Fatalf("Unexpected release assets: %v", assets)
+		}
+
+		sha1Sum := sha1.Sum([]byte(content))
+		zsync := assets[1].(TstReleaseAsset).GetContent()
+		if !strings.Contains(zsync, "SHA-1: "+
+			hex.EncodeToString(sha1Sum[:])+"\n") {
+			t.Fatalf("The zsync file doesn't correspond to the AppImage: %s",
+				zsync)
+		}
+
+		// Continuous releases are prereleases which latest/download path
+		// doesn't resolve into
+		if !strings.Contains(zsync, "URL: https://github.com/d1vanov/"+
+			"ciuploadtool/releases/download/continuous-master/app.AppImage\n") {
+			t.Fatalf("Unexpected URL within the zsync file: %s", zsync)
+		}
+	}
+}